@@ -2,7 +2,6 @@ package main
 
 import (
 	"flag"
-	"fmt"
 	"os"
 	"time"
 
@@ -11,6 +10,8 @@ import (
 	"github.com/Azure/azure-sdk-for-go/arm/network"
 	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/credentials"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/redact"
 	"github.com/spf13/pflag"
 )
 
@@ -29,6 +30,11 @@ var (
 	clientSecret   = flags.String("clientSecret", "", "Azure client secret key")
 	region         = flags.String("region", "", "Azure region that hosts the Kubernetes cluster (e.g. westus, southcentralasia, etc.)")
 	resourceGroup  = flags.String("resourceGroup", "", "Azure resource group that hosts the Kubernetes cluster")
+
+	azureConfigFile = flags.String("azure-config-file", "", "Path of an azure.json file, flags and AZURE_* environment variables override its values")
+
+	cloud                = flags.String("cloud", "", "Azure cloud: AzurePublicCloud, AzureChinaCloud, AzureUSGovernmentCloud or AzureGermanCloud")
+	cloudEnvironmentFile = flags.String("cloudEnvironmentFile", "", "JSON file holding the endpoints of a custom Azure cloud, it takes precedence over --cloud")
 )
 
 //this is main
@@ -39,39 +45,70 @@ func main() {
 	//https://github.com/kubernetes/kubernetes/issues/17162
 	flag.CommandLine.Parse([]string{})
 
-	fmt.Printf("TenantID: %s \nclientID: %s \nsecret: %s \nsubscription: %s \nregion: %s \nresourceGroup: %s \n",
-		*tenantID, *clientID, *clientSecret, *subscriptionID, *region, *resourceGroup)
+	loader := credentials.Loader{
+		Flags: credentials.Config{
+			TenantID:       *tenantID,
+			SubscriptionID: *subscriptionID,
+			ClientID:       *clientID,
+			ClientSecret:   *clientSecret,
+			Region:         *region,
+			ResourceGroup:  *resourceGroup,
+
+			Cloud:                *cloud,
+			CloudEnvironmentFile: *cloudEnvironmentFile,
+		},
+		File: *azureConfigFile,
+	}
+	config, err := loader.Load()
+	if err != nil {
+		redact.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	if config.ClientSecret == "" {
+		redact.Printf("the sample only authenticates with a client secret, certificates and managed identities require the ingress controller\n")
+		os.Exit(1)
+	}
+
+	redact.Printf("TenantID: %s \nclientID: %s \nsecret: %s \nsubscription: %s \nregion: %s \nresourceGroup: %s \n",
+		config.TenantID, config.ClientID, redact.Mask, config.SubscriptionID, config.Region, config.ResourceGroup)
+
+	environment := azure.Environment{}
+	err = config.CloudEnvironment(&environment, func(name string) (interface{}, error) { return azure.EnvironmentFromName(name) })
+	if err != nil {
+		redact.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	redact.Printf("cloud: %s \n", environment.Name)
 
-	oauthConfig, err := azure.PublicCloud.OAuthConfigForTenant(*tenantID)
+	oauthConfig, err := environment.OAuthConfigForTenant(config.TenantID)
 	if err != nil {
 		return
 	}
 
 	servicePrincipalToken, err := azure.NewServicePrincipalToken(
 		*oauthConfig,
-		*clientID,
-		*clientSecret,
-		azure.PublicCloud.ServiceManagementEndpoint)
+		config.ClientID,
+		config.ClientSecret,
+		environment.ServiceManagementEndpoint)
 
 	if err != nil {
-		fmt.Println("Kaboom!")
+		redact.Printf("Kaboom!\n")
 	} else {
-		fmt.Printf("Got a service principal\n")
-		fmt.Println(servicePrincipalToken)
+		redact.Printf("Got a service principal\n")
+		redact.Printf("%v\n", redact.Value(servicePrincipalToken))
 	}
 
-	gatewayClient := network.NewApplicationGatewaysClient(*subscriptionID)
-	gatewayClient.BaseURI = azure.PublicCloud.ResourceManagerEndpoint
+	gatewayClient := network.NewApplicationGatewaysClientWithBaseURI(environment.ResourceManagerEndpoint, config.SubscriptionID)
 	gatewayClient.Authorizer = servicePrincipalToken
 
 	gatewayList := getGatewayList(gatewayClient)
 
 	for i, k := range *gatewayList {
-		fmt.Printf("i is: %b\n", i)
-		fmt.Printf("v is: %s\n", *k.Name)
+		redact.Printf("i is: %b\n", i)
+		redact.Printf("v is: %s\n", *k.Name)
 	}
 
-	createPublicIP(*subscriptionID, *resourceGroup, servicePrincipalToken)
+	createPublicIP(environment, config.SubscriptionID, config.ResourceGroup, servicePrincipalToken)
 }
 
 //GatewayClient interface has been added to support unit testing
@@ -84,7 +121,7 @@ func getGatewayList(client GatewayClient) *[]network.ApplicationGateway {
 	gateways, err := client.ListAll()
 
 	if err != nil {
-		fmt.Printf("Error!!\n%s", err)
+		redact.Printf("Error!!\n%s", redact.Value(err))
 	} else {
 		value = gateways.Value
 	}
@@ -92,8 +129,8 @@ func getGatewayList(client GatewayClient) *[]network.ApplicationGateway {
 	return value
 }
 
-func createPublicIP(subscriptionID, resourceGroup string, servicePrincipalToken autorest.Authorizer) {
-	ipClient := network.NewPublicIPAddressesClient(subscriptionID)
+func createPublicIP(environment azure.Environment, subscriptionID, resourceGroup string, servicePrincipalToken autorest.Authorizer) {
+	ipClient := network.NewPublicIPAddressesClientWithBaseURI(environment.ResourceManagerEndpoint, subscriptionID)
 	ipClient.Authorizer = servicePrincipalToken
 
 	name := "testPIPCreate"
@@ -108,14 +145,14 @@ func createPublicIP(subscriptionID, resourceGroup string, servicePrincipalToken
 	result, err := ipClient.CreateOrUpdate(resourceGroup, name, params, nil)
 
 	if err != nil {
-		fmt.Printf("[AZURE] Failed to create Public IP: %v", err)
-		fmt.Printf("Result: %v\n", result)
+		redact.Printf("[AZURE] Failed to create Public IP: %v", redact.Value(err))
+		redact.Printf("Result: %v\n", redact.Value(result))
 	}
 
 	var p []byte
 	n, err2 := result.Response.Body.Read(p)
 
-	fmt.Printf("length of response was %v\n", n)
-	fmt.Printf("err2 is %v\n", err2)
-	fmt.Printf("content of response was %v\n", p)
+	redact.Printf("length of response was %v\n", n)
+	redact.Printf("err2 is %v\n", err2)
+	redact.Printf("content of response was %v\n", p)
 }
@@ -19,7 +19,7 @@ func (t testGatewayClientFail) ListAll() (network.ApplicationGatewayListResult,
 
 var _ GatewayClient = (*testGatewayClientFail)(nil)
 
-func ExampleGatewayListGet() {
+func Example_getGatewayList() {
 	client := testGatewayClientFail{}
 	getGatewayList(client)
 	//Output:
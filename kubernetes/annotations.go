@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/arm/network"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/azurecontroller"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/logging"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+const (
+	cookieBasedAffinityKey  = "azure.ingress.kubernetes.io/cookie-based-affinity"
+	requestTimeoutKey       = "azure.ingress.kubernetes.io/backend-request-timeout"
+	backendProtocolKey      = "azure.ingress.kubernetes.io/backend-protocol"
+	sslDisabledProtocolsKey = "azure.ingress.kubernetes.io/ssl-disabled-protocols"
+	skuNameKey              = "azure.ingress.kubernetes.io/sku-name"
+	skuTierKey              = "azure.ingress.kubernetes.io/sku-tier"
+	capacityKey             = "azure.ingress.kubernetes.io/capacity"
+	gatewayNameKey          = "azure.ingress.kubernetes.io/gateway-name"
+
+	// assignedGatewayKey records the shared gateway the controller placed an
+	// ingress on, it is written by the controller and not meant to be edited
+	assignedGatewayKey = "azure.ingress.kubernetes.io/assigned-gateway"
+
+	// limits Azure enforces on the annotated values
+	maxRequestTimeout     = 86400
+	maxProbeSeconds       = 86400
+	maxUnhealthyThreshold = 20
+	maxCapacity           = 10
+	maxGatewayNameLength  = 80
+)
+
+// gatewayNamePattern matches the names Azure accepts for application gateways.
+var gatewayNamePattern = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9_.-]*[a-zA-Z0-9_])?$`)
+
+var (
+	sslProtocols = []string{string(network.TLSv10), string(network.TLSv11), string(network.TLSv12)}
+	skuNames     = []string{string(network.StandardSmall), string(network.StandardMedium), string(network.StandardLarge)}
+	skuTiers     = []string{string(network.Standard)}
+)
+
+// gatewayAnnotations is the validated form of the annotations tuning the
+// gateway of an ingress.
+type gatewayAnnotations struct {
+	settings azurecontroller.GatewaySettings
+	probe    probeOverrides
+}
+
+// backendProtocol returns the protocol the gateway uses to reach the backends.
+func (parsed gatewayAnnotations) backendProtocol(annotations ingressAnnotations) network.ApplicationGatewayProtocol {
+	if parsed.settings.BackendProtocol != "" {
+		return parsed.settings.BackendProtocol
+	}
+	if annotations.backendCA() != "" {
+		return network.HTTPS
+	}
+	return network.HTTP
+}
+
+// annotationParser collects the errors of every invalid annotation so they can
+// all be reported at once.
+type annotationParser struct {
+	annotations ingressAnnotations
+	errors      []error
+}
+
+func (parser *annotationParser) invalid(key, value, expected string) {
+	parser.errors = append(parser.errors, fmt.Errorf("invalid %v %q, expected %v", key, value, expected))
+}
+
+func (parser *annotationParser) bool(key string) bool {
+	value, ok := parser.annotations[key]
+	if !ok {
+		return false
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		parser.invalid(key, value, "true or false")
+		return false
+	}
+	return parsed
+}
+
+// int32 parses a number between 1 and max, zero means the annotation is unset
+// or invalid.
+func (parser *annotationParser) int32(key string, max int64) int32 {
+	value, ok := parser.annotations[key]
+	if !ok {
+		return 0
+	}
+	parsed, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || parsed < 1 || parsed > max {
+		parser.invalid(key, value, fmt.Sprintf("a number between 1 and %d", max))
+		return 0
+	}
+	return int32(parsed)
+}
+
+// oneOf returns the allowed value an annotation matches, ignoring case.
+func (parser *annotationParser) oneOf(key string, allowed []string) (string, bool) {
+	value, ok := parser.annotations[key]
+	if !ok {
+		return "", false
+	}
+	if match, ok := matchOneOf(value, allowed); ok {
+		return match, true
+	}
+	parser.invalid(key, value, "one of "+strings.Join(allowed, ", "))
+	return "", false
+}
+
+func matchOneOf(value string, allowed []string) (string, bool) {
+	for _, candidate := range allowed {
+		if strings.EqualFold(strings.TrimSpace(value), candidate) {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// parseGatewayAnnotations validates the gateway annotations of an ingress.
+// Invalid annotations keep their default and are returned as errors.
+func parseGatewayAnnotations(annotations ingressAnnotations) (gatewayAnnotations, []error) {
+	parser := &annotationParser{annotations: annotations}
+	parsed := gatewayAnnotations{}
+	settings := &parsed.settings
+
+	settings.CookieBasedAffinity = parser.bool(cookieBasedAffinityKey)
+	settings.RequestTimeout = parser.int32(requestTimeoutKey, maxRequestTimeout)
+
+	// Azure only accepts HTTPS backends it has authentication certificates for
+	if protocol, ok := parser.oneOf(backendProtocolKey, []string{string(network.HTTP), string(network.HTTPS)}); ok {
+		switch {
+		case protocol == string(network.HTTPS) && annotations.backendCA() == "":
+			parser.errors = append(parser.errors, fmt.Errorf("%v %v requires the backend certificates of %v", backendProtocolKey, protocol, backendCAKey))
+		case protocol == string(network.HTTP) && annotations.backendCA() != "":
+			parser.errors = append(parser.errors, fmt.Errorf("%v %v conflicts with %v", backendProtocolKey, protocol, backendCAKey))
+		default:
+			settings.BackendProtocol = network.ApplicationGatewayProtocol(protocol)
+		}
+	}
+
+	if value, ok := annotations[sslDisabledProtocolsKey]; ok {
+		for _, item := range strings.Split(value, ",") {
+			protocol, ok := matchOneOf(item, sslProtocols)
+			if !ok {
+				parser.invalid(sslDisabledProtocolsKey, value, "a comma separated list of "+strings.Join(sslProtocols, ", "))
+				settings.DisabledSslProtocols = nil
+				break
+			}
+			settings.DisabledSslProtocols = append(settings.DisabledSslProtocols, network.ApplicationGatewaySslProtocol(protocol))
+		}
+	}
+
+	if name, ok := parser.oneOf(skuNameKey, skuNames); ok {
+		settings.SkuName = network.ApplicationGatewaySkuName(name)
+	}
+	if tier, ok := parser.oneOf(skuTierKey, skuTiers); ok {
+		settings.SkuTier = network.ApplicationGatewayTier(tier)
+	}
+	settings.Capacity = parser.int32(capacityKey, maxCapacity)
+
+	if name, ok := annotations[gatewayNameKey]; ok {
+		if len(name) > maxGatewayNameLength || !gatewayNamePattern.MatchString(name) {
+			parser.invalid(gatewayNameKey, name, "a valid Azure resource name")
+		} else {
+			settings.GatewayName = name
+		}
+	}
+
+	parsed.probe = probeOverrides{
+		path:               annotations[probePathKey],
+		host:               annotations[probeHostKey],
+		interval:           parser.int32(probeIntervalKey, maxProbeSeconds),
+		timeout:            parser.int32(probeTimeoutKey, maxProbeSeconds),
+		unhealthyThreshold: parser.int32(probeUnhealthyThresholdKey, maxUnhealthyThreshold),
+	}
+	if parsed.probe.path != "" && !strings.HasPrefix(parsed.probe.path, "/") {
+		parser.invalid(probePathKey, parsed.probe.path, "an absolute path")
+		parsed.probe.path = ""
+	}
+
+	return parsed, parser.errors
+}
+
+// gatewayAnnotations parses the annotations of an ingress, reporting every
+// invalid annotation as an event on the ingress.
+func (lbc *loadBalancerController) gatewayAnnotations(ingress *extensions.Ingress) gatewayAnnotations {
+	parsed, errs := parseGatewayAnnotations(ingressAnnotations(ingress.Annotations))
+	for _, err := range errs {
+		logging.Warningf("ingress %v/%v: %v", ingress.Namespace, ingress.Name, err)
+		lbc.recorder.Eventf(ingress, api.EventTypeWarning, "ANNOTATION", "%v", err)
+	}
+	return parsed
+}
+
+// ingressGatewayName returns the name of the gateway serving an ingress, which
+// is empty until the controller has placed the ingress on a shared gateway.
+func ingressGatewayName(ingress *extensions.Ingress) string {
+	parsed, _ := parseGatewayAnnotations(ingressAnnotations(ingress.Annotations))
+	if parsed.settings.GatewayName != "" {
+		return parsed.settings.GatewayName
+	}
+	return ingress.Annotations[assignedGatewayKey]
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/arm/network"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/azurecontroller"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/client/record"
+)
+
+func TestParseGatewayAnnotations(t *testing.T) {
+	parsed, errs := parseGatewayAnnotations(ingressAnnotations{
+		cookieBasedAffinityKey:  "true",
+		requestTimeoutKey:       "120",
+		backendProtocolKey:      "https",
+		backendCAKey:            "secret/backend-ca",
+		sslDisabledProtocolsKey: "TLSv1_0, TLSv1_1",
+		skuNameKey:              "standard_medium",
+		skuTierKey:              "Standard",
+		capacityKey:             "4",
+		gatewayNameKey:          "shared-gateway",
+	})
+	if len(errs) != 0 {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+
+	expected := azurecontroller.GatewaySettings{
+		GatewayName:          "shared-gateway",
+		CookieBasedAffinity:  true,
+		RequestTimeout:       120,
+		BackendProtocol:      network.HTTPS,
+		DisabledSslProtocols: []network.ApplicationGatewaySslProtocol{network.TLSv10, network.TLSv11},
+		SkuName:              network.StandardMedium,
+		SkuTier:              network.Standard,
+		Capacity:             4,
+	}
+	if !reflect.DeepEqual(parsed.settings, expected) {
+		t.Errorf("unexpected settings %+v", parsed.settings)
+	}
+}
+
+func TestParseGatewayAnnotationsErrors(t *testing.T) {
+	parsed, errs := parseGatewayAnnotations(ingressAnnotations{
+		cookieBasedAffinityKey:  "sometimes",
+		requestTimeoutKey:       "0",
+		backendProtocolKey:      "https",
+		sslDisabledProtocolsKey: "SSLv3",
+		skuNameKey:              "Huge",
+		capacityKey:             "11",
+		gatewayNameKey:          "-gateway",
+		probePathKey:            "healthz",
+	})
+	if len(errs) != 8 {
+		t.Errorf("expected an error per invalid annotation, got %v", errs)
+	}
+	if !reflect.DeepEqual(parsed.settings, azurecontroller.GatewaySettings{}) || parsed.probe.path != "" {
+		t.Errorf("expected invalid annotations to keep their defaults, got %+v", parsed)
+	}
+}
+
+func TestGatewayAnnotationsRecordsEvents(t *testing.T) {
+	lbc := newTestLoadBalancerController()
+	recorder := lbc.recorder.(*record.FakeRecorder)
+	ingress := &extensions.Ingress{
+		ObjectMeta: api.ObjectMeta{
+			Name:        "web",
+			Namespace:   "default",
+			Annotations: map[string]string{capacityKey: "many", gatewayNameKey: "shared"},
+		},
+	}
+
+	if parsed := lbc.gatewayAnnotations(ingress); parsed.settings.GatewayName != "shared" {
+		t.Errorf("expected valid annotations to be applied, got %+v", parsed.settings)
+	}
+	if len(recorder.Events) != 1 {
+		t.Errorf("expected a single warning event, got %v", len(recorder.Events))
+	}
+	if name := ingressGatewayName(ingress); name != "shared" {
+		t.Errorf("expected the annotated gateway name, got %v", name)
+	}
+}
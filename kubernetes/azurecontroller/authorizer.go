@@ -0,0 +1,214 @@
+package azurecontroller
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"golang.org/x/crypto/pkcs12"
+)
+
+const (
+	//DefaultIdentityEndpoint is the token endpoint of the instance metadata service
+	DefaultIdentityEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+	identityAPIVersion      = "2018-02-01"
+	// managed identity tokens are refreshed as early as service principal tokens
+	identityRefreshWithin = 5 * time.Minute
+)
+
+//AuthenticationConfig holds the settings of every way to authenticate to Azure,
+//NewAuthorizer picks one of them
+type AuthenticationConfig struct {
+	TenantID string
+	// ClientID is the service principal, or the user assigned identity when
+	// UseManagedIdentity is set
+	ClientID string
+
+	ClientSecret string
+
+	// Certificate is a PEM certificate and private key or a PFX archive
+	// protected by CertificatePassword
+	Certificate         []byte
+	CertificatePassword string
+
+	// UseManagedIdentity requests the tokens of the identity assigned to the
+	// VM from IdentityEndpoint, which defaults to DefaultIdentityEndpoint
+	UseManagedIdentity bool
+	IdentityEndpoint   string
+
+	// Environment holds the Active Directory endpoint and the resource the
+	// tokens are requested for, the public cloud when it is empty
+	Environment azure.Environment
+}
+
+//NewAuthorizer creates the authorizer of the Azure clients. A managed identity
+//takes precedence over a certificate, which takes precedence over a client
+//secret. The authorizer refreshes its token as needed.
+func NewAuthorizer(config AuthenticationConfig) (autorest.Authorizer, error) {
+	environment := environmentOrDefault(config.Environment)
+	resource := environment.ServiceManagementEndpoint
+	if config.UseManagedIdentity {
+		endpoint := config.IdentityEndpoint
+		if endpoint == "" {
+			endpoint = DefaultIdentityEndpoint
+		}
+		return &managedIdentityToken{
+			endpoint: endpoint,
+			clientID: config.ClientID,
+			resource: resource,
+			sender:   &http.Client{},
+		}, nil
+	}
+
+	oauthConfig, err := environment.OAuthConfigForTenant(config.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	if len(config.Certificate) > 0 {
+		certificate, key, err := parseClientCertificate(config.Certificate, config.CertificatePassword)
+		if err != nil {
+			return nil, fmt.Errorf("loading the client certificate: %v", err)
+		}
+		return azure.NewServicePrincipalTokenFromCertificate(*oauthConfig, config.ClientID, certificate, key, resource)
+	}
+	if config.ClientSecret == "" {
+		return nil, errors.New("no client secret, client certificate or managed identity to authenticate with")
+	}
+	return azure.NewServicePrincipalToken(*oauthConfig, config.ClientID, config.ClientSecret, resource)
+}
+
+//parseClientCertificate reads a PEM certificate and private key, either from
+//one file or from a PFX archive
+func parseClientCertificate(data []byte, password string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	if !bytes.Contains(data, []byte("-----BEGIN")) {
+		blocks, err := pkcs12.ToPEM(data, password)
+		if err != nil {
+			return nil, nil, err
+		}
+		data = nil
+		for _, block := range blocks {
+			data = append(data, pem.EncodeToMemory(block)...)
+		}
+	}
+
+	// the file may hold the whole chain, the certificate of the key is the
+	// one tls accepts along with it
+	var keyPair tls.Certificate
+	err := errors.New("no PEM encoded certificate found")
+	for rest := data; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if keyPair, err = tls.X509KeyPair(pem.EncodeToMemory(block), data); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	key, ok := keyPair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported private key type %T, only RSA keys are supported", keyPair.PrivateKey)
+	}
+	certificate, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	return certificate, key, nil
+}
+
+//managedIdentityToken authorizes requests with the tokens of a managed identity
+type managedIdentityToken struct {
+	endpoint string
+	clientID string
+	resource string
+	sender   autorest.Sender
+
+	lock  sync.Mutex
+	token azure.Token
+}
+
+//EnsureFresh requests a new token when the current one is about to expire
+func (identity *managedIdentityToken) EnsureFresh() error {
+	_, err := identity.accessToken()
+	return err
+}
+
+func (identity *managedIdentityToken) WithAuthorization() autorest.PrepareDecorator {
+	return func(p autorest.Preparer) autorest.Preparer {
+		return autorest.PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			accessToken, err := identity.accessToken()
+			if err != nil {
+				return r, autorest.NewErrorWithError(err,
+					"azurecontroller.managedIdentityToken", "WithAuthorization", nil, "Failed to refresh the managed identity token for request to %s",
+					r.URL)
+			}
+			return autorest.WithBearerAuthorization(accessToken)(p).Prepare(r)
+		})
+	}
+}
+
+func (identity *managedIdentityToken) accessToken() (string, error) {
+	identity.lock.Lock()
+	defer identity.lock.Unlock()
+	if identity.token.WillExpireIn(identityRefreshWithin) {
+		if err := identity.refresh(); err != nil {
+			return "", err
+		}
+	}
+	return identity.token.AccessToken, nil
+}
+
+func (identity *managedIdentityToken) refresh() error {
+	parameters := map[string]interface{}{
+		"api-version": identityAPIVersion,
+		"resource":    identity.resource,
+	}
+	if identity.clientID != "" {
+		parameters["client_id"] = identity.clientID
+	}
+
+	req, err := autorest.Prepare(&http.Request{},
+		autorest.AsGet(),
+		autorest.WithBaseURL(identity.endpoint),
+		autorest.WithQueryParameters(parameters),
+		autorest.WithHeader("Metadata", "true"))
+	if err != nil {
+		return err
+	}
+
+	resp, err := autorest.SendWithSender(identity.sender, req)
+	if err != nil {
+		return autorest.NewErrorWithError(err,
+			"azurecontroller.managedIdentityToken", "Refresh", resp, "Failure sending request to %s", identity.endpoint)
+	}
+
+	var token azure.Token
+	err = autorest.Respond(resp,
+		autorest.WithErrorUnlessOK(),
+		autorest.ByUnmarshallingJSON(&token),
+		autorest.ByClosing())
+	if err != nil {
+		return autorest.NewErrorWithError(err,
+			"azurecontroller.managedIdentityToken", "Refresh", resp, "Failure handling the response of %s", identity.endpoint)
+	}
+	if token.AccessToken == "" {
+		return fmt.Errorf("the response of %v holds no access token", identity.endpoint)
+	}
+
+	identity.token = token
+	return nil
+}
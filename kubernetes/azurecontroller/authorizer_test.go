@@ -0,0 +1,155 @@
+package azurecontroller
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+	gopkcs12 "software.sslmate.com/src/go-pkcs12"
+)
+
+func TestNewAuthorizerWithManagedIdentity(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Metadata") != "true" || r.URL.Query().Get("client_id") != "identity" || r.URL.Query().Get("resource") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, `{"access_token": "identity-token", "expires_on": "%v", "token_type": "Bearer"}`, time.Now().Add(time.Hour).Unix())
+	}))
+	defer server.Close()
+
+	authorizer, err := NewAuthorizer(AuthenticationConfig{ClientID: "identity", UseManagedIdentity: true, IdentityEndpoint: server.URL})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		req, err := autorest.Prepare(&http.Request{}, autorest.WithBaseURL("https://management.azure.com/"), authorizer.WithAuthorization())
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if header := req.Header.Get("Authorization"); header != "Bearer identity-token" {
+			t.Errorf("expected the token of the identity, got %q", header)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("expected the token to be reused until it expires, got %v requests", requests)
+	}
+
+	failing, _ := NewAuthorizer(AuthenticationConfig{UseManagedIdentity: true, IdentityEndpoint: server.URL})
+	if err := failing.(tokenRefresher).EnsureFresh(); err == nil {
+		t.Errorf("expected the rejected token request to fail")
+	}
+}
+
+func TestNewAuthorizerWithCertificate(t *testing.T) {
+	certPEM, keyPEM := newTestCertificatePEM(t, "client")
+	keyPair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pfx := newTestPFX(t, keyPair, nil, "pfx-password")
+
+	certificates := map[string]AuthenticationConfig{
+		"pem": {Certificate: append(certPEM, keyPEM...)},
+		"pfx": {Certificate: pfx, CertificatePassword: "pfx-password"},
+	}
+	for name, config := range certificates {
+		config.TenantID, config.ClientID = "tenant", "client"
+		authorizer, err := NewAuthorizer(config)
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", name, err)
+			continue
+		}
+		if _, ok := authorizer.(*azure.ServicePrincipalToken); !ok {
+			t.Errorf("%v: expected a service principal token, got %T", name, authorizer)
+		}
+	}
+
+	_, err = NewAuthorizer(AuthenticationConfig{TenantID: "tenant", ClientID: "client", Certificate: pfx, CertificatePassword: "wrong"})
+	if err == nil || !strings.Contains(err.Error(), "password") {
+		t.Errorf("expected the wrong PFX password to be reported, got %v", err)
+	}
+}
+
+//newTestPFX archives a key pair along with the certificates of its chain
+func newTestPFX(t *testing.T, keyPair tls.Certificate, chain []*x509.Certificate, password string) []byte {
+	leaf, err := x509.ParseCertificate(keyPair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pfx, err := gopkcs12.LegacyDES.Encode(keyPair.PrivateKey, leaf, chain, password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pfx
+}
+
+func TestParseClientCertificateFindsTheCertificateOfTheKey(t *testing.T) {
+	certPEM, keyPEM := newTestCertificatePEM(t, "client")
+	keyPair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caPEM, _ := newTestCertificatePEM(t, "ca")
+	caBlock, _ := pem.Decode(caPEM)
+	ca, err := x509.ParseCertificate(caBlock.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// both files hold the chain, the CA first, the key decides which is the leaf
+	certificates := map[string][]byte{
+		"pem": append(append(caPEM, certPEM...), keyPEM...),
+		"pfx": newTestPFX(t, keyPair, []*x509.Certificate{ca}, "pfx-password"),
+	}
+	for name, data := range certificates {
+		certificate, key, err := parseClientCertificate(data, "pfx-password")
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", name, err)
+			continue
+		}
+		if certificate.Subject.CommonName != "client" || key.N.Cmp(keyPair.PrivateKey.(*rsa.PrivateKey).N) != 0 {
+			t.Errorf("%v: expected the client certificate and its key, got %v", name, certificate.Subject.CommonName)
+		}
+	}
+}
+
+//testdata/openssl.pfx holds a self signed certificate of openssl.example.com
+//exported by "openssl pkcs12 -export" of OpenSSL 3.0 with the options
+//-certpbe PBE-SHA1-3DES -keypbe PBE-SHA1-3DES -macalg sha1
+const openSSLFixturePassword = "fixture-password"
+
+func TestParseClientCertificateExportedByOpenSSL(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "openssl.pfx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificate, key, err := parseClientCertificate(data, openSSLFixturePassword)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if certificate.Subject.CommonName != "openssl.example.com" {
+		t.Errorf("expected the certificate of openssl.example.com, got %v", certificate.Subject.CommonName)
+	}
+	if err := key.Validate(); err != nil {
+		t.Errorf("expected a valid key, got %v", err)
+	}
+
+	if _, _, err := parseClientCertificate(data, "wrong"); err == nil {
+		t.Errorf("expected the wrong password to be rejected")
+	}
+}
@@ -1,18 +1,42 @@
 package azurecontroller
 
 import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/Azure/azure-sdk-for-go/arm/network"
 	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/azure"
-	"github.com/golang/glog"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/logging"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/redact"
 
 	"k8s.io/kubernetes/pkg/apis/extensions"
 )
 
+const resourceNotFoundCode = "ResourceNotFound"
+
 //GatewayClient interface has been added to support unit testing
 type GatewayClient interface {
 	ListAll() (network.ApplicationGatewayListResult, error)
+	List(resourceGroupName string) (result network.ApplicationGatewayListResult, err error)
+	ListNextResults(lastResults network.ApplicationGatewayListResult) (result network.ApplicationGatewayListResult, err error)
 	Get(resourceGroupName string, applicationGatewayName string) (result network.ApplicationGateway, err error)
+	CreateOrUpdate(resourceGroupName string, applicationGatewayName string, parameters network.ApplicationGateway, cancel <-chan struct{}) (result autorest.Response, err error)
+	CreateOrUpdateIfMatch(resourceGroupName string, applicationGatewayName string, parameters network.ApplicationGateway, etag string, cancel <-chan struct{}) (result autorest.Response, err error)
+	Delete(resourceGroupName string, applicationGatewayName string, cancel <-chan struct{}) (result autorest.Response, err error)
+}
+
+//PublicIPClient is the subset of the Azure public IP address API used by the controller
+type PublicIPClient interface {
+	List(resourceGroupName string) (result network.PublicIPAddressListResult, err error)
+	ListNextResults(lastResults network.PublicIPAddressListResult) (result network.PublicIPAddressListResult, err error)
+	Get(resourceGroupName string, publicIPAddressName string, expand string) (result network.PublicIPAddress, err error)
+	CreateOrUpdate(resourceGroupName string, publicIPAddressName string, parameters network.PublicIPAddress, cancel <-chan struct{}) (result autorest.Response, err error)
+	Delete(resourceGroupName string, publicIPAddressName string, cancel <-chan struct{}) (result autorest.Response, err error)
 }
 
 //AzureCredentialInfo holds credentials and security tokens for Azure
@@ -21,44 +45,404 @@ type AzureCredentialInfo struct {
 	Region            string
 	SubscriptionID    string
 
-	ServicePrincipalToken *azure.ServicePrincipalToken
+	// Authorizer authenticates the requests, see NewAuthorizer
+	Authorizer autorest.Authorizer
+	// Environment holds the endpoints of the Azure cloud, the public cloud
+	// when it is empty
+	Environment azure.Environment
+}
+
+//ProvisioningOptions holds the settings used when creating new ApplicationGateways
+type ProvisioningOptions struct {
+	SubnetID string
+	SkuName  network.ApplicationGatewaySkuName
+	Capacity int32
+
+	// ClusterID and ControllerVersion are tagged on every resource the
+	// controller creates, only resources tagged with the cluster ID are
+	// ever garbage collected
+	ClusterID         string
+	ControllerVersion string
 }
 
-//NewAzureGatewayClientController creates an object for interacting with Azure API
-func NewAzureGatewayClientController(creds AzureCredentialInfo) *AzureGatewayClientController {
-	return &AzureGatewayClientController{
+//NewAzureGatewayClientController creates an object for interacting with Azure API,
+//closing cancel stops waiting for the long running operations in flight
+func NewAzureGatewayClientController(creds AzureCredentialInfo, options ProvisioningOptions, cancel <-chan struct{}) *AzureGatewayClientController {
+	creds.Environment = environmentOrDefault(creds.Environment)
+	authorizer := &swappableAuthorizer{authorizer: tokenAuthorizer{creds.Authorizer}}
+
+	gatewayClient := network.NewApplicationGatewaysClientWithBaseURI(creds.Environment.ResourceManagerEndpoint, creds.SubscriptionID)
+	gatewayClient.Authorizer = authorizer
+
+	publicIPClient := network.NewPublicIPAddressesClientWithBaseURI(creds.Environment.ResourceManagerEndpoint, creds.SubscriptionID)
+	publicIPClient.Authorizer = authorizer
+
+	controller := &AzureGatewayClientController{
 		AzureCredentialInfo: creds,
+		ProvisioningOptions: options,
+		gatewayClient:       instrumentedGatewayClient{etagGatewayClient{gatewayClient}},
+		publicIPClient:      instrumentedPublicIPClient{publicIPClient},
+		operations:          newOperationTracker(cancel),
+		authorizer:          authorizer,
+	}
+	if token, ok := creds.Authorizer.(tokenRefresher); ok {
+		controller.token = token
 	}
+	return controller
 }
 
 //AzureGatewayClientController handles api calls to Azure
 type AzureGatewayClientController struct {
 	AzureCredentialInfo
+	ProvisioningOptions
+
+	gatewayClient  GatewayClient
+	publicIPClient PublicIPClient
+
+	// operations serializes the writes to every gateway
+	operations *operationTracker
+	// pollInterval is how often a gateway Azure is still provisioning is
+	// checked, it defaults to defaultPollInterval
+	pollInterval time.Duration
+
+	// authorizer holds the credentials of both clients, token is refreshed
+	// by CheckAzure and health holds the outcome
+	authorizer *swappableAuthorizer
+	tokenLock  sync.Mutex
+	token      tokenRefresher
+	health     healthTracker
 }
 
-//SyncApplicationGateway synchronizes an ingress identifier with the matching Azure ApplicationGateway
-func (controller *AzureGatewayClientController) SyncApplicationGateway(ingress *extensions.Ingress) {
-	gatewayClient := network.NewApplicationGatewaysClient(controller.SubscriptionID)
-	gatewayClient.BaseURI = azure.PublicCloud.ResourceManagerEndpoint
-	gatewayClient.Authorizer = controller.ServicePrincipalToken
+//SyncResult describes what synchronizing a gateway did
+type SyncResult struct {
+	// Updated is true when the gateway was created or changed
+	Updated bool
+	// ConcurrentUpdates counts the writes recomputed because another writer
+	// changed the gateway between reading and writing it
+	ConcurrentUpdates int
+}
+
+//SyncApplicationGateway synchronizes the named Azure ApplicationGateway with every
+//ingress it serves. A write still in flight, even one issued before a restart,
+//is waited for instead of being repeated.
+func (controller *AzureGatewayClientController) SyncApplicationGateway(gatewayName string, states []IngressState) (SyncResult, error) {
+	result := SyncResult{}
+	err := controller.operations.run(gatewayName, OperationCreateOrUpdate, func(cancel <-chan struct{}) error {
+		live, err := controller.liveGateway(gatewayName)
+		if err != nil {
+			if !isResourceNotFound(err) {
+				logging.Errorf("Failure retrieving the gateway %v in the resource group %v: %v", gatewayName, controller.ResourceGroupName, redact.Value(err))
+				return err
+			}
 
-	gateway, err := gatewayClient.Get(controller.ResourceGroupName, ingress.Name)
+			logging.Infof("Gateway %v not found in the resource group %v. Attempting to create.", gatewayName, controller.ResourceGroupName)
+			result.Updated = true
+			return controller.createApplicationGateway(gatewayName, states, cancel)
+		}
 
+		// writes only apply to the version of the gateway they were computed
+		// from, a concurrent change by another replica or in the portal makes
+		// Azure reject them and the write is recomputed from the new version
+		for {
+			result.Updated, err = controller.reconcileApplicationGateway(live, states, cancel)
+			if !isPreconditionFailed(err) || result.ConcurrentUpdates == maxConcurrentUpdateRetries {
+				return err
+			}
+			result.ConcurrentUpdates++
+			logging.Infof("Gateway %v was changed concurrently, recomputing the update", gatewayName)
+
+			if live, err = controller.liveGateway(gatewayName); err != nil {
+				return err
+			}
+		}
+	})
+	return result, err
+}
+
+//liveGateway reads a gateway once Azure finished any write in flight
+func (controller *AzureGatewayClientController) liveGateway(gatewayName string) (network.ApplicationGateway, error) {
+	gateway, err := controller.gatewayClient.Get(controller.ResourceGroupName, gatewayName)
 	if err != nil {
-		detailedError, ok := err.(autorest.DetailedError) //.Original.(*azure.RequestError).ServiceError
-		if ok {
-			requestError, ok := detailedError.Original.(*azure.RequestError)
-			if ok {
-				if requestError.ServiceError.Code == "ResourceNotFound" {
-					glog.Infof("%v Attempting to create.", requestError.ServiceError.Message)
-					//TODO: go create a new one
-				}
+		return gateway, err
+	}
+	return controller.awaitProvisioning(gateway)
+}
+
+//reconcileApplicationGateway brings an existing gateway in line with the ingresses,
+//only writing to Azure when the live configuration has drifted
+func (controller *AzureGatewayClientController) reconcileApplicationGateway(live network.ApplicationGateway, states []IngressState, cancel <-chan struct{}) (bool, error) {
+	gatewayName := to.String(live.Name)
+	settings := gatewaySettings(states)
+
+	translated := TranslateIngresses(controller.gatewayConfig(gatewayName, "", settings), states)
+	desired := mergeGateway(live, translated)
+	// tagging a gateway the controller did not create would have it deleted
+	// as if the controller owned it
+	if controller.createdByController(live.Tags) {
+		desired.Tags = mergeTags(live.Tags, controller.gatewayTags(states))
+	}
+	if settings.hasSku() {
+		// the provisioning options only size new gateways, an explicit
+		// request of the ingress also resizes existing ones
+		desired.Properties.Sku = translated.Properties.Sku
+	}
+	equal, err := gatewaysEqual(live, desired)
+	if err != nil {
+		return false, err
+	}
+	if equal {
+		logging.V(3).Infof("Gateway %v is up to date", gatewayName)
+		recordGatewaySize(gatewayName, live)
+		return false, nil
+	}
+
+	logging.Infof("Gateway %v has drifted from its %d ingresses, updating", gatewayName, len(states))
+
+	start := time.Now()
+	_, err = controller.gatewayClient.CreateOrUpdateIfMatch(controller.ResourceGroupName, gatewayName, desired, to.String(live.Etag), cancel)
+	if err != nil {
+		logging.Errorf("Failed to update gateway %v in the resource group %v after %v: %v", gatewayName, controller.ResourceGroupName, time.Since(start), redact.Value(err))
+		return false, err
+	}
+
+	logging.Infof("Updated gateway %v in the resource group %v in %v", gatewayName, controller.ResourceGroupName, time.Since(start))
+	recordGatewaySize(gatewayName, desired)
+	return true, nil
+}
+
+//DeleteApplicationGateway tears down a gateway together with the public IP the
+//controller created for it, waiting for both deletions to complete. It returns
+//the resources it deleted or failed to delete, the public IP only when the
+//controller created it.
+func (controller *AzureGatewayClientController) DeleteApplicationGateway(gatewayName string) ([]DeletedResource, error) {
+	deleted := []DeletedResource{}
+	err := controller.operations.run(gatewayName, OperationDelete, func(cancel <-chan struct{}) error {
+		start := time.Now()
+		_, err := controller.gatewayClient.Delete(controller.ResourceGroupName, gatewayName, cancel)
+		if err != nil && !isResourceNotFound(err) {
+			logging.Errorf("Failed to delete gateway %v in the resource group %v after %v: %v", gatewayName, controller.ResourceGroupName, time.Since(start), redact.Value(err))
+			return err
+		}
+		logging.Infof("Deleted gateway %v in the resource group %v in %v", gatewayName, controller.ResourceGroupName, time.Since(start))
+		forgetGatewaySize(gatewayName)
+		deleted = append(deleted, deletedResource(GatewayKind, gatewayName, nil))
+
+		// the public IP can only be removed once no gateway references it,
+		// an address of the same name the controller did not create is kept
+		name := publicIPName(gatewayName)
+		publicIP, err := controller.publicIPClient.Get(controller.ResourceGroupName, name, "")
+		if err != nil {
+			if isResourceNotFound(err) {
+				return nil
 			}
-		} else {
-			glog.Errorf("Failure retrieving the gateway %v in the resource group %v: %v", ingress.Name, controller.ResourceGroupName, err)
+			logging.Errorf("Failure retrieving the public IP %v in the resource group %v: %v", name, controller.ResourceGroupName, redact.Value(err))
+			deleted = append(deleted, deletedResource(PublicIPKind, name, err))
+			return err
+		}
+		if !controller.createdByController(publicIP.Tags) {
+			logging.Infof("Public IP %v was not created by the controller, keeping it", name)
+			return nil
+		}
+		_, err = controller.publicIPClient.Delete(controller.ResourceGroupName, name, cancel)
+		if err != nil && !isResourceNotFound(err) {
+			logging.Errorf("[AZURE] Failed to delete Public IP %v: %v", name, redact.Value(err))
+			deleted = append(deleted, deletedResource(PublicIPKind, name, err))
+			return err
 		}
-	} else {
-		//TODO: No errors therefore validate the configuration
-		glog.Infof("Validating %v settings", gateway)
+		logging.Infof("Deleted public IP %v in the resource group %v", name, controller.ResourceGroupName)
+		deleted = append(deleted, deletedResource(PublicIPKind, name, nil))
+
+		return nil
+	})
+	// the gateway is reported as failed when its deletion did not complete
+	if err != nil && len(deleted) == 0 {
+		deleted = append(deleted, deletedResource(GatewayKind, gatewayName, err))
 	}
+	return deleted, err
+}
+
+//ReleaseApplicationGateway frees the gateway of the last ingress it served.
+//Gateways the controller created are deleted together with their public IP,
+//any other gateway only loses the listeners, rules and backends of the ingress.
+func (controller *AzureGatewayClientController) ReleaseApplicationGateway(gatewayName string, ingress *extensions.Ingress) error {
+	live, err := controller.gatewayClient.Get(controller.ResourceGroupName, gatewayName)
+	if err != nil && !isResourceNotFound(err) {
+		logging.Errorf("Failure retrieving the gateway %v in the resource group %v: %v", gatewayName, controller.ResourceGroupName, redact.Value(err))
+		return err
+	}
+	// a missing gateway may still have left its public IP behind
+	if err != nil || controller.createdByController(live.Tags) {
+		_, err := controller.DeleteApplicationGateway(gatewayName)
+		return err
+	}
+
+	logging.Infof("Gateway %v was not created by the controller, only removing ingress %v/%v from it", gatewayName, ingress.Namespace, ingress.Name)
+	return controller.operations.run(gatewayName, OperationCreateOrUpdate, func(cancel <-chan struct{}) error {
+		live, err := controller.liveGateway(gatewayName)
+		if err != nil {
+			return err
+		}
+		desired := withoutIngress(live, ingress)
+		equal, err := gatewaysEqual(live, desired)
+		if err != nil || equal {
+			return err
+		}
+
+		start := time.Now()
+		_, err = controller.gatewayClient.CreateOrUpdateIfMatch(controller.ResourceGroupName, gatewayName, desired, to.String(live.Etag), cancel)
+		if err != nil {
+			logging.Errorf("Failed to update gateway %v in the resource group %v after %v: %v", gatewayName, controller.ResourceGroupName, time.Since(start), redact.Value(err))
+			return err
+		}
+		logging.Infof("Removed ingress %v/%v from gateway %v in %v", ingress.Namespace, ingress.Name, gatewayName, time.Since(start))
+		recordGatewaySize(gatewayName, desired)
+		return nil
+	})
+}
+
+//FrontendAddress returns the IP address clients reach a gateway on, preferring
+//its public address over a private one. The address is empty while Azure has
+//not allocated one yet.
+func (controller *AzureGatewayClientController) FrontendAddress(gatewayName string) (string, error) {
+	gateway, err := controller.gatewayClient.Get(controller.ResourceGroupName, gatewayName)
+	if err != nil {
+		return "", err
+	}
+	if gateway.Properties == nil || gateway.Properties.FrontendIPConfigurations == nil {
+		return "", nil
+	}
+
+	privateAddress := ""
+	for _, frontend := range *gateway.Properties.FrontendIPConfigurations {
+		if frontend.Properties == nil {
+			continue
+		}
+		if frontend.Properties.PublicIPAddress != nil {
+			resourceGroupName, name := parseResourceID(to.String(frontend.Properties.PublicIPAddress.ID))
+			publicIP, err := controller.publicIPClient.Get(resourceGroupName, name, "")
+			if err != nil {
+				return "", err
+			}
+			if publicIP.Properties != nil && to.String(publicIP.Properties.IPAddress) != "" {
+				return to.String(publicIP.Properties.IPAddress), nil
+			}
+		}
+		if privateAddress == "" {
+			privateAddress = to.String(frontend.Properties.PrivateIPAddress)
+		}
+	}
+
+	return privateAddress, nil
+}
+
+//gatewayConfig describes the gateway with the given name in the controller's resource group
+func (controller *AzureGatewayClientController) gatewayConfig(gatewayName, publicIPAddressID string, settings GatewaySettings) GatewayConfig {
+	config := GatewayConfig{
+		SubscriptionID:    controller.SubscriptionID,
+		ResourceGroupName: controller.ResourceGroupName,
+		Region:            controller.Region,
+		GatewayName:       gatewayName,
+		PublicIPAddressID: publicIPAddressID,
+		SubnetID:          controller.SubnetID,
+		SkuName:           controller.SkuName,
+		Capacity:          controller.Capacity,
+	}
+	if settings.SkuName != "" {
+		config.SkuName = settings.SkuName
+	}
+	if settings.SkuTier != "" {
+		config.SkuTier = settings.SkuTier
+	}
+	if settings.Capacity > 0 {
+		config.Capacity = settings.Capacity
+	}
+	return config
+}
+
+//createApplicationGateway provisions a new gateway for the ingresses and waits for
+//the long running operation to complete
+func (controller *AzureGatewayClientController) createApplicationGateway(gatewayName string, states []IngressState, cancel <-chan struct{}) error {
+	if controller.SubnetID == "" {
+		return fmt.Errorf("cannot create gateway %v: no subnet has been configured for application gateways", gatewayName)
+	}
+
+	publicIP, err := controller.ensurePublicIP(gatewayName, cancel)
+	if err != nil {
+		return err
+	}
+
+	gateway := TranslateIngresses(controller.gatewayConfig(gatewayName, to.String(publicIP.ID), gatewaySettings(states)), states)
+	gateway.Tags = mergeTags(nil, controller.gatewayTags(states))
+
+	start := time.Now()
+	_, err = controller.gatewayClient.CreateOrUpdate(controller.ResourceGroupName, gatewayName, gateway, cancel)
+	if err != nil {
+		logging.Errorf("Failed to create gateway %v in the resource group %v after %v: %v", gatewayName, controller.ResourceGroupName, time.Since(start), redact.Value(err))
+		return err
+	}
+
+	logging.Infof("Created gateway %v in the resource group %v in %v", gatewayName, controller.ResourceGroupName, time.Since(start))
+	recordGatewaySize(gatewayName, gateway)
+	return nil
+}
+
+//ensurePublicIP returns the public IP address of a gateway, creating it when it does not exist
+func (controller *AzureGatewayClientController) ensurePublicIP(gatewayName string, cancel <-chan struct{}) (network.PublicIPAddress, error) {
+	name := publicIPName(gatewayName)
+	publicIP, err := controller.publicIPClient.Get(controller.ResourceGroupName, name, "")
+	if err == nil {
+		return publicIP, nil
+	}
+	if !isResourceNotFound(err) {
+		logging.Errorf("Failure retrieving the public IP %v in the resource group %v: %v", name, controller.ResourceGroupName, redact.Value(err))
+		return publicIP, err
+	}
+
+	// application gateways only support dynamically allocated public addresses
+	params := network.PublicIPAddress{
+		Name:     to.StringPtr(name),
+		Location: to.StringPtr(controller.Region),
+		Tags:     mergeTags(nil, controller.publicIPTags(gatewayName)),
+		Properties: &network.PublicIPAddressPropertiesFormat{
+			PublicIPAllocationMethod: network.Dynamic,
+		},
+	}
+
+	if _, err := controller.publicIPClient.CreateOrUpdate(controller.ResourceGroupName, name, params, cancel); err != nil {
+		logging.Errorf("[AZURE] Failed to create Public IP %v: %v", name, redact.Value(err))
+		return publicIP, err
+	}
+
+	logging.Infof("Created public IP %v in the resource group %v", name, controller.ResourceGroupName)
+	return controller.publicIPClient.Get(controller.ResourceGroupName, name, "")
+}
+
+//publicIPName returns the name of the public IP address fronting a gateway
+func publicIPName(gatewayName string) string {
+	return fmt.Sprintf("%s-pip", gatewayName)
+}
+
+//parseResourceID extracts the resource group and name from an ARM resource identifier
+func parseResourceID(id string) (resourceGroupName, name string) {
+	segments := strings.Split(strings.Trim(id, "/"), "/")
+	for index := 0; index+1 < len(segments); index++ {
+		if strings.EqualFold(segments[index], "resourceGroups") {
+			resourceGroupName = segments[index+1]
+		}
+	}
+	return resourceGroupName, segments[len(segments)-1]
+}
+
+//isResourceNotFound determines if an Azure API error reports a missing resource
+func isResourceNotFound(err error) bool {
+	detailedError, ok := err.(autorest.DetailedError)
+	if !ok {
+		return false
+	}
+
+	if requestError, ok := detailedError.Original.(*azure.RequestError); ok && requestError.ServiceError != nil {
+		return requestError.ServiceError.Code == resourceNotFoundCode
+	}
+
+	return detailedError.StatusCode == http.StatusNotFound
 }
@@ -0,0 +1,261 @@
+package azurecontroller
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/arm/network"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/to"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/util/intstr"
+)
+
+func notFoundError() error {
+	return autorest.DetailedError{
+		StatusCode: http.StatusNotFound,
+		Original: &azure.RequestError{
+			ServiceError: &azure.ServiceError{Code: resourceNotFoundCode},
+		},
+	}
+}
+
+type fakeGatewayClient struct {
+	gateways map[string]network.ApplicationGateway
+	writes   []string
+	version  int
+
+	// failDeletes holds the gateways whose deletion fails
+	failDeletes map[string]bool
+}
+
+func newFakeGatewayClient() *fakeGatewayClient {
+	return &fakeGatewayClient{gateways: map[string]network.ApplicationGateway{}}
+}
+
+func (client *fakeGatewayClient) ListAll() (network.ApplicationGatewayListResult, error) {
+	gateways := []network.ApplicationGateway{}
+	for _, gateway := range client.gateways {
+		gateways = append(gateways, gateway)
+	}
+	return network.ApplicationGatewayListResult{Value: &gateways}, nil
+}
+
+//List returns a page per gateway to exercise paging
+func (client *fakeGatewayClient) List(resourceGroupName string) (network.ApplicationGatewayListResult, error) {
+	return client.page(0), nil
+}
+
+func (client *fakeGatewayClient) ListNextResults(lastResults network.ApplicationGatewayListResult) (network.ApplicationGatewayListResult, error) {
+	index, err := strconv.Atoi(to.String(lastResults.NextLink))
+	if err != nil {
+		return network.ApplicationGatewayListResult{}, err
+	}
+	return client.page(index), nil
+}
+
+func (client *fakeGatewayClient) page(index int) network.ApplicationGatewayListResult {
+	names := []string{}
+	for name := range client.gateways {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := network.ApplicationGatewayListResult{Value: &[]network.ApplicationGateway{}}
+	if index < len(names) {
+		result.Value = &[]network.ApplicationGateway{client.gateways[names[index]]}
+	}
+	if index+1 < len(names) {
+		result.NextLink = to.StringPtr(strconv.Itoa(index + 1))
+	}
+	return result
+}
+
+func (client *fakeGatewayClient) Get(resourceGroupName string, applicationGatewayName string) (network.ApplicationGateway, error) {
+	gateway, ok := client.gateways[applicationGatewayName]
+	if !ok {
+		return gateway, notFoundError()
+	}
+	return gateway, nil
+}
+
+func (client *fakeGatewayClient) CreateOrUpdate(resourceGroupName string, applicationGatewayName string, parameters network.ApplicationGateway, cancel <-chan struct{}) (autorest.Response, error) {
+	client.writes = append(client.writes, applicationGatewayName)
+	client.version++
+	parameters.Etag = to.StringPtr(fmt.Sprintf("W/\"%d\"", client.version))
+	client.gateways[applicationGatewayName] = parameters
+	return autorest.Response{}, nil
+}
+
+func (client *fakeGatewayClient) CreateOrUpdateIfMatch(resourceGroupName string, applicationGatewayName string, parameters network.ApplicationGateway, etag string, cancel <-chan struct{}) (autorest.Response, error) {
+	if live, ok := client.gateways[applicationGatewayName]; ok && etag != "" && to.String(live.Etag) != etag {
+		return autorest.Response{}, autorest.DetailedError{StatusCode: http.StatusPreconditionFailed}
+	}
+	return client.CreateOrUpdate(resourceGroupName, applicationGatewayName, parameters, cancel)
+}
+
+func (client *fakeGatewayClient) Delete(resourceGroupName string, applicationGatewayName string, cancel <-chan struct{}) (autorest.Response, error) {
+	if client.failDeletes[applicationGatewayName] {
+		return autorest.Response{}, autorest.DetailedError{StatusCode: http.StatusConflict}
+	}
+	client.writes = append(client.writes, applicationGatewayName)
+	delete(client.gateways, applicationGatewayName)
+	return autorest.Response{}, nil
+}
+
+type fakePublicIPClient struct {
+	addresses map[string]network.PublicIPAddress
+}
+
+func newFakePublicIPClient() *fakePublicIPClient {
+	return &fakePublicIPClient{addresses: map[string]network.PublicIPAddress{}}
+}
+
+func (client *fakePublicIPClient) List(resourceGroupName string) (network.PublicIPAddressListResult, error) {
+	addresses := []network.PublicIPAddress{}
+	for _, address := range client.addresses {
+		addresses = append(addresses, address)
+	}
+	return network.PublicIPAddressListResult{Value: &addresses}, nil
+}
+
+func (client *fakePublicIPClient) ListNextResults(lastResults network.PublicIPAddressListResult) (network.PublicIPAddressListResult, error) {
+	return network.PublicIPAddressListResult{}, fmt.Errorf("unexpected next page %v", to.String(lastResults.NextLink))
+}
+
+func (client *fakePublicIPClient) Get(resourceGroupName string, publicIPAddressName string, expand string) (network.PublicIPAddress, error) {
+	address, ok := client.addresses[publicIPAddressName]
+	if !ok {
+		return address, notFoundError()
+	}
+	return address, nil
+}
+
+func (client *fakePublicIPClient) CreateOrUpdate(resourceGroupName string, publicIPAddressName string, parameters network.PublicIPAddress, cancel <-chan struct{}) (autorest.Response, error) {
+	parameters.ID = to.StringPtr(fmt.Sprintf("/subscriptions/subscription/resourceGroups/%s/providers/Microsoft.Network/publicIPAddresses/%s", resourceGroupName, publicIPAddressName))
+	client.addresses[publicIPAddressName] = parameters
+	return autorest.Response{}, nil
+}
+
+func (client *fakePublicIPClient) Delete(resourceGroupName string, publicIPAddressName string, cancel <-chan struct{}) (autorest.Response, error) {
+	delete(client.addresses, publicIPAddressName)
+	return autorest.Response{}, nil
+}
+
+func newTestController() (*AzureGatewayClientController, *fakeGatewayClient, *fakePublicIPClient) {
+	gatewayClient := newFakeGatewayClient()
+	publicIPClient := newFakePublicIPClient()
+	controller := &AzureGatewayClientController{
+		AzureCredentialInfo: AzureCredentialInfo{
+			ResourceGroupName: "group",
+			Region:            "westus",
+			SubscriptionID:    "subscription",
+		},
+		ProvisioningOptions: ProvisioningOptions{
+			SubnetID: "/subscriptions/subscription/resourceGroups/group/providers/Microsoft.Network/virtualNetworks/vnet/subnets/gateways",
+			SkuName:  network.StandardMedium,
+			Capacity: 3,
+		},
+		gatewayClient:  gatewayClient,
+		publicIPClient: publicIPClient,
+		operations:     newOperationTracker(nil),
+	}
+	return controller, gatewayClient, publicIPClient
+}
+
+func TestSyncCreatesMissingGateway(t *testing.T) {
+	controller, gatewayClient, publicIPClient := newTestController()
+	ingress := newTestIngress(newTestRule("foo.example.com", newTestPath("/", "web", 80)))
+	ingress.Spec.Backend = &extensions.IngressBackend{ServiceName: "web", ServicePort: intstr.FromInt(80)}
+
+	if _, err := controller.SyncApplicationGateway("web", []IngressState{{Ingress: ingress}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	publicIP, ok := publicIPClient.addresses["web-pip"]
+	if !ok {
+		t.Fatalf("expected the public IP web-pip to be created, got %v", publicIPClient.addresses)
+	}
+
+	gateway, ok := gatewayClient.gateways["web"]
+	if !ok {
+		t.Fatalf("expected the gateway web to be created")
+	}
+	properties := gateway.Properties
+	if properties.Sku.Name != network.StandardMedium || to.Int32(properties.Sku.Capacity) != 3 {
+		t.Errorf("unexpected sku %+v", *properties.Sku)
+	}
+	subnet := (*properties.GatewayIPConfigurations)[0].Properties.Subnet
+	if to.String(subnet.ID) != controller.SubnetID {
+		t.Errorf("expected gateway in subnet %v, got %v", controller.SubnetID, to.String(subnet.ID))
+	}
+	frontendIP := (*properties.FrontendIPConfigurations)[0].Properties.PublicIPAddress
+	if to.String(frontendIP.ID) != to.String(publicIP.ID) {
+		t.Errorf("expected frontend IP %v, got %v", to.String(publicIP.ID), to.String(frontendIP.ID))
+	}
+}
+
+func TestSyncWithoutSubnetDoesNotCreate(t *testing.T) {
+	controller, gatewayClient, _ := newTestController()
+	controller.SubnetID = ""
+
+	if _, err := controller.SyncApplicationGateway("web", []IngressState{{Ingress: newTestIngress()}}); err == nil {
+		t.Errorf("expected an error when no subnet is configured")
+	}
+	if len(gatewayClient.writes) != 0 {
+		t.Errorf("expected no writes, got %v", gatewayClient.writes)
+	}
+}
+
+func TestDeleteRemovesGatewayAndPublicIP(t *testing.T) {
+	controller, gatewayClient, publicIPClient := newTestController()
+	ingress := newTestIngress(newTestRule("foo.example.com", newTestPath("/", "web", 80)))
+	if _, err := controller.SyncApplicationGateway("web", []IngressState{{Ingress: ingress}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if _, err := controller.DeleteApplicationGateway("web"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(gatewayClient.gateways) != 0 {
+		t.Errorf("expected the gateway to be deleted, got %v", gatewayClient.gateways)
+	}
+	if len(publicIPClient.addresses) != 0 {
+		t.Errorf("expected the public IP to be deleted, got %v", publicIPClient.addresses)
+	}
+}
+
+func TestFrontendAddress(t *testing.T) {
+	controller, _, publicIPClient := newTestController()
+	ingress := newTestIngress(newTestRule("foo.example.com", newTestPath("/", "web", 80)))
+	if _, err := controller.SyncApplicationGateway("web", []IngressState{{Ingress: ingress}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// the dynamic public address is only allocated once the gateway runs
+	address, err := controller.FrontendAddress("web")
+	if err != nil || address != "" {
+		t.Errorf("expected no address before allocation, got %q, %v", address, err)
+	}
+
+	publicIP := publicIPClient.addresses["web-pip"]
+	publicIP.Properties.IPAddress = to.StringPtr("52.160.0.10")
+	publicIPClient.addresses["web-pip"] = publicIP
+
+	address, err = controller.FrontendAddress("web")
+	if err != nil || address != "52.160.0.10" {
+		t.Errorf("expected the public address, got %q, %v", address, err)
+	}
+}
+
+func TestParseResourceID(t *testing.T) {
+	resourceGroupName, name := parseResourceID("/subscriptions/subscription/resourceGroups/group/providers/Microsoft.Network/publicIPAddresses/web-pip")
+	if resourceGroupName != "group" || name != "web-pip" {
+		t.Errorf("unexpected resource group %q and name %q", resourceGroupName, name)
+	}
+}
@@ -0,0 +1,50 @@
+package azurecontroller
+
+import (
+	"sync"
+
+	"github.com/Azure/go-autorest/autorest"
+)
+
+//swappableAuthorizer authorizes the requests of every client with the current
+//credentials, which are replaced when they are reloaded
+type swappableAuthorizer struct {
+	lock       sync.RWMutex
+	authorizer autorest.Authorizer
+}
+
+func (authorizer *swappableAuthorizer) WithAuthorization() autorest.PrepareDecorator {
+	authorizer.lock.RLock()
+	current := authorizer.authorizer
+	authorizer.lock.RUnlock()
+	return current.WithAuthorization()
+}
+
+func (authorizer *swappableAuthorizer) set(current autorest.Authorizer) {
+	authorizer.lock.Lock()
+	defer authorizer.lock.Unlock()
+	authorizer.authorizer = current
+}
+
+//UpdateAuthorizer authorizes the requests from now on with the given
+//authorizer, e.g. after the client secret was rotated. Requests in flight keep
+//the token they started with.
+func (controller *AzureGatewayClientController) UpdateAuthorizer(authorizer autorest.Authorizer) {
+	controller.authorizer.set(tokenAuthorizer{authorizer})
+
+	controller.tokenLock.Lock()
+	defer controller.tokenLock.Unlock()
+	controller.token, _ = authorizer.(tokenRefresher)
+}
+
+//RefreshesToken determines if the authorizer has a token CheckAzure refreshes
+func (controller *AzureGatewayClientController) RefreshesToken() bool {
+	return controller.currentToken() != nil
+}
+
+//currentToken returns the token the health check refreshes
+func (controller *AzureGatewayClientController) currentToken() tokenRefresher {
+	controller.tokenLock.Lock()
+	defer controller.tokenLock.Unlock()
+	return controller.token
+}
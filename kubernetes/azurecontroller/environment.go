@@ -0,0 +1,11 @@
+package azurecontroller
+
+import "github.com/Azure/go-autorest/autorest/azure"
+
+//environmentOrDefault returns the public cloud for the zero environment
+func environmentOrDefault(environment azure.Environment) azure.Environment {
+	if environment.ResourceManagerEndpoint == "" {
+		return azure.PublicCloud
+	}
+	return environment
+}
@@ -0,0 +1,60 @@
+package azurecontroller
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+func TestControllerUsesTheEnvironment(t *testing.T) {
+	requests := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r.URL.Path
+		fmt.Fprint(w, `{"value": []}`)
+	}))
+	defer server.Close()
+
+	controller := NewAzureGatewayClientController(AzureCredentialInfo{
+		ResourceGroupName: "group",
+		SubscriptionID:    "subscription",
+		Authorizer:        autorest.NullAuthorizer{},
+		Environment:       azure.Environment{Name: "AzureStackCloud", ResourceManagerEndpoint: server.URL},
+	}, ProvisioningOptions{}, nil)
+
+	if _, err := controller.gatewayClient.List("group"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	select {
+	case path := <-requests:
+		if !strings.HasPrefix(path, "/subscriptions/subscription/resourceGroups/group/") {
+			t.Errorf("expected the gateways of the resource group to be listed, got %v", path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the resource manager of the environment to be called")
+	}
+}
+
+func TestManagedIdentityRequestsTokensForTheEnvironment(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("resource") != azure.ChinaCloud.ServiceManagementEndpoint {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, `{"access_token": "china-token", "expires_on": "%v"}`, time.Now().Add(time.Hour).Unix())
+	}))
+	defer server.Close()
+
+	authorizer, err := NewAuthorizer(AuthenticationConfig{UseManagedIdentity: true, IdentityEndpoint: server.URL, Environment: azure.ChinaCloud})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := authorizer.(tokenRefresher).EnsureFresh(); err != nil {
+		t.Errorf("expected a token for the resource of the China cloud, got %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package azurecontroller
+
+import (
+	"net/http"
+
+	"github.com/Azure/azure-sdk-for-go/arm/network"
+	"github.com/Azure/go-autorest/autorest"
+)
+
+//maxConcurrentUpdateRetries bounds how often a write is recomputed after
+//another writer changed the gateway first
+const maxConcurrentUpdateRetries = 3
+
+//etagGatewayClient adds conditional writes to the Azure gateway client, which
+//has no way to send an If-Match header
+type etagGatewayClient struct {
+	network.ApplicationGatewaysClient
+}
+
+//CreateOrUpdateIfMatch updates a gateway only while its Etag still matches,
+//Azure answers 412 Precondition Failed when it changed in the meantime
+func (client etagGatewayClient) CreateOrUpdateIfMatch(resourceGroupName string, applicationGatewayName string, parameters network.ApplicationGateway, etag string, cancel <-chan struct{}) (result autorest.Response, err error) {
+	req, err := client.CreateOrUpdatePreparer(resourceGroupName, applicationGatewayName, parameters, cancel)
+	if err == nil && etag != "" {
+		req, err = autorest.Prepare(req, autorest.WithHeader("If-Match", etag))
+	}
+	if err != nil {
+		return result, autorest.NewErrorWithError(err, "azurecontroller.etagGatewayClient", "CreateOrUpdateIfMatch", nil, "Failure preparing request")
+	}
+
+	resp, err := client.CreateOrUpdateSender(req)
+	if err != nil {
+		result.Response = resp
+		return result, autorest.NewErrorWithError(err, "azurecontroller.etagGatewayClient", "CreateOrUpdateIfMatch", resp, "Failure sending request")
+	}
+
+	result, err = client.CreateOrUpdateResponder(resp)
+	if err != nil {
+		err = autorest.NewErrorWithError(err, "azurecontroller.etagGatewayClient", "CreateOrUpdateIfMatch", resp, "Failure responding to request")
+	}
+	return result, err
+}
+
+//isPreconditionFailed determines if a conditional write lost against another writer
+func isPreconditionFailed(err error) bool {
+	detailedError, ok := err.(autorest.DetailedError)
+	return ok && detailedError.StatusCode == http.StatusPreconditionFailed
+}
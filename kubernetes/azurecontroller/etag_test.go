@@ -0,0 +1,62 @@
+package azurecontroller
+
+import (
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/arm/network"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+//racingGatewayClient changes the gateway right before the next writes of the controller
+type racingGatewayClient struct {
+	*fakeGatewayClient
+	races int
+}
+
+func (client *racingGatewayClient) CreateOrUpdateIfMatch(resourceGroupName string, applicationGatewayName string, parameters network.ApplicationGateway, etag string, cancel <-chan struct{}) (autorest.Response, error) {
+	if client.races > 0 {
+		client.races--
+		live := client.gateways[applicationGatewayName]
+		live.Tags = &map[string]*string{"owner": to.StringPtr("portal")}
+		client.CreateOrUpdate(resourceGroupName, applicationGatewayName, live, cancel)
+	}
+	return client.fakeGatewayClient.CreateOrUpdateIfMatch(resourceGroupName, applicationGatewayName, parameters, etag, cancel)
+}
+
+func TestSyncRecomputesConcurrentUpdates(t *testing.T) {
+	controller, gatewayClient, _ := newTestController()
+	ingress := newTestIngress(newTestRule("foo.example.com", newTestPath("/", "web", 80)))
+	createTestGateway(t, controller, ingress)
+	controller.gatewayClient = &racingGatewayClient{fakeGatewayClient: gatewayClient, races: 1}
+
+	ingress.Spec.Rules = append(ingress.Spec.Rules, newTestRule("bar.example.com", newTestPath("/", "web", 80)))
+	result, err := controller.SyncApplicationGateway("web", []IngressState{{Ingress: ingress}})
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if !result.Updated || result.ConcurrentUpdates != 1 {
+		t.Errorf("expected the update to be recomputed once, got %+v", result)
+	}
+
+	updated := gatewayClient.gateways["web"]
+	if updated.Tags == nil || to.String((*updated.Tags)["owner"]) != "portal" {
+		t.Errorf("expected the concurrent change to be kept, got %v", updated.Tags)
+	}
+	if len(*updated.Properties.HTTPListeners) != 2 {
+		t.Errorf("expected the ingress change to be applied, got %v listeners", len(*updated.Properties.HTTPListeners))
+	}
+}
+
+func TestSyncGivesUpOnPersistentConflicts(t *testing.T) {
+	controller, gatewayClient, _ := newTestController()
+	ingress := newTestIngress(newTestRule("foo.example.com", newTestPath("/", "web", 80)))
+	createTestGateway(t, controller, ingress)
+	controller.gatewayClient = &racingGatewayClient{fakeGatewayClient: gatewayClient, races: maxConcurrentUpdateRetries + 1}
+
+	ingress.Spec.Rules = append(ingress.Spec.Rules, newTestRule("bar.example.com", newTestPath("/", "web", 80)))
+	result, err := controller.SyncApplicationGateway("web", []IngressState{{Ingress: ingress}})
+	if !isPreconditionFailed(err) || result.ConcurrentUpdates != maxConcurrentUpdateRetries {
+		t.Errorf("expected the conflict to be returned after %v retries, got %+v %v", maxConcurrentUpdateRetries, result, err)
+	}
+}
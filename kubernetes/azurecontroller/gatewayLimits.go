@@ -0,0 +1,73 @@
+package azurecontroller
+
+import (
+	"fmt"
+	"strings"
+)
+
+//GatewayLimits holds how many sub resources Azure accepts on a single gateway
+type GatewayLimits struct {
+	Listeners    int
+	BackendPools int
+	// PathRules is the limit of a single URL path map
+	PathRules                  int
+	SslCertificates            int
+	AuthenticationCertificates int
+}
+
+//DefaultGatewayLimits are the limits Azure enforces on every application gateway
+var DefaultGatewayLimits = GatewayLimits{
+	Listeners:                  20,
+	BackendPools:               20,
+	PathRules:                  100,
+	SslCertificates:            20,
+	AuthenticationCertificates: 5,
+}
+
+//GatewayUsage counts the sub resources of a gateway subject to the Azure limits
+type GatewayUsage struct {
+	Listeners    int
+	BackendPools int
+	// PathRules is the size of the largest URL path map
+	PathRules                  int
+	SslCertificates            int
+	AuthenticationCertificates int
+}
+
+//MeasureGateway counts the sub resources a gateway shared by the ingresses needs
+func MeasureGateway(states []IngressState) GatewayUsage {
+	properties := TranslateIngresses(GatewayConfig{}, states).Properties
+
+	usage := GatewayUsage{
+		Listeners:                  len(*properties.HTTPListeners),
+		BackendPools:               len(*properties.BackendAddressPools),
+		SslCertificates:            len(*properties.SslCertificates),
+		AuthenticationCertificates: len(*properties.AuthenticationCertificates),
+	}
+	for _, pathMap := range *properties.URLPathMaps {
+		if rules := len(*pathMap.Properties.PathRules); rules > usage.PathRules {
+			usage.PathRules = rules
+		}
+	}
+	return usage
+}
+
+//Exceeds reports every limit the usage goes over, nil when the gateway fits
+func (usage GatewayUsage) Exceeds(limits GatewayLimits) error {
+	exceeded := []string{}
+	check := func(used, limit int, resource string) {
+		if used > limit {
+			exceeded = append(exceeded, fmt.Sprintf("%d %s of %d", used, resource, limit))
+		}
+	}
+	check(usage.Listeners, limits.Listeners, "listeners")
+	check(usage.BackendPools, limits.BackendPools, "backend pools")
+	check(usage.PathRules, limits.PathRules, "path rules")
+	check(usage.SslCertificates, limits.SslCertificates, "certificates")
+	check(usage.AuthenticationCertificates, limits.AuthenticationCertificates, "authentication certificates")
+
+	if len(exceeded) == 0 {
+		return nil
+	}
+	return fmt.Errorf("gateway exceeds the Azure limits with %s", strings.Join(exceeded, ", "))
+}
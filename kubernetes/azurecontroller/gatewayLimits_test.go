@@ -0,0 +1,31 @@
+package azurecontroller
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMeasureGateway(t *testing.T) {
+	web := newTestIngress(
+		newTestRule("foo.example.com", newTestPath("/api", "api", 80), newTestPath("/static", "static", 80)),
+		newTestRule("bar.example.com", newTestPath("/", "web", 80)),
+	)
+	states := []IngressState{{Ingress: web, Certificates: map[string]TLSCertificate{}}}
+
+	usage := MeasureGateway(states)
+	expected := GatewayUsage{Listeners: 2, BackendPools: 4, PathRules: 2}
+	if usage != expected {
+		t.Errorf("expected %+v, got %+v", expected, usage)
+	}
+
+	if err := usage.Exceeds(DefaultGatewayLimits); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	limits := DefaultGatewayLimits
+	limits.Listeners = 1
+	limits.PathRules = 1
+	err := usage.Exceeds(limits)
+	if err == nil || !strings.Contains(err.Error(), "2 listeners of 1") || !strings.Contains(err.Error(), "2 path rules of 1") {
+		t.Errorf("expected the listeners and path rules to be reported, got %v", err)
+	}
+}
@@ -0,0 +1,233 @@
+package azurecontroller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+//Conflict reports a route of an ingress which an older ingress sharing the same
+//gateway already serves. The route of the newer ingress is left out.
+type Conflict struct {
+	Ingress *extensions.Ingress
+	Owner   *extensions.Ingress
+
+	Host string
+	// Path is empty for a conflicting default backend, Host as well for
+	// the default backend of the gateway
+	Path string
+}
+
+func (conflict Conflict) Error() string {
+	route := fmt.Sprintf("host %q path %q", conflict.Host, conflict.Path)
+	switch {
+	case conflict.Path == "" && conflict.Host == "":
+		route = "the default backend"
+	case conflict.Path == "":
+		route = fmt.Sprintf("the default backend of host %q", conflict.Host)
+	}
+	return fmt.Sprintf("%s is already served by ingress %s/%s on the same gateway",
+		route, conflict.Owner.Namespace, conflict.Owner.Name)
+}
+
+//Conflicts returns the routes left out when the ingresses are merged into one gateway
+func Conflicts(states []IngressState) []Conflict {
+	return planGateway(states).conflicts
+}
+
+//backendSpec is a backend together with the state of the ingress referencing it
+type backendSpec struct {
+	key   backendKey
+	state *IngressState
+}
+
+func (spec backendSpec) target() BackendTarget {
+	return spec.state.Backends[spec.key.ingressBackend()]
+}
+
+//gatewayPlan is the routing of every ingress sharing a gateway merged together
+type gatewayPlan struct {
+	states    []*IngressState
+	listeners []listenerSpec
+	backends  map[string]backendSpec
+
+	// defaultBackend receives requests for hosts no listener matches, nil
+	// when no ingress has a default backend
+	defaultBackend *backendKey
+
+	conflicts []Conflict
+}
+
+//planGateway merges the ingresses of a gateway. Older ingresses win routes
+//claimed by several ingresses, so adding an ingress never changes the routing
+//of the ingresses already served.
+func planGateway(states []IngressState) gatewayPlan {
+	plan := gatewayPlan{backends: map[string]backendSpec{}}
+	for index := range states {
+		plan.states = append(plan.states, &states[index])
+	}
+	sort.Sort(statesByAge(plan.states))
+
+	owners := map[string]*extensions.Ingress{}
+	// claim returns the ingress serving a route, the given ingress unless an
+	// older one claimed the route first
+	claim := func(ingress *extensions.Ingress, host, path string) *extensions.Ingress {
+		key := host + " " + path
+		if owner, claimed := owners[key]; claimed {
+			return owner
+		}
+		owners[key] = ingress
+		return ingress
+	}
+
+	byHost := map[string]*listenerSpec{}
+	listener := func(host string) *listenerSpec {
+		spec, ok := byHost[host]
+		if !ok {
+			spec = &listenerSpec{host: host}
+			byHost[host] = spec
+		}
+		return spec
+	}
+
+	for _, state := range plan.states {
+		ingress := state.Ingress
+
+		defaultBackend, hasDefaultBackend := ingressDefaultBackend(ingress)
+		if hasDefaultBackend {
+			if owner := claim(ingress, "", ""); owner == ingress {
+				plan.defaultBackend = &defaultBackend
+				plan.backends[defaultBackend.String()] = backendSpec{key: defaultBackend, state: state}
+			} else {
+				plan.conflicts = append(plan.conflicts, Conflict{Ingress: ingress, Owner: owner})
+			}
+		}
+
+		for _, spec := range ingressListeners(ingress) {
+			merged := listener(spec.host)
+			merged.states = append(merged.states, state)
+			if hasDefaultBackend {
+				// the rules without a host share the claim of the gateway
+				// default backend, whose conflict is already reported
+				if owner := claim(ingress, spec.host, ""); owner == ingress {
+					merged.defaultBackend = &defaultBackend
+					plan.backends[defaultBackend.String()] = backendSpec{key: defaultBackend, state: state}
+				} else if spec.host != "" {
+					plan.conflicts = append(plan.conflicts, Conflict{Ingress: ingress, Owner: owner, Host: spec.host})
+				}
+			}
+			for _, rule := range spec.rules {
+				// every pattern is claimed on its own, the paths /api and
+				// /api/ both route /api/*
+				paths, lost := []string{}, []string{}
+				var owner *extensions.Ingress
+				for _, path := range rule.paths {
+					if claimant := claim(ingress, spec.host, path); claimant != ingress {
+						owner = claimant
+						lost = append(lost, path)
+						continue
+					}
+					paths = append(paths, path)
+				}
+				if len(lost) > 0 {
+					plan.conflicts = append(plan.conflicts, Conflict{Ingress: ingress, Owner: owner, Host: spec.host, Path: strings.Join(lost, ",")})
+				}
+				if len(paths) == 0 {
+					continue
+				}
+				rule.paths = paths
+				plan.backends[rule.backend.String()] = backendSpec{key: rule.backend, state: state}
+				merged.rules = append(merged.rules, rule)
+			}
+		}
+	}
+
+	hosts := []string{}
+	for host := range byHost {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		plan.listeners = append(plan.listeners, *byHost[host])
+	}
+
+	return plan
+}
+
+//listenerCertificate picks the certificate of the oldest ingress naming the host
+//in its TLS section, falling back to TLS entries without hosts of the ingresses
+//routing the host
+func (plan gatewayPlan) listenerCertificate(listener listenerSpec) (string, TLSCertificate, bool) {
+	for _, state := range plan.states {
+		for _, tls := range state.Ingress.Spec.TLS {
+			certificate, ok := state.Certificates[tls.SecretName]
+			if !ok {
+				continue
+			}
+			for _, host := range tls.Hosts {
+				if host == listener.host {
+					return certificateName(state.Ingress.Namespace, tls.SecretName, certificate), certificate, true
+				}
+			}
+		}
+	}
+	for _, state := range listener.states {
+		for _, tls := range state.Ingress.Spec.TLS {
+			certificate, ok := state.Certificates[tls.SecretName]
+			if ok && len(tls.Hosts) == 0 {
+				return certificateName(state.Ingress.Namespace, tls.SecretName, certificate), certificate, true
+			}
+		}
+	}
+	return "", TLSCertificate{}, false
+}
+
+//gatewaySettings merges the gateway wide settings of the ingresses, the oldest
+//ingress setting a value wins
+func gatewaySettings(states []IngressState) GatewaySettings {
+	sorted := []*IngressState{}
+	for index := range states {
+		sorted = append(sorted, &states[index])
+	}
+	sort.Sort(statesByAge(sorted))
+
+	merged := GatewaySettings{}
+	for _, state := range sorted {
+		settings := state.Settings
+		if merged.GatewayName == "" {
+			merged.GatewayName = settings.GatewayName
+		}
+		if len(merged.DisabledSslProtocols) == 0 {
+			merged.DisabledSslProtocols = settings.DisabledSslProtocols
+		}
+		if merged.SkuName == "" {
+			merged.SkuName = settings.SkuName
+		}
+		if merged.SkuTier == "" {
+			merged.SkuTier = settings.SkuTier
+		}
+		if merged.Capacity == 0 {
+			merged.Capacity = settings.Capacity
+		}
+	}
+	return merged
+}
+
+//statesByAge orders ingresses from the oldest to the newest, ingresses created
+//in the same second are ordered by namespace and name
+type statesByAge []*IngressState
+
+func (states statesByAge) Len() int      { return len(states) }
+func (states statesByAge) Swap(i, j int) { states[i], states[j] = states[j], states[i] }
+func (states statesByAge) Less(i, j int) bool {
+	first, second := states[i].Ingress, states[j].Ingress
+	if !first.CreationTimestamp.Equal(second.CreationTimestamp) {
+		return first.CreationTimestamp.Before(second.CreationTimestamp)
+	}
+	if first.Namespace != second.Namespace {
+		return first.Namespace < second.Namespace
+	}
+	return first.Name < second.Name
+}
@@ -0,0 +1,145 @@
+package azurecontroller
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/util/intstr"
+)
+
+func newTestSharedIngress(namespace, name string, age time.Duration, rules ...extensions.IngressRule) *extensions.Ingress {
+	ingress := newTestIngress(rules...)
+	ingress.Namespace = namespace
+	ingress.Name = name
+	ingress.CreationTimestamp = unversioned.NewTime(time.Unix(1500000000, 0).Add(-age))
+	return ingress
+}
+
+func TestTranslateIngressesSharedHost(t *testing.T) {
+	shop := newTestSharedIngress("shop", "store", time.Minute, newTestRule("foo.example.com", newTestPath("/shop", "shop", 80)))
+	web := newTestSharedIngress("default", "web", time.Hour,
+		newTestRule("foo.example.com", newTestPath("/api", "api", 80)),
+		newTestRule("bar.example.com", newTestPath("/", "web", 80)),
+	)
+
+	properties := TranslateIngresses(testGatewayConfig, []IngressState{{Ingress: shop}, {Ingress: web}}).Properties
+
+	if got := names(properties.HTTPListeners); !reflect.DeepEqual(got, []string{"listener-bar.example.com", "listener-foo.example.com"}) {
+		t.Errorf("expected a listener per host, got %v", got)
+	}
+	expectedPools := []string{"pool-default-web-api-80", "pool-default-web-web-80", "pool-shop-store-shop-80", "pool-defaultbackend"}
+	if got := names(properties.BackendAddressPools); !reflect.DeepEqual(got, expectedPools) {
+		t.Errorf("expected a pool per ingress backend, got %v", got)
+	}
+
+	// the older ingress comes first so adding an ingress keeps existing routes
+	pathRules := *(*properties.URLPathMaps)[1].Properties.PathRules
+	if len(pathRules) != 2 {
+		t.Fatalf("expected the paths of both ingresses, got %v", len(pathRules))
+	}
+	first := to.String(pathRules[0].Properties.BackendAddressPool.ID)
+	if expected := testGatewayConfig.gatewayResourceID() + "/backendAddressPools/pool-default-web-api-80"; first != expected {
+		t.Errorf("expected the first path to target %v, got %v", expected, first)
+	}
+}
+
+func TestConflicts(t *testing.T) {
+	older := newTestSharedIngress("default", "web", time.Hour, newTestRule("foo.example.com", newTestPath("/api", "api", 80)))
+	older.Spec.Backend = &extensions.IngressBackend{ServiceName: "web", ServicePort: intstr.FromInt(80)}
+	newer := newTestSharedIngress("shop", "store", time.Minute,
+		newTestRule("foo.example.com", newTestPath("/api", "shop", 80), newTestPath("/shop", "shop", 80)),
+	)
+	newer.Spec.Backend = &extensions.IngressBackend{ServiceName: "shop", ServicePort: intstr.FromInt(80)}
+	states := []IngressState{{Ingress: newer}, {Ingress: older}}
+
+	conflicts := Conflicts(states)
+	if len(conflicts) != 3 {
+		t.Fatalf("expected the default backends of the gateway and of the host and the path to conflict, got %v", conflicts)
+	}
+	routes := []string{}
+	for _, conflict := range conflicts {
+		if conflict.Ingress != newer || conflict.Owner != older {
+			t.Errorf("expected the older ingress to keep %v %v", conflict.Host, conflict.Path)
+		}
+		routes = append(routes, conflict.Host+" "+conflict.Path)
+	}
+	if !reflect.DeepEqual(routes, []string{" ", "foo.example.com ", "foo.example.com /api,/api/*"}) {
+		t.Errorf("unexpected conflicting routes %q", routes)
+	}
+
+	properties := TranslateIngresses(testGatewayConfig, states).Properties
+	pathRules := *(*properties.URLPathMaps)[0].Properties.PathRules
+	if len(pathRules) != 2 {
+		t.Fatalf("expected the conflicting path to be left out, got %v rules", len(pathRules))
+	}
+	for index, expected := range []string{"pool-default-web-api-80", "pool-shop-store-shop-80"} {
+		pool := to.String(pathRules[index].Properties.BackendAddressPool.ID)
+		if pool != testGatewayConfig.gatewayResourceID()+"/backendAddressPools/"+expected {
+			t.Errorf("expected path %v to target %v, got %v", index, expected, pool)
+		}
+	}
+	if got := names(properties.BackendAddressPools); len(got) != 3 {
+		t.Errorf("expected the pool of the conflicting path to be left out, got %v", got)
+	}
+}
+
+func TestConflictsClaimEveryPattern(t *testing.T) {
+	older := newTestSharedIngress("default", "web", time.Hour, newTestRule("foo.example.com", newTestPath("/api/", "api", 80)))
+	newer := newTestSharedIngress("shop", "store", time.Minute, newTestRule("foo.example.com", newTestPath("/api", "shop", 80)))
+	plan := planGateway([]IngressState{{Ingress: newer}, {Ingress: older}})
+
+	if len(plan.conflicts) != 1 || plan.conflicts[0].Ingress != newer || plan.conflicts[0].Path != "/api/*" {
+		t.Fatalf("expected the pattern both paths route to conflict, got %v", plan.conflicts)
+	}
+	rules := plan.listeners[0].rules
+	if len(rules) != 2 || !reflect.DeepEqual(rules[0].paths, []string{"/api/*"}) || !reflect.DeepEqual(rules[1].paths, []string{"/api"}) {
+		t.Errorf("expected the newer ingress to keep the pattern nobody else routes, got %+v", rules)
+	}
+}
+
+func TestConflictingDefaultBackendAddsNoPool(t *testing.T) {
+	older := newTestSharedIngress("default", "web", time.Hour, newTestRule("foo.example.com", newTestPath("/api", "api", 80)))
+	older.Spec.Backend = &extensions.IngressBackend{ServiceName: "web", ServicePort: intstr.FromInt(80)}
+	newer := newTestSharedIngress("shop", "store", time.Minute, newTestRule("foo.example.com", newTestPath("/shop", "shop", 80)))
+	newer.Spec.Backend = &extensions.IngressBackend{ServiceName: "fallback", ServicePort: intstr.FromInt(80)}
+
+	properties := TranslateIngresses(testGatewayConfig, []IngressState{{Ingress: newer}, {Ingress: older}}).Properties
+	for _, name := range names(properties.BackendAddressPools) {
+		if name == "pool-shop-store-fallback-80" {
+			t.Errorf("expected the default backend that lost to get no pool, got %v", names(properties.BackendAddressPools))
+		}
+	}
+	for _, name := range names(properties.BackendHTTPSettingsCollection) {
+		if name == "settings-shop-store-fallback-80" {
+			t.Errorf("expected the default backend that lost to get no settings, got %v", names(properties.BackendHTTPSettingsCollection))
+		}
+	}
+}
+
+func TestTranslateIngressesSharedCertificate(t *testing.T) {
+	certPEM, keyPEM := newTestCertificatePEM(t, "foo.example.com")
+	certificate, err := NewTLSCertificate(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	states := []IngressState{}
+	for _, name := range []string{"api", "web"} {
+		ingress := newTestSharedIngress("default", name, 0, newTestRule("foo.example.com", newTestPath("/"+name, name, 80)))
+		ingress.Spec.TLS = []extensions.IngressTLS{{Hosts: []string{"foo.example.com"}, SecretName: "web-tls"}}
+		states = append(states, IngressState{Ingress: ingress, Certificates: map[string]TLSCertificate{"web-tls": certificate}})
+	}
+
+	properties := TranslateIngresses(testGatewayConfig, states).Properties
+	if got := names(properties.SslCertificates); len(got) != 1 {
+		t.Errorf("expected the shared secret once, got %v", got)
+	}
+	if got := names(properties.HTTPListeners); !reflect.DeepEqual(got, []string{"listener-foo.example.com", "listener-foo.example.com-https"}) {
+		t.Errorf("unexpected listeners %v", got)
+	}
+}
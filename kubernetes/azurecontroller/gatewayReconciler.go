@@ -0,0 +1,206 @@
+package azurecontroller
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/arm/network"
+)
+
+//readOnlyProperties are filled in by Azure and never part of a desired gateway
+var readOnlyProperties = map[string]bool{
+	"etag":              true,
+	"type":              true,
+	"provisioningState": true,
+	"resourceGuid":      true,
+	"operationalState":  true,
+	"publicCertData":    true,
+}
+
+//writeOnlyProperties are accepted by Azure but never returned, certificates
+//are told apart by the fingerprint in their name instead
+var writeOnlyProperties = map[string]bool{
+	"data":     true,
+	"password": true,
+}
+
+//controllerNamePrefixes are the prefixes of the sub resource names the
+//translator generates, see resourceName
+var controllerNamePrefixes = []string{"sslcert-", "authcert-", "port-", "probe-", "pool-", "settings-", "listener-", "urlpathmap-", "rule-"}
+
+//mergeGateway overlays the sub resources the controller generates from desired
+//onto the live gateway, matching them by name. Sub resources of the live
+//gateway the controller did not name, e.g. those configured in the portal, are
+//kept, as are the tags, the sku and the IP configurations.
+func mergeGateway(live, desired network.ApplicationGateway) network.ApplicationGateway {
+	merged := live
+
+	properties := network.ApplicationGatewayPropertiesFormat{}
+	if live.Properties != nil {
+		properties = *live.Properties
+	}
+	if desired.Properties != nil {
+		mergeNamed(&properties.SslCertificates, desired.Properties.SslCertificates)
+		mergeNamed(&properties.AuthenticationCertificates, desired.Properties.AuthenticationCertificates)
+		mergeNamed(&properties.FrontendPorts, desired.Properties.FrontendPorts)
+		mergeNamed(&properties.Probes, desired.Properties.Probes)
+		mergeNamed(&properties.BackendAddressPools, desired.Properties.BackendAddressPools)
+		mergeNamed(&properties.BackendHTTPSettingsCollection, desired.Properties.BackendHTTPSettingsCollection)
+		mergeNamed(&properties.HTTPListeners, desired.Properties.HTTPListeners)
+		mergeNamed(&properties.URLPathMaps, desired.Properties.URLPathMaps)
+		mergeNamed(&properties.RequestRoutingRules, desired.Properties.RequestRoutingRules)
+		properties.SslPolicy = desired.Properties.SslPolicy
+	}
+
+	// the operational state is read only and rejected by CreateOrUpdate
+	properties.OperationalState = ""
+	merged.Properties = &properties
+
+	return merged
+}
+
+//mergeNamed merges a desired collection of sub resources into the live one it
+//points to. Both are pointers to slices of structs with a Name *string field.
+//Live entries are replaced by the desired entry of the same name in place, so
+//an unchanged gateway keeps the order Azure returns, and desired entries
+//missing from the live collection are appended. Live entries the controller
+//named which are no longer desired are dropped, any other entry is kept.
+func mergeNamed(live interface{}, desired interface{}) {
+	liveValue := reflect.ValueOf(live).Elem()
+	desiredValue := reflect.ValueOf(desired)
+	if desiredValue.IsNil() {
+		desiredValue = reflect.New(liveValue.Type().Elem())
+		desiredValue.Elem().Set(reflect.MakeSlice(liveValue.Type().Elem(), 0, 0))
+	}
+	desiredItems := desiredValue.Elem()
+
+	desiredIndex := map[string]int{}
+	for index := 0; index < desiredItems.Len(); index++ {
+		desiredIndex[itemName(desiredItems.Index(index))] = index
+	}
+
+	merged := reflect.MakeSlice(desiredItems.Type(), 0, desiredItems.Len())
+	mergedNames := map[string]bool{}
+	if !liveValue.IsNil() {
+		liveItems := liveValue.Elem()
+		for index := 0; index < liveItems.Len(); index++ {
+			item := liveItems.Index(index)
+			name := itemName(item)
+			if desired, ok := desiredIndex[name]; ok {
+				item = desiredItems.Index(desired)
+			} else if namedByController(name) {
+				continue
+			}
+			merged = reflect.Append(merged, item)
+			mergedNames[name] = true
+		}
+	}
+	for index := 0; index < desiredItems.Len(); index++ {
+		item := desiredItems.Index(index)
+		if !mergedNames[itemName(item)] {
+			merged = reflect.Append(merged, item)
+		}
+	}
+
+	result := reflect.New(merged.Type())
+	result.Elem().Set(merged)
+	liveValue.Set(result)
+}
+
+func itemName(item reflect.Value) string {
+	name, _ := item.FieldByName("Name").Interface().(*string)
+	if name == nil {
+		return ""
+	}
+	return *name
+}
+
+//namedByController determines if a sub resource name is one the translator
+//generates
+func namedByController(name string) bool {
+	for _, prefix := range controllerNamePrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+//gatewaysEqual compares the user settable parts of two gateways, ignoring
+//read only fields and the identifiers Azure assigns to sub resources
+func gatewaysEqual(first, second network.ApplicationGateway) (bool, error) {
+	firstValue, err := comparableGateway(first)
+	if err != nil {
+		return false, err
+	}
+	secondValue, err := comparableGateway(second)
+	if err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(firstValue, secondValue), nil
+}
+
+//comparableGateway reduces a gateway to a generic JSON value holding only the
+//fields that describe its configuration
+func comparableGateway(gateway network.ApplicationGateway) (interface{}, error) {
+	data, err := json.Marshal(gateway)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	return normalizeValue(value), nil
+}
+
+func normalizeValue(value interface{}) interface{} {
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		// named resources carry their own generated id, references to
+		// other resources only carry an id which Azure may return in a
+		// different case
+		_, named := typed["name"]
+		result := map[string]interface{}{}
+		for key, item := range typed {
+			if readOnlyProperties[key] || writeOnlyProperties[key] || (named && key == "id") {
+				continue
+			}
+			if id, ok := item.(string); ok && key == "id" {
+				item = strings.ToLower(id)
+			}
+			item = normalizeValue(item)
+			if isEmptyValue(item) {
+				continue
+			}
+			result[key] = item
+		}
+		return result
+	case []interface{}:
+		result := []interface{}{}
+		for _, item := range typed {
+			result = append(result, normalizeValue(item))
+		}
+		return result
+	}
+	return value
+}
+
+//isEmptyValue treats values Azure omits and values it returns as defaults alike
+func isEmptyValue(value interface{}) bool {
+	switch typed := value.(type) {
+	case nil:
+		return true
+	case bool:
+		return !typed
+	case string:
+		return typed == ""
+	case map[string]interface{}:
+		return len(typed) == 0
+	case []interface{}:
+		return len(typed) == 0
+	}
+	return false
+}
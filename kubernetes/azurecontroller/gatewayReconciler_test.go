@@ -0,0 +1,208 @@
+package azurecontroller
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/arm/network"
+	"github.com/Azure/go-autorest/autorest/to"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+func createTestGateway(t *testing.T, controller *AzureGatewayClientController, ingress *extensions.Ingress) {
+	if _, err := controller.SyncApplicationGateway("web", []IngressState{{Ingress: ingress}}); err != nil {
+		t.Fatalf("unexpected error creating the gateway %v", err)
+	}
+}
+
+//decorateLiveGateway adds the read only fields Azure returns on a Get
+func decorateLiveGateway(gateway network.ApplicationGateway) network.ApplicationGateway {
+	config := testGatewayConfig
+	config.GatewayName = to.String(gateway.Name)
+
+	gateway.ID = to.StringPtr(config.gatewayResourceID())
+	gateway.Etag = to.StringPtr("W/\"1\"")
+	gateway.Properties.OperationalState = network.Running
+	gateway.Properties.ProvisioningState = to.StringPtr("Succeeded")
+
+	listeners := *gateway.Properties.HTTPListeners
+	for index := range listeners {
+		listeners[index].ID = config.subResource("httpListeners", to.String(listeners[index].Name)).ID
+		listeners[index].Properties.RequireServerNameIndication = to.BoolPtr(false)
+		listeners[index].Properties.ProvisioningState = to.StringPtr("Succeeded")
+		listeners[index].Properties.FrontendPort.ID = to.StringPtr(strings.ToUpper(to.String(listeners[index].Properties.FrontendPort.ID)))
+	}
+
+	// Azure returns the defaults of the settings a PUT omitted
+	settings := *gateway.Properties.BackendHTTPSettingsCollection
+	for index := range settings {
+		settings[index].ID = config.subResource("backendHttpSettingsCollection", to.String(settings[index].Name)).ID
+		settings[index].Properties.ProvisioningState = to.StringPtr("Succeeded")
+		if settings[index].Properties.RequestTimeout == nil {
+			settings[index].Properties.RequestTimeout = to.Int32Ptr(30)
+		}
+	}
+	return gateway
+}
+
+func TestReconcileUnchangedGatewayDoesNotWrite(t *testing.T) {
+	controller, gatewayClient, _ := newTestController()
+	ingress := newTestIngress(newTestRule("foo.example.com", newTestPath("/api", "api", 80)))
+	createTestGateway(t, controller, ingress)
+
+	gatewayClient.gateways["web"] = decorateLiveGateway(gatewayClient.gateways["web"])
+	gatewayClient.writes = nil
+
+	if _, err := controller.SyncApplicationGateway("web", []IngressState{{Ingress: ingress}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(gatewayClient.writes) != 0 {
+		t.Errorf("expected no writes to an unchanged gateway, got %v", gatewayClient.writes)
+	}
+}
+
+func TestReconcileCorrectsDrift(t *testing.T) {
+	controller, gatewayClient, _ := newTestController()
+	ingress := newTestIngress(newTestRule("foo.example.com", newTestPath("/api", "api", 80)))
+	createTestGateway(t, controller, ingress)
+
+	// simulate a listener being removed and a tag being added in the portal
+	live := decorateLiveGateway(gatewayClient.gateways["web"])
+	live.Properties.HTTPListeners = &[]network.ApplicationGatewayHTTPListener{}
+	live.Tags = &map[string]*string{"owner": to.StringPtr("portal")}
+	gatewayClient.gateways["web"] = live
+	gatewayClient.writes = nil
+
+	if _, err := controller.SyncApplicationGateway("web", []IngressState{{Ingress: ingress}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(gatewayClient.writes) != 1 {
+		t.Fatalf("expected a single write, got %v", gatewayClient.writes)
+	}
+
+	updated := gatewayClient.gateways["web"]
+	if got := names(updated.Properties.HTTPListeners); len(got) != 1 || got[0] != "listener-foo.example.com" {
+		t.Errorf("expected the listener to be restored, got %v", got)
+	}
+	if updated.Tags == nil || to.String((*updated.Tags)["owner"]) != "portal" {
+		t.Errorf("expected tags the controller does not own to be preserved, got %v", updated.Tags)
+	}
+	if updated.Properties.OperationalState != "" {
+		t.Errorf("expected the read only operational state to be cleared, got %v", updated.Properties.OperationalState)
+	}
+}
+
+func TestReconcileAppliesIngressChanges(t *testing.T) {
+	controller, gatewayClient, _ := newTestController()
+	ingress := newTestIngress(newTestRule("foo.example.com", newTestPath("/api", "api", 80)))
+	createTestGateway(t, controller, ingress)
+	gatewayClient.writes = nil
+
+	ingress.Spec.Rules[0].HTTP.Paths = append(ingress.Spec.Rules[0].HTTP.Paths, newTestPath("/web", "web", 80))
+	if _, err := controller.SyncApplicationGateway("web", []IngressState{{Ingress: ingress}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(gatewayClient.writes) != 1 {
+		t.Fatalf("expected a single write, got %v", gatewayClient.writes)
+	}
+	pathRules := *(*gatewayClient.gateways["web"].Properties.URLPathMaps)[0].Properties.PathRules
+	if len(pathRules) != 2 {
+		t.Errorf("expected two path rules, got %v", len(pathRules))
+	}
+}
+
+func TestReconcileAppliesSettings(t *testing.T) {
+	controller, gatewayClient, _ := newTestController()
+	ingress := newTestIngress(newTestRule("foo.example.com", newTestPath("/api", "api", 80)))
+	createTestGateway(t, controller, ingress)
+	gatewayClient.gateways["web"] = decorateLiveGateway(gatewayClient.gateways["web"])
+	gatewayClient.writes = nil
+
+	settings := GatewaySettings{
+		CookieBasedAffinity:  true,
+		RequestTimeout:       60,
+		DisabledSslProtocols: []network.ApplicationGatewaySslProtocol{network.TLSv10},
+		Capacity:             5,
+	}
+	if _, err := controller.SyncApplicationGateway("web", []IngressState{{Ingress: ingress, Settings: settings}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(gatewayClient.writes) != 1 {
+		t.Fatalf("expected a single write, got %v", gatewayClient.writes)
+	}
+
+	properties := gatewayClient.gateways["web"].Properties
+	if sku := properties.Sku; sku.Name != network.StandardMedium || to.Int32(sku.Capacity) != 5 {
+		t.Errorf("expected the capacity to change and the size to be kept, got %v %v", sku.Name, to.Int32(sku.Capacity))
+	}
+	backendSettings := (*properties.BackendHTTPSettingsCollection)[0].Properties
+	if backendSettings.CookieBasedAffinity != network.Enabled || to.Int32(backendSettings.RequestTimeout) != 60 {
+		t.Errorf("unexpected backend settings %+v", backendSettings)
+	}
+	if properties.SslPolicy == nil || len(*properties.SslPolicy.DisabledSslProtocols) != 1 {
+		t.Errorf("expected TLS 1.0 to be disabled, got %+v", properties.SslPolicy)
+	}
+}
+
+func TestSyncSharedGatewayRemovesOnlyItsIngress(t *testing.T) {
+	controller, gatewayClient, _ := newTestController()
+	web := newTestIngress(newTestRule("foo.example.com", newTestPath("/api", "api", 80)))
+	shop := newTestIngress(newTestRule("foo.example.com", newTestPath("/shop", "shop", 80)))
+	shop.Namespace = "shop"
+
+	if _, err := controller.SyncApplicationGateway("shared", []IngressState{{Ingress: web}, {Ingress: shop}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	pools := names(gatewayClient.gateways["shared"].Properties.BackendAddressPools)
+	if !reflect.DeepEqual(pools, []string{"pool-default-web-api-80", "pool-shop-web-shop-80", "pool-defaultbackend"}) {
+		t.Fatalf("expected the pools of both ingresses, got %v", pools)
+	}
+
+	// the remaining ingress resyncs the gateway without the removed one
+	gatewayClient.gateways["shared"] = decorateLiveGateway(gatewayClient.gateways["shared"])
+	if _, err := controller.SyncApplicationGateway("shared", []IngressState{{Ingress: web}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	properties := gatewayClient.gateways["shared"].Properties
+	if pools := names(properties.BackendAddressPools); !reflect.DeepEqual(pools, []string{"pool-default-web-api-80", "pool-defaultbackend"}) {
+		t.Errorf("expected only the pool of the remaining ingress, got %v", pools)
+	}
+	if rules := *(*properties.URLPathMaps)[0].Properties.PathRules; len(rules) != 1 {
+		t.Errorf("expected only the path of the remaining ingress, got %v", len(rules))
+	}
+	if len(*properties.GatewayIPConfigurations) != 1 {
+		t.Errorf("expected the gateway IP configuration to be preserved")
+	}
+}
+
+func TestReconcileKeepsSubResourcesConfiguredByHand(t *testing.T) {
+	controller, gatewayClient, _ := newTestController()
+	ingress := newTestIngress(newTestRule("foo.example.com", newTestPath("/api", "api", 80)))
+	createTestGateway(t, controller, ingress)
+
+	// a pool and a listener added in the portal, next to a pool the
+	// controller generated for a backend the ingress no longer uses
+	live := decorateLiveGateway(gatewayClient.gateways["web"])
+	pools := append(*live.Properties.BackendAddressPools,
+		network.ApplicationGatewayBackendAddressPool{Name: to.StringPtr("legacy-servers")},
+		network.ApplicationGatewayBackendAddressPool{Name: to.StringPtr("pool-default-web-old-80")})
+	live.Properties.BackendAddressPools = &pools
+	listeners := append(*live.Properties.HTTPListeners, network.ApplicationGatewayHTTPListener{Name: to.StringPtr("legacy-listener")})
+	live.Properties.HTTPListeners = &listeners
+	gatewayClient.gateways["web"] = live
+
+	ingress.Spec.Rules[0].HTTP.Paths = append(ingress.Spec.Rules[0].HTTP.Paths, newTestPath("/web", "web", 80))
+	if _, err := controller.SyncApplicationGateway("web", []IngressState{{Ingress: ingress}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	properties := gatewayClient.gateways["web"].Properties
+	if got := names(properties.BackendAddressPools); !reflect.DeepEqual(got, []string{"pool-default-web-api-80", "pool-defaultbackend", "legacy-servers", "pool-default-web-web-80"}) {
+		t.Errorf("expected the pool configured by hand to be kept and the stale pool to be dropped, got %v", got)
+	}
+	if got := names(properties.HTTPListeners); !reflect.DeepEqual(got, []string{"listener-foo.example.com", "legacy-listener"}) {
+		t.Errorf("expected the listener configured by hand to be kept, got %v", got)
+	}
+}
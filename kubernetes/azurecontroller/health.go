@@ -0,0 +1,72 @@
+package azurecontroller
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jargoonpard/appGatewaySample/kubernetes/logging"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/redact"
+)
+
+//tokenRefresher is the part of the service principal token the health check uses
+type tokenRefresher interface {
+	EnsureFresh() error
+}
+
+//AzureHealth reports when the controller last reached Azure
+type AzureHealth struct {
+	// LastTokenRefresh is when the token was last refreshed or found fresh
+	LastTokenRefresh time.Time
+	// LastARMCall is when the resource manager last answered a request
+	LastARMCall time.Time
+	// LastError is the error of the last failed check
+	LastError string
+}
+
+//healthTracker records the outcome of the Azure checks
+type healthTracker struct {
+	lock   sync.Mutex
+	health AzureHealth
+}
+
+//CheckAzure refreshes the token when it is about to expire and lists the
+//gateways of the resource group, recording when both last succeeded
+func (controller *AzureGatewayClientController) CheckAzure() error {
+	if token := controller.currentToken(); token != nil {
+		if err := token.EnsureFresh(); err != nil {
+			logging.Errorf("Failure refreshing the Azure token: %v", redact.Value(err))
+			tokenRefreshFailures.Inc()
+			controller.health.failed(err)
+			return err
+		}
+		controller.health.succeeded(&controller.health.health.LastTokenRefresh)
+	}
+
+	// a single page is enough to tell the resource manager is reachable
+	if _, err := controller.gatewayClient.List(controller.ResourceGroupName); err != nil {
+		logging.Errorf("Failure listing the gateways in the resource group %v: %v", controller.ResourceGroupName, redact.Value(err))
+		controller.health.failed(err)
+		return err
+	}
+	controller.health.succeeded(&controller.health.health.LastARMCall)
+	return nil
+}
+
+//Health returns the outcome of the Azure checks
+func (controller *AzureGatewayClientController) Health() AzureHealth {
+	controller.health.lock.Lock()
+	defer controller.health.lock.Unlock()
+	return controller.health.health
+}
+
+func (tracker *healthTracker) succeeded(last *time.Time) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	*last = time.Now()
+}
+
+func (tracker *healthTracker) failed(err error) {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	tracker.health.LastError = redact.Value(err)
+}
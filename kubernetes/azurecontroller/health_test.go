@@ -0,0 +1,37 @@
+package azurecontroller
+
+import (
+	"fmt"
+	"testing"
+)
+
+type fakeTokenRefresher struct {
+	err error
+}
+
+func (token *fakeTokenRefresher) EnsureFresh() error {
+	return token.err
+}
+
+func TestCheckAzure(t *testing.T) {
+	controller, _, _ := newTestController()
+	token := &fakeTokenRefresher{}
+	controller.token = token
+
+	if err := controller.CheckAzure(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	health := controller.Health()
+	if health.LastTokenRefresh.IsZero() || health.LastARMCall.IsZero() {
+		t.Errorf("expected the successful checks to be recorded, got %+v", health)
+	}
+
+	token.err = fmt.Errorf("invalid client secret")
+	if err := controller.CheckAzure(); err == nil {
+		t.Errorf("expected the failed refresh to be reported")
+	}
+	failed := controller.Health()
+	if failed.LastError != "invalid client secret" || failed.LastARMCall != health.LastARMCall {
+		t.Errorf("expected the failure to be recorded without reaching Azure, got %+v", failed)
+	}
+}
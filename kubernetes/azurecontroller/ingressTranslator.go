@@ -0,0 +1,714 @@
+package azurecontroller
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/arm/network"
+	"github.com/Azure/go-autorest/autorest/to"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/util/intstr"
+)
+
+const (
+	gatewayIPConfigurationName  = "gatewayIPConfig"
+	frontendIPConfigurationName = "frontendIPConfig"
+
+	httpPort           int32 = 80
+	defaultCapacity    int32 = 2
+	defaultBackendName       = "defaultbackend"
+
+	// Azure fills in the request timeout of backend settings that omit it,
+	// the desired gateway states it so it compares equal to the live one
+	defaultRequestTimeout int32 = 30
+
+	// probes need a host header, backends addressed by IP usually answer
+	// requests for the loopback address
+	defaultProbeHost = "127.0.0.1"
+
+	// limits Azure enforces on probe settings
+	maxProbeSeconds            int32 = 86400
+	maxProbeUnhealthyThreshold int32 = 20
+
+	// Azure resource names are limited to 80 characters
+	maxResourceNameLength = 80
+)
+
+var invalidNameCharacters = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+//BackendTarget holds the addresses and port the gateway uses to reach an ingress backend
+type BackendTarget struct {
+	Addresses []string
+	Port      int32
+
+	// Probe replaces the default gateway probe of the backend when set
+	Probe *HealthProbe
+}
+
+//HealthProbe describes how the gateway checks the health of backend members
+type HealthProbe struct {
+	Protocol network.ApplicationGatewayProtocol
+	Host     string
+	Path     string
+
+	// Interval and Timeout are in seconds
+	Interval           int32
+	Timeout            int32
+	UnhealthyThreshold int32
+}
+
+//IngressState is an ingress together with the cluster state needed to translate it
+type IngressState struct {
+	Ingress *extensions.Ingress
+
+	// Backends holds the resolved target of every backend the ingress
+	// references, backends without a target get an empty pool
+	Backends map[extensions.IngressBackend]BackendTarget
+
+	// Certificates holds the converted certificate of every TLS secret the
+	// ingress references by secret name, hosts whose secret is missing are
+	// only served over HTTP
+	Certificates map[string]TLSCertificate
+
+	// BackendCertificates switches every backend of the ingress to HTTPS
+	// when set, the gateway only accepts backends presenting one of them
+	BackendCertificates []AuthenticationCertificate
+
+	// Settings tunes the gateway beyond what the ingress spec can express
+	Settings GatewaySettings
+}
+
+//GatewaySettings holds the gateway tuning requested by an ingress, zero values
+//keep the defaults of the controller or of Azure
+type GatewaySettings struct {
+	// GatewayName overrides the name of the gateway serving the ingress
+	GatewayName string
+
+	CookieBasedAffinity bool
+	// RequestTimeout is the backend request timeout in seconds
+	RequestTimeout int32
+	// BackendProtocol defaults to HTTPS with backend certificates and HTTP
+	// otherwise
+	BackendProtocol network.ApplicationGatewayProtocol
+
+	DisabledSslProtocols []network.ApplicationGatewaySslProtocol
+
+	SkuName  network.ApplicationGatewaySkuName
+	SkuTier  network.ApplicationGatewayTier
+	Capacity int32
+}
+
+//hasSku is true when an ingress asks for a specific gateway size
+func (settings GatewaySettings) hasSku() bool {
+	return settings.SkuName != "" || settings.SkuTier != "" || settings.Capacity > 0
+}
+
+//GatewayConfig describes the Azure resources an ingress is translated against
+type GatewayConfig struct {
+	SubscriptionID    string
+	ResourceGroupName string
+	Region            string
+	GatewayName       string
+
+	PublicIPAddressID string
+	SubnetID          string
+
+	SkuName  network.ApplicationGatewaySkuName
+	SkuTier  network.ApplicationGatewayTier
+	Capacity int32
+}
+
+//gatewayResourceID returns the ARM identifier of the gateway described by config
+func (config GatewayConfig) gatewayResourceID() string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Network/applicationGateways/%s",
+		config.SubscriptionID, config.ResourceGroupName, config.GatewayName)
+}
+
+//subResource returns a reference to the named child resource of the gateway
+func (config GatewayConfig) subResource(collection, name string) *network.SubResource {
+	return &network.SubResource{
+		ID: to.StringPtr(fmt.Sprintf("%s/%s/%s", config.gatewayResourceID(), collection, name)),
+	}
+}
+
+//sku returns the gateway size, defaulting to the smallest standard gateway
+func (config GatewayConfig) sku() *network.ApplicationGatewaySku {
+	sku := network.ApplicationGatewaySku{
+		Name:     config.SkuName,
+		Tier:     config.SkuTier,
+		Capacity: to.Int32Ptr(config.Capacity),
+	}
+	if sku.Name == "" {
+		sku.Name = network.StandardSmall
+	}
+	if sku.Tier == "" {
+		sku.Tier = network.Standard
+	}
+	if config.Capacity <= 0 {
+		sku.Capacity = to.Int32Ptr(defaultCapacity)
+	}
+	return &sku
+}
+
+//backendKey identifies a single service port referenced by an ingress. Keys
+//include the ingress so ingresses sharing a gateway never share a pool.
+type backendKey struct {
+	namespace   string
+	ingressName string
+	serviceName string
+	servicePort intstr.IntOrString
+}
+
+func newBackendKey(ingress *extensions.Ingress, backend extensions.IngressBackend) backendKey {
+	return backendKey{
+		namespace:   ingress.Namespace,
+		ingressName: ingress.Name,
+		serviceName: backend.ServiceName,
+		servicePort: backend.ServicePort,
+	}
+}
+
+func (key backendKey) ingressBackend() extensions.IngressBackend {
+	return extensions.IngressBackend{
+		ServiceName: key.serviceName,
+		ServicePort: key.servicePort,
+	}
+}
+
+//String joins the parts of the key. Parts containing the separator make the
+//result ambiguous, namespace a-b with service c and namespace a with service
+//b-c join alike, such keys are told apart by a hash of the parts.
+func (key backendKey) String() string {
+	parts := []string{key.namespace, key.ingressName, key.serviceName, key.servicePort.String()}
+	joined := strings.Join(parts, "-")
+	for _, part := range parts {
+		if strings.Contains(part, "-") {
+			return joined + "-" + nameHash(strings.Join(parts, "/"))
+		}
+	}
+	return joined
+}
+
+func (key backendKey) poolName() string {
+	return resourceName("pool", key.String())
+}
+
+func (key backendKey) settingsName() string {
+	return resourceName("settings", key.String())
+}
+
+func (key backendKey) probeName() string {
+	return resourceName("probe", key.String())
+}
+
+//port returns the port the gateway should use to reach the backend
+func (key backendKey) port(target BackendTarget) int32 {
+	if target.Port > 0 {
+		return target.Port
+	}
+	if key.servicePort.Type == intstr.Int && key.servicePort.IntVal > 0 {
+		return key.servicePort.IntVal
+	}
+	return httpPort
+}
+
+//pathRule is a single path of an ingress rule together with its backend
+type pathRule struct {
+	paths   []string
+	backend backendKey
+}
+
+//listenerSpec collects everything routed through a single host name
+type listenerSpec struct {
+	host  string
+	rules []pathRule
+
+	// defaultBackend receives the requests none of the rules match, nil
+	// falls back to the default backend of the gateway
+	defaultBackend *backendKey
+	// states are the ingresses routing the host
+	states []*IngressState
+}
+
+func (listener listenerSpec) name() string {
+	return resourceName("listener", hostSuffix(listener.host))
+}
+
+func (listener listenerSpec) httpsName() string {
+	return resourceName("listener", hostSuffix(listener.host)+"-https")
+}
+
+func (listener listenerSpec) urlPathMapName() string {
+	return resourceName("urlpathmap", hostSuffix(listener.host))
+}
+
+func (listener listenerSpec) ruleName() string {
+	return resourceName("rule", hostSuffix(listener.host))
+}
+
+func (listener listenerSpec) httpsRuleName() string {
+	return resourceName("rule", hostSuffix(listener.host)+"-https")
+}
+
+//isBasic is true when every request to the listener goes to the default backend
+func (listener listenerSpec) isBasic() bool {
+	return len(listener.rules) == 0
+}
+
+//TranslateIngress builds the complete ApplicationGateway model for an ingress
+//served by a gateway of its own
+func TranslateIngress(config GatewayConfig, state IngressState) network.ApplicationGateway {
+	return TranslateIngresses(config, []IngressState{state})
+}
+
+//TranslateIngresses builds the complete ApplicationGateway model for every ingress
+//sharing a gateway. The result only depends on its arguments so the same
+//ingresses always yield the same gateway, with every collection in a stable order.
+func TranslateIngresses(config GatewayConfig, states []IngressState) network.ApplicationGateway {
+	plan := planGateway(states)
+	settings := gatewaySettings(states)
+
+	authenticationCertificates := []network.ApplicationGatewayAuthenticationCertificate{}
+	authenticationReferences := map[*IngressState][]network.SubResource{}
+	seenAuthenticationCertificates := map[string]bool{}
+	for _, state := range plan.states {
+		for _, certificate := range state.BackendCertificates {
+			name := authenticationCertificateName(state.Ingress.Namespace, certificate)
+			authenticationReferences[state] = append(authenticationReferences[state], *config.subResource("authenticationCertificates", name))
+			if seenAuthenticationCertificates[name] {
+				continue
+			}
+			seenAuthenticationCertificates[name] = true
+			authenticationCertificates = append(authenticationCertificates, network.ApplicationGatewayAuthenticationCertificate{
+				Name: to.StringPtr(name),
+				Properties: &network.ApplicationGatewayAuthenticationCertificatePropertiesFormat{
+					Data: to.StringPtr(certificate.Data),
+				},
+			})
+		}
+	}
+
+	pools := []network.ApplicationGatewayBackendAddressPool{}
+	httpSettings := []network.ApplicationGatewayBackendHTTPSettings{}
+	probes := []network.ApplicationGatewayProbe{}
+	for _, key := range sortedBackendKeys(plan.backends) {
+		spec := plan.backends[key]
+		backend, state, target := spec.key, spec.state, spec.target()
+
+		// every ingress tunes its own backends
+		backendProtocol := network.HTTP
+		if len(state.BackendCertificates) > 0 {
+			backendProtocol = network.HTTPS
+		}
+		if state.Settings.BackendProtocol != "" {
+			backendProtocol = state.Settings.BackendProtocol
+		}
+		cookieBasedAffinity := network.Disabled
+		if state.Settings.CookieBasedAffinity {
+			cookieBasedAffinity = network.Enabled
+		}
+
+		pools = append(pools, network.ApplicationGatewayBackendAddressPool{
+			Name: to.StringPtr(backend.poolName()),
+			Properties: &network.ApplicationGatewayBackendAddressPoolPropertiesFormat{
+				BackendAddresses: backendAddresses(target),
+			},
+		})
+		requestTimeout := defaultRequestTimeout
+		if state.Settings.RequestTimeout > 0 {
+			requestTimeout = state.Settings.RequestTimeout
+		}
+		backendSettings := network.ApplicationGatewayBackendHTTPSettingsPropertiesFormat{
+			Port:                to.Int32Ptr(backend.port(target)),
+			Protocol:            backendProtocol,
+			CookieBasedAffinity: cookieBasedAffinity,
+			RequestTimeout:      to.Int32Ptr(requestTimeout),
+		}
+		if backendProtocol == network.HTTPS {
+			references := append([]network.SubResource{}, authenticationReferences[state]...)
+			backendSettings.AuthenticationCertificates = &references
+		}
+		// Azure rejects probes using another protocol than the settings
+		// they belong to, such backends keep the default probe
+		if target.Probe != nil && target.Probe.Protocol == backendProtocol {
+			probes = append(probes, network.ApplicationGatewayProbe{
+				Name:       to.StringPtr(backend.probeName()),
+				Properties: target.Probe.properties(),
+			})
+			backendSettings.Probe = config.subResource("probes", backend.probeName())
+		}
+		httpSettings = append(httpSettings, network.ApplicationGatewayBackendHTTPSettings{
+			Name:       to.StringPtr(backend.settingsName()),
+			Properties: &backendSettings,
+		})
+	}
+
+	emptyPool := config.subResource("backendAddressPools", resourceName("pool", defaultBackendName))
+	emptySettings := config.subResource("backendHttpSettingsCollection", resourceName("settings", defaultBackendName))
+	defaultPool, defaultSettings := emptyPool, emptySettings
+	if plan.defaultBackend != nil {
+		defaultPool = config.subResource("backendAddressPools", plan.defaultBackend.poolName())
+		defaultSettings = config.subResource("backendHttpSettingsCollection", plan.defaultBackend.settingsName())
+	} else {
+		// application gateways always need somewhere to send unmatched
+		// requests, an empty pool answers them with an error
+		pools = append(pools, network.ApplicationGatewayBackendAddressPool{
+			Name: to.StringPtr(resourceName("pool", defaultBackendName)),
+			Properties: &network.ApplicationGatewayBackendAddressPoolPropertiesFormat{
+				BackendAddresses: &[]network.ApplicationGatewayBackendAddress{},
+			},
+		})
+		httpSettings = append(httpSettings, network.ApplicationGatewayBackendHTTPSettings{
+			Name: to.StringPtr(resourceName("settings", defaultBackendName)),
+			Properties: &network.ApplicationGatewayBackendHTTPSettingsPropertiesFormat{
+				Port:                to.Int32Ptr(httpPort),
+				Protocol:            network.HTTP,
+				CookieBasedAffinity: network.Disabled,
+				RequestTimeout:      to.Int32Ptr(defaultRequestTimeout),
+			},
+		})
+	}
+
+	frontendPortName := resourceName("port", fmt.Sprintf("%d", httpPort))
+	frontendPorts := []network.ApplicationGatewayFrontendPort{
+		{
+			Name: to.StringPtr(frontendPortName),
+			Properties: &network.ApplicationGatewayFrontendPortPropertiesFormat{
+				Port: to.Int32Ptr(httpPort),
+			},
+		},
+	}
+	httpsPortName := resourceName("port", fmt.Sprintf("%d", httpsPort))
+
+	certificates := []network.ApplicationGatewaySslCertificate{}
+	seenCertificates := map[string]bool{}
+	httpListeners := []network.ApplicationGatewayHTTPListener{}
+	urlPathMaps := []network.ApplicationGatewayURLPathMap{}
+	routingRules := []network.ApplicationGatewayRequestRoutingRule{}
+	for _, listener := range plan.listeners {
+		// hosts fall back to the gateway default backend when none of the
+		// ingresses routing them has a default backend of its own
+		listenerPool, listenerSettings := defaultPool, defaultSettings
+		if listener.defaultBackend != nil {
+			listenerPool = config.subResource("backendAddressPools", listener.defaultBackend.poolName())
+			listenerSettings = config.subResource("backendHttpSettingsCollection", listener.defaultBackend.settingsName())
+		}
+
+		listenerProperties := network.ApplicationGatewayHTTPListenerPropertiesFormat{
+			FrontendIPConfiguration: config.subResource("frontendIPConfigurations", frontendIPConfigurationName),
+			FrontendPort:            config.subResource("frontendPorts", frontendPortName),
+			Protocol:                network.HTTP,
+		}
+		if listener.host != "" {
+			listenerProperties.HostName = to.StringPtr(listener.host)
+		}
+		httpListeners = append(httpListeners, network.ApplicationGatewayHTTPListener{
+			Name:       to.StringPtr(listener.name()),
+			Properties: &listenerProperties,
+		})
+
+		ruleProperties := network.ApplicationGatewayRequestRoutingRulePropertiesFormat{
+			HTTPListener: config.subResource("httpListeners", listener.name()),
+		}
+		if listener.isBasic() {
+			ruleProperties.RuleType = network.Basic
+			ruleProperties.BackendAddressPool = listenerPool
+			ruleProperties.BackendHTTPSettings = listenerSettings
+		} else {
+			ruleProperties.RuleType = network.PathBasedRouting
+			ruleProperties.URLPathMap = config.subResource("urlPathMaps", listener.urlPathMapName())
+
+			pathRules := []network.ApplicationGatewayPathRule{}
+			for index, rule := range listener.rules {
+				pathRules = append(pathRules, network.ApplicationGatewayPathRule{
+					Name: to.StringPtr(resourceName("pathrule", fmt.Sprintf("%d", index))),
+					Properties: &network.ApplicationGatewayPathRulePropertiesFormat{
+						Paths:               to.StringSlicePtr(rule.paths),
+						BackendAddressPool:  config.subResource("backendAddressPools", rule.backend.poolName()),
+						BackendHTTPSettings: config.subResource("backendHttpSettingsCollection", rule.backend.settingsName()),
+					},
+				})
+			}
+			urlPathMaps = append(urlPathMaps, network.ApplicationGatewayURLPathMap{
+				Name: to.StringPtr(listener.urlPathMapName()),
+				Properties: &network.ApplicationGatewayURLPathMapPropertiesFormat{
+					DefaultBackendAddressPool:  listenerPool,
+					DefaultBackendHTTPSettings: listenerSettings,
+					PathRules:                  &pathRules,
+				},
+			})
+		}
+		routingRules = append(routingRules, network.ApplicationGatewayRequestRoutingRule{
+			Name:       to.StringPtr(listener.ruleName()),
+			Properties: &ruleProperties,
+		})
+
+		sslCertificateName, certificate, ok := plan.listenerCertificate(listener)
+		if !ok {
+			continue
+		}
+		if !seenCertificates[sslCertificateName] {
+			seenCertificates[sslCertificateName] = true
+			certificates = append(certificates, network.ApplicationGatewaySslCertificate{
+				Name: to.StringPtr(sslCertificateName),
+				Properties: &network.ApplicationGatewaySslCertificatePropertiesFormat{
+					Data:     to.StringPtr(certificate.Data),
+					Password: to.StringPtr(certificate.Password),
+				},
+			})
+		}
+
+		// the HTTPS listener terminates TLS and routes exactly like its
+		// HTTP counterpart, SNI lets several hosts share the port
+		httpsProperties := listenerProperties
+		httpsProperties.FrontendPort = config.subResource("frontendPorts", httpsPortName)
+		httpsProperties.Protocol = network.HTTPS
+		httpsProperties.SslCertificate = config.subResource("sslCertificates", sslCertificateName)
+		if listener.host != "" {
+			httpsProperties.RequireServerNameIndication = to.BoolPtr(true)
+		}
+		httpListeners = append(httpListeners, network.ApplicationGatewayHTTPListener{
+			Name:       to.StringPtr(listener.httpsName()),
+			Properties: &httpsProperties,
+		})
+
+		httpsRuleProperties := ruleProperties
+		httpsRuleProperties.HTTPListener = config.subResource("httpListeners", listener.httpsName())
+		routingRules = append(routingRules, network.ApplicationGatewayRequestRoutingRule{
+			Name:       to.StringPtr(listener.httpsRuleName()),
+			Properties: &httpsRuleProperties,
+		})
+	}
+	sort.Sort(certificatesByName(certificates))
+	if len(certificates) > 0 {
+		frontendPorts = append(frontendPorts, network.ApplicationGatewayFrontendPort{
+			Name: to.StringPtr(httpsPortName),
+			Properties: &network.ApplicationGatewayFrontendPortPropertiesFormat{
+				Port: to.Int32Ptr(httpsPort),
+			},
+		})
+	}
+
+	frontendIPProperties := network.ApplicationGatewayFrontendIPConfigurationPropertiesFormat{}
+	if config.PublicIPAddressID != "" {
+		frontendIPProperties.PublicIPAddress = &network.SubResource{ID: to.StringPtr(config.PublicIPAddressID)}
+	}
+
+	gatewayIPConfigurations := []network.ApplicationGatewayIPConfiguration{}
+	if config.SubnetID != "" {
+		gatewayIPConfigurations = append(gatewayIPConfigurations, network.ApplicationGatewayIPConfiguration{
+			Name: to.StringPtr(gatewayIPConfigurationName),
+			Properties: &network.ApplicationGatewayIPConfigurationPropertiesFormat{
+				Subnet: &network.SubResource{ID: to.StringPtr(config.SubnetID)},
+			},
+		})
+	}
+
+	var sslPolicy *network.ApplicationGatewaySslPolicy
+	if len(settings.DisabledSslProtocols) > 0 {
+		disabledProtocols := append([]network.ApplicationGatewaySslProtocol{}, settings.DisabledSslProtocols...)
+		sslPolicy = &network.ApplicationGatewaySslPolicy{DisabledSslProtocols: &disabledProtocols}
+	}
+
+	return network.ApplicationGateway{
+		Name:     to.StringPtr(config.GatewayName),
+		Location: to.StringPtr(config.Region),
+		Properties: &network.ApplicationGatewayPropertiesFormat{
+			Sku:                     config.sku(),
+			SslPolicy:               sslPolicy,
+			GatewayIPConfigurations: &gatewayIPConfigurations,
+			FrontendIPConfigurations: &[]network.ApplicationGatewayFrontendIPConfiguration{
+				{
+					Name:       to.StringPtr(frontendIPConfigurationName),
+					Properties: &frontendIPProperties,
+				},
+			},
+			FrontendPorts:                 &frontendPorts,
+			SslCertificates:               &certificates,
+			AuthenticationCertificates:    &authenticationCertificates,
+			Probes:                        &probes,
+			BackendAddressPools:           &pools,
+			BackendHTTPSettingsCollection: &httpSettings,
+			HTTPListeners:                 &httpListeners,
+			URLPathMaps:                   &urlPathMaps,
+			RequestRoutingRules:           &routingRules,
+		},
+	}
+}
+
+//properties converts the probe into its Azure model, keeping values within the
+//limits Azure accepts
+func (probe HealthProbe) properties() *network.ApplicationGatewayProbePropertiesFormat {
+	host := probe.Host
+	if host == "" {
+		host = defaultProbeHost
+	}
+	path := probe.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return &network.ApplicationGatewayProbePropertiesFormat{
+		Protocol:           probe.Protocol,
+		Host:               to.StringPtr(host),
+		Path:               to.StringPtr(path),
+		Interval:           to.Int32Ptr(clamp(probe.Interval, 1, maxProbeSeconds)),
+		Timeout:            to.Int32Ptr(clamp(probe.Timeout, 1, maxProbeSeconds)),
+		UnhealthyThreshold: to.Int32Ptr(clamp(probe.UnhealthyThreshold, 1, maxProbeUnhealthyThreshold)),
+	}
+}
+
+func clamp(value, min, max int32) int32 {
+	if value < min {
+		return min
+	}
+	if value > max {
+		return max
+	}
+	return value
+}
+
+//ingressDefaultBackend returns the backend used for requests no rule matches
+func ingressDefaultBackend(ingress *extensions.Ingress) (backendKey, bool) {
+	if ingress.Spec.Backend == nil {
+		return backendKey{}, false
+	}
+	return newBackendKey(ingress, *ingress.Spec.Backend), true
+}
+
+//ingressListeners groups the ingress rules by host name. Paths keep the order of
+//the ingress spec because the gateway evaluates path rules in order.
+func ingressListeners(ingress *extensions.Ingress) []listenerSpec {
+	byHost := map[string]*listenerSpec{}
+	for _, rule := range ingress.Spec.Rules {
+		listener, ok := byHost[rule.Host]
+		if !ok {
+			listener = &listenerSpec{host: rule.Host}
+			byHost[rule.Host] = listener
+		}
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			listener.rules = append(listener.rules, pathRule{
+				paths:   gatewayPaths(path.Path),
+				backend: newBackendKey(ingress, path.Backend),
+			})
+		}
+	}
+
+	// an ingress with only a default backend still needs a listener
+	if len(byHost) == 0 && ingress.Spec.Backend != nil {
+		byHost[""] = &listenerSpec{}
+	}
+
+	hosts := []string{}
+	for host := range byHost {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	listeners := []listenerSpec{}
+	for _, host := range hosts {
+		listeners = append(listeners, *byHost[host])
+	}
+	return listeners
+}
+
+//certificateName includes the fingerprint so a rotated certificate replaces the
+//previous one instead of being compared against data Azure never returns
+func certificateName(namespace, secretName string, certificate TLSCertificate) string {
+	return resourceName("sslcert", fmt.Sprintf("%s-%s-%s", namespace, secretName, certificate.Fingerprint))
+}
+
+func authenticationCertificateName(namespace string, certificate AuthenticationCertificate) string {
+	return resourceName("authcert", fmt.Sprintf("%s-%s", namespace, certificate.Fingerprint))
+}
+
+//gatewayPaths converts an ingress path into application gateway path patterns
+func gatewayPaths(path string) []string {
+	switch {
+	case path == "" || path == "/":
+		return []string{"/*"}
+	case strings.HasSuffix(path, "*"):
+		return []string{path}
+	case strings.HasSuffix(path, "/"):
+		return []string{path + "*"}
+	default:
+		return []string{path, path + "/*"}
+	}
+}
+
+//backendAddresses converts the addresses of a target into pool members in a stable order
+func backendAddresses(target BackendTarget) *[]network.ApplicationGatewayBackendAddress {
+	addresses := append([]string{}, target.Addresses...)
+	sort.Strings(addresses)
+
+	result := []network.ApplicationGatewayBackendAddress{}
+	for _, address := range addresses {
+		result = append(result, network.ApplicationGatewayBackendAddress{IPAddress: to.StringPtr(address)})
+	}
+	return &result
+}
+
+func sortedBackendKeys(backends map[string]backendSpec) []string {
+	keys := []string{}
+	for key := range backends {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+//certificatesByName orders gateway certificates by name
+type certificatesByName []network.ApplicationGatewaySslCertificate
+
+func (certificates certificatesByName) Len() int {
+	return len(certificates)
+}
+
+func (certificates certificatesByName) Swap(i, j int) {
+	certificates[i], certificates[j] = certificates[j], certificates[i]
+}
+
+func (certificates certificatesByName) Less(i, j int) bool {
+	return to.String(certificates[i].Name) < to.String(certificates[j].Name)
+}
+
+//hostSuffix names the resources of a host. Ingress hosts are DNS names, which
+//never contain an underscore, so the resources of rules without a host cannot
+//collide with those of a host named default.
+func hostSuffix(host string) string {
+	if host == "" {
+		return "_default"
+	}
+	return host
+}
+
+//resourceName builds a valid Azure child resource name from a prefix and suffix
+func resourceName(prefix, suffix string) string {
+	original := fmt.Sprintf("%s-%s", prefix, suffix)
+	name := invalidNameCharacters.ReplaceAllString(original, "-")
+	// rewritten names must not collide with names that were valid already,
+	// the hosts *.example.com and -.example.com both become -.example.com
+	if name != original {
+		name += "-" + nameHash(original)
+	}
+	if len(name) <= maxResourceNameLength {
+		return name
+	}
+
+	// keep truncated names unique by replacing the tail with a hash of the full name
+	suffixHash := "-" + nameHash(original)
+	return name[:maxResourceNameLength-len(suffixHash)] + suffixHash
+}
+
+//nameHash returns a short hash telling apart names that would be equal otherwise
+func nameHash(value string) string {
+	hash := fnv.New32a()
+	hash.Write([]byte(value))
+	return fmt.Sprintf("%08x", hash.Sum32())
+}
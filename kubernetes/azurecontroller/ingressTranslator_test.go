@@ -0,0 +1,353 @@
+package azurecontroller
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/arm/network"
+	"github.com/Azure/go-autorest/autorest/to"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/util/intstr"
+)
+
+var testGatewayConfig = GatewayConfig{
+	SubscriptionID:    "subscription",
+	ResourceGroupName: "group",
+	Region:            "westus",
+	GatewayName:       "gateway",
+	PublicIPAddressID: "/subscriptions/subscription/resourceGroups/group/providers/Microsoft.Network/publicIPAddresses/ip",
+}
+
+func newTestIngress(rules ...extensions.IngressRule) *extensions.Ingress {
+	return &extensions.Ingress{
+		ObjectMeta: api.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       extensions.IngressSpec{Rules: rules},
+	}
+}
+
+func newTestRule(host string, paths ...extensions.HTTPIngressPath) extensions.IngressRule {
+	return extensions.IngressRule{
+		Host: host,
+		IngressRuleValue: extensions.IngressRuleValue{
+			HTTP: &extensions.HTTPIngressRuleValue{Paths: paths},
+		},
+	}
+}
+
+func newTestPath(path, service string, port int) extensions.HTTPIngressPath {
+	return extensions.HTTPIngressPath{
+		Path: path,
+		Backend: extensions.IngressBackend{
+			ServiceName: service,
+			ServicePort: intstr.FromInt(port),
+		},
+	}
+}
+
+func names(value interface{}) []string {
+	result := []string{}
+	items := reflect.ValueOf(value).Elem()
+	for index := 0; index < items.Len(); index++ {
+		result = append(result, to.String(items.Index(index).FieldByName("Name").Interface().(*string)))
+	}
+	return result
+}
+
+func TestTranslateIngressDefaultBackendOnly(t *testing.T) {
+	ingress := newTestIngress()
+	ingress.Spec.Backend = &extensions.IngressBackend{ServiceName: "web", ServicePort: intstr.FromInt(8080)}
+
+	gateway := TranslateIngress(testGatewayConfig, IngressState{Ingress: ingress})
+	properties := gateway.Properties
+
+	if got := names(properties.HTTPListeners); !reflect.DeepEqual(got, []string{"listener-_default"}) {
+		t.Errorf("unexpected listeners %v", got)
+	}
+	if got := names(properties.BackendAddressPools); !reflect.DeepEqual(got, []string{"pool-default-web-web-8080"}) {
+		t.Errorf("unexpected pools %v", got)
+	}
+	if len(*properties.URLPathMaps) != 0 {
+		t.Errorf("expected no url path maps, got %v", names(properties.URLPathMaps))
+	}
+
+	rule := (*properties.RequestRoutingRules)[0].Properties
+	if rule.RuleType != network.Basic {
+		t.Errorf("expected a basic rule, got %v", rule.RuleType)
+	}
+	expectedPool := testGatewayConfig.gatewayResourceID() + "/backendAddressPools/pool-default-web-web-8080"
+	if to.String(rule.BackendAddressPool.ID) != expectedPool {
+		t.Errorf("expected rule to target %v, got %v", expectedPool, to.String(rule.BackendAddressPool.ID))
+	}
+	if port := to.Int32((*properties.BackendHTTPSettingsCollection)[0].Properties.Port); port != 8080 {
+		t.Errorf("expected backend port 8080, got %v", port)
+	}
+}
+
+func TestTranslateIngressPathRules(t *testing.T) {
+	ingress := newTestIngress(
+		newTestRule("foo.example.com",
+			newTestPath("/api", "api", 80),
+			newTestPath("/", "web", 80)),
+		newTestRule("bar.example.com",
+			newTestPath("/static/", "static", 8080)),
+	)
+
+	gateway := TranslateIngress(testGatewayConfig, IngressState{Ingress: ingress})
+	properties := gateway.Properties
+
+	if got := names(properties.HTTPListeners); !reflect.DeepEqual(got, []string{"listener-bar.example.com", "listener-foo.example.com"}) {
+		t.Errorf("unexpected listeners %v", got)
+	}
+	expectedPools := []string{"pool-default-web-api-80", "pool-default-web-static-8080", "pool-default-web-web-80", "pool-defaultbackend"}
+	if got := names(properties.BackendAddressPools); !reflect.DeepEqual(got, expectedPools) {
+		t.Errorf("unexpected pools %v", got)
+	}
+	if got := names(properties.URLPathMaps); !reflect.DeepEqual(got, []string{"urlpathmap-bar.example.com", "urlpathmap-foo.example.com"}) {
+		t.Errorf("unexpected url path maps %v", got)
+	}
+
+	fooMap := (*properties.URLPathMaps)[1].Properties
+	var paths [][]string
+	for _, rule := range *fooMap.PathRules {
+		paths = append(paths, to.StringSlice(rule.Properties.Paths))
+	}
+	if !reflect.DeepEqual(paths, [][]string{{"/api", "/api/*"}, {"/*"}}) {
+		t.Errorf("unexpected paths %v", paths)
+	}
+	if !reflect.DeepEqual(to.String(fooMap.DefaultBackendAddressPool.ID), testGatewayConfig.gatewayResourceID()+"/backendAddressPools/pool-defaultbackend") {
+		t.Errorf("unexpected default pool %v", to.String(fooMap.DefaultBackendAddressPool.ID))
+	}
+
+	listener := (*properties.HTTPListeners)[1].Properties
+	if to.String(listener.HostName) != "foo.example.com" {
+		t.Errorf("unexpected listener host %v", to.String(listener.HostName))
+	}
+	for _, rule := range *properties.RequestRoutingRules {
+		if rule.Properties.RuleType != network.PathBasedRouting {
+			t.Errorf("expected %v to be path based, got %v", to.String(rule.Name), rule.Properties.RuleType)
+		}
+	}
+}
+
+func TestTranslateIngressIsDeterministic(t *testing.T) {
+	first := newTestIngress(
+		newTestRule("a.example.com", newTestPath("/a", "a", 80)),
+		newTestRule("b.example.com", newTestPath("/b", "b", 80)),
+	)
+	second := newTestIngress(
+		newTestRule("b.example.com", newTestPath("/b", "b", 80)),
+		newTestRule("a.example.com", newTestPath("/a", "a", 80)),
+	)
+
+	firstJSON, err := json.Marshal(TranslateIngress(testGatewayConfig, IngressState{Ingress: first}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondJSON, err := json.Marshal(TranslateIngress(testGatewayConfig, IngressState{Ingress: second}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(firstJSON) != string(secondJSON) {
+		t.Errorf("expected identical payloads\n%s\n%s", firstJSON, secondJSON)
+	}
+}
+
+func TestResourceName(t *testing.T) {
+	wildcard := resourceName("listener", "*.example.com")
+	if !strings.HasPrefix(wildcard, "listener--.example.com-") || invalidNameCharacters.MatchString(wildcard) {
+		t.Errorf("unexpected name %v", wildcard)
+	}
+	if plain := resourceName("listener", "-.example.com"); plain != "listener--.example.com" || plain == wildcard {
+		t.Errorf("expected rewritten names to differ from valid ones, got %v and %v", wildcard, plain)
+	}
+
+	long := "a-very-long-service-name-that-keeps-going-and-going-well-past-any-limit-azure-has"
+	first := resourceName("pool", long+"-1")
+	second := resourceName("pool", long+"-2")
+	if len(first) != maxResourceNameLength || len(second) != maxResourceNameLength {
+		t.Errorf("expected names to be truncated to %v, got %v and %v", maxResourceNameLength, len(first), len(second))
+	}
+	if first == second {
+		t.Errorf("expected truncated names to stay unique, both were %v", first)
+	}
+}
+
+func TestHostSuffixKeepsDefaultHostApart(t *testing.T) {
+	catchAll := listenerSpec{}
+	named := listenerSpec{host: "default"}
+	if catchAll.name() == named.name() || catchAll.ruleName() == named.ruleName() {
+		t.Errorf("expected rules without a host to be named apart from host default, both were %v", catchAll.name())
+	}
+}
+
+func TestBackendKeysStayUnique(t *testing.T) {
+	first := newBackendKey(&extensions.Ingress{ObjectMeta: api.ObjectMeta{Namespace: "a-b", Name: "web"}}, extensions.IngressBackend{ServiceName: "c", ServicePort: intstr.FromInt(80)})
+	second := newBackendKey(&extensions.Ingress{ObjectMeta: api.ObjectMeta{Namespace: "a", Name: "b-web"}}, extensions.IngressBackend{ServiceName: "c", ServicePort: intstr.FromInt(80)})
+	if first.poolName() == second.poolName() {
+		t.Errorf("expected distinct backends to get distinct pools, both were %v", first.poolName())
+	}
+
+	plain := newBackendKey(newTestIngress(), extensions.IngressBackend{ServiceName: "web", ServicePort: intstr.FromInt(80)})
+	if name := plain.poolName(); name != "pool-default-web-web-80" {
+		t.Errorf("expected unambiguous keys to be joined as they are, got %v", name)
+	}
+}
+
+func TestTranslateIngressBackendTargets(t *testing.T) {
+	ingress := newTestIngress(newTestRule("foo.example.com", newTestPath("/", "web", 80)))
+	state := IngressState{
+		Ingress: ingress,
+		Backends: map[extensions.IngressBackend]BackendTarget{
+			ingress.Spec.Rules[0].HTTP.Paths[0].Backend: {
+				Addresses: []string{"10.0.0.5", "10.0.0.4"},
+				Port:      30080,
+			},
+		},
+	}
+
+	properties := TranslateIngress(testGatewayConfig, state).Properties
+
+	pool := (*properties.BackendAddressPools)[0]
+	var addresses []string
+	for _, address := range *pool.Properties.BackendAddresses {
+		addresses = append(addresses, to.String(address.IPAddress))
+	}
+	if !reflect.DeepEqual(addresses, []string{"10.0.0.4", "10.0.0.5"}) {
+		t.Errorf("unexpected pool addresses %v", addresses)
+	}
+	if port := to.Int32((*properties.BackendHTTPSettingsCollection)[0].Properties.Port); port != 30080 {
+		t.Errorf("expected backend port 30080, got %v", port)
+	}
+}
+
+func TestTranslateIngressTLS(t *testing.T) {
+	ingress := newTestIngress(
+		newTestRule("foo.example.com", newTestPath("/api", "api", 80)),
+		newTestRule("bar.example.com"),
+		newTestRule("plain.example.com", newTestPath("/", "web", 80)),
+	)
+	ingress.Spec.TLS = []extensions.IngressTLS{
+		{Hosts: []string{"foo.example.com", "bar.example.com"}, SecretName: "example-tls"},
+		{Hosts: []string{"plain.example.com"}, SecretName: "missing-tls"},
+	}
+	state := IngressState{
+		Ingress: ingress,
+		Certificates: map[string]TLSCertificate{
+			"example-tls": {Fingerprint: "0123456789abcdef", Data: "pfx", Password: "secret"},
+		},
+	}
+
+	properties := TranslateIngress(testGatewayConfig, state).Properties
+
+	if got := names(properties.SslCertificates); !reflect.DeepEqual(got, []string{"sslcert-default-example-tls-0123456789abcdef"}) {
+		t.Errorf("unexpected certificates %v", got)
+	}
+	if got := names(properties.FrontendPorts); !reflect.DeepEqual(got, []string{"port-80", "port-443"}) {
+		t.Errorf("unexpected frontend ports %v", got)
+	}
+	expectedListeners := []string{
+		"listener-bar.example.com", "listener-bar.example.com-https",
+		"listener-foo.example.com", "listener-foo.example.com-https",
+		"listener-plain.example.com",
+	}
+	if got := names(properties.HTTPListeners); !reflect.DeepEqual(got, expectedListeners) {
+		t.Errorf("unexpected listeners %v", got)
+	}
+
+	listener := (*properties.HTTPListeners)[3].Properties
+	if listener.Protocol != network.HTTPS || to.String(listener.HostName) != "foo.example.com" || !to.Bool(listener.RequireServerNameIndication) {
+		t.Errorf("expected an SNI HTTPS listener for foo.example.com, got %+v", listener)
+	}
+	expectedCertificate := testGatewayConfig.gatewayResourceID() + "/sslCertificates/sslcert-default-example-tls-0123456789abcdef"
+	if to.String(listener.SslCertificate.ID) != expectedCertificate {
+		t.Errorf("unexpected certificate %v", to.String(listener.SslCertificate.ID))
+	}
+
+	rule := (*properties.RequestRoutingRules)[3]
+	if to.String(rule.Name) != "rule-foo.example.com-https" || to.String(rule.Properties.URLPathMap.ID) != testGatewayConfig.gatewayResourceID()+"/urlPathMaps/urlpathmap-foo.example.com" {
+		t.Errorf("expected the HTTPS rule to share the url path map, got %v", to.String(rule.Name))
+	}
+}
+
+func TestTranslateIngressWithoutCertificates(t *testing.T) {
+	ingress := newTestIngress(newTestRule("foo.example.com", newTestPath("/", "web", 80)))
+	ingress.Spec.TLS = []extensions.IngressTLS{{SecretName: "missing-tls"}}
+
+	properties := TranslateIngress(testGatewayConfig, IngressState{Ingress: ingress}).Properties
+
+	if len(*properties.SslCertificates) != 0 {
+		t.Errorf("expected no certificates, got %v", names(properties.SslCertificates))
+	}
+	if got := names(properties.FrontendPorts); !reflect.DeepEqual(got, []string{"port-80"}) {
+		t.Errorf("unexpected frontend ports %v", got)
+	}
+}
+
+func TestTranslateIngressHTTPSBackends(t *testing.T) {
+	ingress := newTestIngress(newTestRule("foo.example.com", newTestPath("/", "web", 443)))
+	state := IngressState{
+		Ingress: ingress,
+		BackendCertificates: []AuthenticationCertificate{
+			{Fingerprint: "0123456789abcdef", Data: "der"},
+		},
+	}
+
+	properties := TranslateIngress(testGatewayConfig, state).Properties
+
+	if got := names(properties.AuthenticationCertificates); !reflect.DeepEqual(got, []string{"authcert-default-0123456789abcdef"}) {
+		t.Errorf("unexpected authentication certificates %v", got)
+	}
+	backendSettings := (*properties.BackendHTTPSettingsCollection)[0].Properties
+	if backendSettings.Protocol != network.HTTPS {
+		t.Errorf("expected HTTPS backends, got %v", backendSettings.Protocol)
+	}
+	expected := testGatewayConfig.gatewayResourceID() + "/authenticationCertificates/authcert-default-0123456789abcdef"
+	if len(*backendSettings.AuthenticationCertificates) != 1 || to.String((*backendSettings.AuthenticationCertificates)[0].ID) != expected {
+		t.Errorf("unexpected certificate references %+v", *backendSettings.AuthenticationCertificates)
+	}
+}
+
+func TestTranslateIngressProbes(t *testing.T) {
+	ingress := newTestIngress(newTestRule("foo.example.com",
+		newTestPath("/api", "api", 80),
+		newTestPath("/", "web", 80)))
+	state := IngressState{
+		Ingress: ingress,
+		Backends: map[extensions.IngressBackend]BackendTarget{
+			ingress.Spec.Rules[0].HTTP.Paths[0].Backend: {
+				Port:  8080,
+				Probe: &HealthProbe{Protocol: network.HTTP, Path: "healthz", Interval: 10, Timeout: 0, UnhealthyThreshold: 30},
+			},
+			ingress.Spec.Rules[0].HTTP.Paths[1].Backend: {
+				Port:  8443,
+				Probe: &HealthProbe{Protocol: network.HTTPS, Path: "/ready"},
+			},
+		},
+	}
+
+	properties := TranslateIngress(testGatewayConfig, state).Properties
+
+	if got := names(properties.Probes); !reflect.DeepEqual(got, []string{"probe-default-web-api-80"}) {
+		t.Errorf("unexpected probes %v", got)
+	}
+	probe := (*properties.Probes)[0].Properties
+	if to.String(probe.Path) != "/healthz" || to.String(probe.Host) != defaultProbeHost {
+		t.Errorf("unexpected probe target %v%v", to.String(probe.Host), to.String(probe.Path))
+	}
+	if to.Int32(probe.Interval) != 10 || to.Int32(probe.Timeout) != 1 || to.Int32(probe.UnhealthyThreshold) != maxProbeUnhealthyThreshold {
+		t.Errorf("expected probe values within the Azure limits, got %+v", probe)
+	}
+
+	settings := *properties.BackendHTTPSettingsCollection
+	expected := testGatewayConfig.gatewayResourceID() + "/probes/probe-default-web-api-80"
+	if settings[0].Properties.Probe == nil || to.String(settings[0].Properties.Probe.ID) != expected {
+		t.Errorf("expected the api settings to use the probe")
+	}
+	if settings[1].Properties.Probe != nil {
+		t.Errorf("expected an HTTPS probe not to be linked to HTTP settings")
+	}
+}
@@ -0,0 +1,222 @@
+package azurecontroller
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/arm/network"
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	metricsNamespace = "azure_ingress"
+
+	gatewayResource  = "gateway"
+	publicIPResource = "publicIP"
+)
+
+var (
+	armRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "arm_requests_total",
+		Help:      "Requests to the Azure resource manager by resource, operation and status code.",
+	}, []string{"resource", "operation", "code"})
+
+	armRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "arm_request_duration_seconds",
+		Help:      "Latency of the requests to the Azure resource manager, including the polling of long running requests.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 15),
+	}, []string{"resource", "operation"})
+
+	operationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "gateway_operation_duration_seconds",
+		Help:      "Duration of the long running operations on gateways by kind and result.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 13),
+	}, []string{"kind", "result"})
+
+	managedGateways = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "managed_gateways",
+		Help:      "Number of gateways the controller wrote since it started.",
+	})
+
+	gatewayListeners = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "gateway_listeners",
+		Help:      "Number of HTTP listeners of every managed gateway.",
+	}, []string{"gateway"})
+
+	gatewayBackendPools = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "gateway_backend_pools",
+		Help:      "Number of backend address pools of every managed gateway.",
+	}, []string{"gateway"})
+
+	tokenRefreshFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "token_refresh_failures_total",
+		Help:      "Failed refreshes of the Azure token.",
+	})
+
+	// sizedGateways holds the gateways with size metrics
+	sizedGatewaysLock sync.Mutex
+	sizedGateways     = map[string]bool{}
+)
+
+func init() {
+	prometheus.MustRegister(armRequests, armRequestDuration, operationDuration, managedGateways, gatewayListeners, gatewayBackendPools, tokenRefreshFailures)
+}
+
+//observeARMRequest records a request to the resource manager, failed requests
+//are counted with the status code of the error when Azure answered at all
+func observeARMRequest(resource, operation string, started time.Time, response autorest.Response, err error) {
+	armRequestDuration.WithLabelValues(resource, operation).Observe(time.Since(started).Seconds())
+	armRequests.WithLabelValues(resource, operation, statusCode(response, err)).Inc()
+}
+
+func statusCode(response autorest.Response, err error) string {
+	if detailedError, ok := err.(autorest.DetailedError); ok && detailedError.StatusCode != nil {
+		return fmt.Sprint(detailedError.StatusCode)
+	}
+	if response.Response != nil {
+		return fmt.Sprint(response.StatusCode)
+	}
+	if err != nil {
+		return "error"
+	}
+	return "unknown"
+}
+
+//observeOperation records a finished long running operation
+func observeOperation(kind OperationKind, duration time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	operationDuration.WithLabelValues(string(kind), result).Observe(duration.Seconds())
+}
+
+//recordGatewaySize updates the size metrics of a gateway the controller wrote
+func recordGatewaySize(gatewayName string, gateway network.ApplicationGateway) {
+	listeners, pools := 0, 0
+	if gateway.Properties != nil {
+		if gateway.Properties.HTTPListeners != nil {
+			listeners = len(*gateway.Properties.HTTPListeners)
+		}
+		if gateway.Properties.BackendAddressPools != nil {
+			pools = len(*gateway.Properties.BackendAddressPools)
+		}
+	}
+	gatewayListeners.WithLabelValues(gatewayName).Set(float64(listeners))
+	gatewayBackendPools.WithLabelValues(gatewayName).Set(float64(pools))
+
+	sizedGatewaysLock.Lock()
+	defer sizedGatewaysLock.Unlock()
+	sizedGateways[gatewayName] = true
+	managedGateways.Set(float64(len(sizedGateways)))
+}
+
+//forgetGatewaySize removes the size metrics of a deleted gateway
+func forgetGatewaySize(gatewayName string) {
+	gatewayListeners.DeleteLabelValues(gatewayName)
+	gatewayBackendPools.DeleteLabelValues(gatewayName)
+
+	sizedGatewaysLock.Lock()
+	defer sizedGatewaysLock.Unlock()
+	delete(sizedGateways, gatewayName)
+	managedGateways.Set(float64(len(sizedGateways)))
+}
+
+//tokenAuthorizer counts the failed refreshes of the token, the token only
+//fails to authorize a request when it cannot be refreshed
+type tokenAuthorizer struct {
+	autorest.Authorizer
+}
+
+func (authorizer tokenAuthorizer) WithAuthorization() autorest.PrepareDecorator {
+	return func(p autorest.Preparer) autorest.Preparer {
+		authorized := authorizer.Authorizer.WithAuthorization()(p)
+		return autorest.PreparerFunc(func(r *http.Request) (*http.Request, error) {
+			r, err := authorized.Prepare(r)
+			if err != nil {
+				tokenRefreshFailures.Inc()
+			}
+			return r, err
+		})
+	}
+}
+
+//instrumentedGatewayClient records every request of the gateway client
+type instrumentedGatewayClient struct {
+	GatewayClient
+}
+
+func (client instrumentedGatewayClient) ListAll() (result network.ApplicationGatewayListResult, err error) {
+	defer func(started time.Time) { observeARMRequest(gatewayResource, "ListAll", started, result.Response, err) }(time.Now())
+	return client.GatewayClient.ListAll()
+}
+
+func (client instrumentedGatewayClient) List(resourceGroupName string) (result network.ApplicationGatewayListResult, err error) {
+	defer func(started time.Time) { observeARMRequest(gatewayResource, "List", started, result.Response, err) }(time.Now())
+	return client.GatewayClient.List(resourceGroupName)
+}
+
+func (client instrumentedGatewayClient) ListNextResults(lastResults network.ApplicationGatewayListResult) (result network.ApplicationGatewayListResult, err error) {
+	defer func(started time.Time) { observeARMRequest(gatewayResource, "List", started, result.Response, err) }(time.Now())
+	return client.GatewayClient.ListNextResults(lastResults)
+}
+
+func (client instrumentedGatewayClient) Get(resourceGroupName string, applicationGatewayName string) (result network.ApplicationGateway, err error) {
+	defer func(started time.Time) { observeARMRequest(gatewayResource, "Get", started, result.Response, err) }(time.Now())
+	return client.GatewayClient.Get(resourceGroupName, applicationGatewayName)
+}
+
+func (client instrumentedGatewayClient) CreateOrUpdate(resourceGroupName string, applicationGatewayName string, parameters network.ApplicationGateway, cancel <-chan struct{}) (result autorest.Response, err error) {
+	defer func(started time.Time) { observeARMRequest(gatewayResource, "CreateOrUpdate", started, result, err) }(time.Now())
+	return client.GatewayClient.CreateOrUpdate(resourceGroupName, applicationGatewayName, parameters, cancel)
+}
+
+func (client instrumentedGatewayClient) CreateOrUpdateIfMatch(resourceGroupName string, applicationGatewayName string, parameters network.ApplicationGateway, etag string, cancel <-chan struct{}) (result autorest.Response, err error) {
+	defer func(started time.Time) { observeARMRequest(gatewayResource, "CreateOrUpdate", started, result, err) }(time.Now())
+	return client.GatewayClient.CreateOrUpdateIfMatch(resourceGroupName, applicationGatewayName, parameters, etag, cancel)
+}
+
+func (client instrumentedGatewayClient) Delete(resourceGroupName string, applicationGatewayName string, cancel <-chan struct{}) (result autorest.Response, err error) {
+	defer func(started time.Time) { observeARMRequest(gatewayResource, "Delete", started, result, err) }(time.Now())
+	return client.GatewayClient.Delete(resourceGroupName, applicationGatewayName, cancel)
+}
+
+//instrumentedPublicIPClient records every request of the public IP client
+type instrumentedPublicIPClient struct {
+	PublicIPClient
+}
+
+func (client instrumentedPublicIPClient) List(resourceGroupName string) (result network.PublicIPAddressListResult, err error) {
+	defer func(started time.Time) { observeARMRequest(publicIPResource, "List", started, result.Response, err) }(time.Now())
+	return client.PublicIPClient.List(resourceGroupName)
+}
+
+func (client instrumentedPublicIPClient) ListNextResults(lastResults network.PublicIPAddressListResult) (result network.PublicIPAddressListResult, err error) {
+	defer func(started time.Time) { observeARMRequest(publicIPResource, "List", started, result.Response, err) }(time.Now())
+	return client.PublicIPClient.ListNextResults(lastResults)
+}
+
+func (client instrumentedPublicIPClient) Get(resourceGroupName string, publicIPAddressName string, expand string) (result network.PublicIPAddress, err error) {
+	defer func(started time.Time) { observeARMRequest(publicIPResource, "Get", started, result.Response, err) }(time.Now())
+	return client.PublicIPClient.Get(resourceGroupName, publicIPAddressName, expand)
+}
+
+func (client instrumentedPublicIPClient) CreateOrUpdate(resourceGroupName string, publicIPAddressName string, parameters network.PublicIPAddress, cancel <-chan struct{}) (result autorest.Response, err error) {
+	defer func(started time.Time) { observeARMRequest(publicIPResource, "CreateOrUpdate", started, result, err) }(time.Now())
+	return client.PublicIPClient.CreateOrUpdate(resourceGroupName, publicIPAddressName, parameters, cancel)
+}
+
+func (client instrumentedPublicIPClient) Delete(resourceGroupName string, publicIPAddressName string, cancel <-chan struct{}) (result autorest.Response, err error) {
+	defer func(started time.Time) { observeARMRequest(publicIPResource, "Delete", started, result, err) }(time.Now())
+	return client.PublicIPClient.Delete(resourceGroupName, publicIPAddressName, cancel)
+}
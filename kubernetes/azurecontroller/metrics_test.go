@@ -0,0 +1,45 @@
+package azurecontroller
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func counterValue(t *testing.T, counter *prometheus.CounterVec, labels ...string) float64 {
+	metric := &dto.Metric{}
+	if err := counter.WithLabelValues(labels...).Write(metric); err != nil {
+		t.Fatal(err)
+	}
+	return metric.GetCounter().GetValue()
+}
+
+func TestInstrumentedGatewayClient(t *testing.T) {
+	controller, gatewayClient, _ := newTestController()
+	controller.gatewayClient = instrumentedGatewayClient{gatewayClient}
+	ingress := newTestIngress(newTestRule("foo.example.com", newTestPath("/", "web", 80)))
+
+	notFound := counterValue(t, armRequests, gatewayResource, "Get", "404")
+	if _, err := controller.SyncApplicationGateway("metrics", []IngressState{{Ingress: ingress}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if got := counterValue(t, armRequests, gatewayResource, "Get", "404"); got != notFound+1 {
+		t.Errorf("expected the missing gateway to be counted as 404, got %v more", got-notFound)
+	}
+	metric := &dto.Metric{}
+	gatewayListeners.WithLabelValues("metrics").Write(metric)
+	if got := metric.GetGauge().GetValue(); got != 1 {
+		t.Errorf("expected the listener of the created gateway, got %v", got)
+	}
+
+	if _, err := controller.DeleteApplicationGateway("metrics"); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	sizedGatewaysLock.Lock()
+	defer sizedGatewaysLock.Unlock()
+	if sizedGateways["metrics"] {
+		t.Errorf("expected the size of the deleted gateway to be forgotten")
+	}
+}
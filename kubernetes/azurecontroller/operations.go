@@ -0,0 +1,165 @@
+package azurecontroller
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/arm/network"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/logging"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/redact"
+)
+
+//OperationKind names the long running operations the controller performs on gateways
+type OperationKind string
+
+const (
+	//OperationCreateOrUpdate creates a gateway or updates its configuration
+	OperationCreateOrUpdate OperationKind = "CreateOrUpdate"
+	//OperationDelete deletes a gateway together with its public IP
+	OperationDelete OperationKind = "Delete"
+
+	// provisioning states of resources Azure is done with
+	provisioningSucceeded = "Succeeded"
+	provisioningFailed    = "Failed"
+	provisioningCanceled  = "Canceled"
+
+	defaultPollInterval = 15 * time.Second
+)
+
+//Operation records a long running operation on a gateway
+type Operation struct {
+	Gateway  string
+	Kind     OperationKind
+	Started  time.Time
+	Finished time.Time
+	// Err is empty while the operation runs and when it succeeded
+	Err string
+}
+
+//Running is true until the operation finished
+func (operation Operation) Running() bool {
+	return operation.Finished.IsZero()
+}
+
+//OperationInProgressError is returned for writes to a gateway another
+//operation is still writing to
+type OperationInProgressError struct {
+	Operation Operation
+}
+
+func (err OperationInProgressError) Error() string {
+	return fmt.Sprintf("%v of gateway %v is in progress since %v", err.Operation.Kind, err.Operation.Gateway, err.Operation.Started.Format(time.RFC3339))
+}
+
+//operationTracker serializes the long running operations on every gateway and
+//cancels them when the controller shuts down
+type operationTracker struct {
+	cancel <-chan struct{}
+
+	lock       sync.Mutex
+	operations map[string]Operation
+}
+
+func newOperationTracker(cancel <-chan struct{}) *operationTracker {
+	return &operationTracker{cancel: cancel, operations: map[string]Operation{}}
+}
+
+//run performs an operation on a gateway unless another one is still in flight.
+//Operations block until Azure completes them or the controller shuts down.
+func (tracker *operationTracker) run(gatewayName string, kind OperationKind, operation func(cancel <-chan struct{}) error) error {
+	tracker.lock.Lock()
+	if current, ok := tracker.operations[gatewayName]; ok && current.Running() {
+		tracker.lock.Unlock()
+		return OperationInProgressError{Operation: current}
+	}
+	tracker.operations[gatewayName] = Operation{Gateway: gatewayName, Kind: kind, Started: time.Now()}
+	tracker.lock.Unlock()
+
+	err := operation(tracker.cancel)
+
+	tracker.lock.Lock()
+	finished := tracker.operations[gatewayName]
+	finished.Finished = time.Now()
+	observeOperation(kind, finished.Finished.Sub(finished.Started), err)
+	if err != nil {
+		finished.Err = redact.Value(err)
+	}
+	tracker.operations[gatewayName] = finished
+	tracker.lock.Unlock()
+
+	return err
+}
+
+//writtenSince determines if an operation on the gateway is in flight or
+//started after the given time
+func (tracker *operationTracker) writtenSince(gatewayName string, since time.Time) bool {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+	operation, ok := tracker.operations[gatewayName]
+	return ok && (operation.Running() || operation.Started.After(since))
+}
+
+//snapshot returns the last operation of every gateway ordered by gateway name
+func (tracker *operationTracker) snapshot() []Operation {
+	tracker.lock.Lock()
+	defer tracker.lock.Unlock()
+
+	names := []string{}
+	for name := range tracker.operations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	operations := []Operation{}
+	for _, name := range names {
+		operations = append(operations, tracker.operations[name])
+	}
+	return operations
+}
+
+//Operations returns the last long running operation of every gateway
+func (controller *AzureGatewayClientController) Operations() []Operation {
+	return controller.operations.snapshot()
+}
+
+//provisioningInProgress determines if Azure is still applying a previous write
+//to the gateway, e.g. one issued before the controller restarted
+func provisioningInProgress(gateway network.ApplicationGateway) bool {
+	if gateway.Properties == nil {
+		return false
+	}
+	switch to.String(gateway.Properties.ProvisioningState) {
+	case "", provisioningSucceeded, provisioningFailed, provisioningCanceled:
+		return false
+	}
+	return true
+}
+
+//awaitProvisioning polls a gateway until Azure finished the write in flight,
+//returning the gateway as it is afterwards
+func (controller *AzureGatewayClientController) awaitProvisioning(gateway network.ApplicationGateway) (network.ApplicationGateway, error) {
+	gatewayName := to.String(gateway.Name)
+	pollInterval := controller.pollInterval
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+
+	for provisioningInProgress(gateway) {
+		logging.Infof("Gateway %v is %v, waiting for the operation in flight", gatewayName, to.String(gateway.Properties.ProvisioningState))
+		select {
+		case <-controller.operations.cancel:
+			return gateway, fmt.Errorf("stopped waiting for gateway %v to finish provisioning", gatewayName)
+		case <-time.After(pollInterval):
+		}
+
+		var err error
+		gateway, err = controller.gatewayClient.Get(controller.ResourceGroupName, gatewayName)
+		if err != nil {
+			return gateway, err
+		}
+	}
+	return gateway, nil
+}
@@ -0,0 +1,102 @@
+package azurecontroller
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/arm/network"
+	"github.com/Azure/go-autorest/autorest/to"
+)
+
+//provisioningGatewayClient reports a gateway as updating for a number of polls
+type provisioningGatewayClient struct {
+	*fakeGatewayClient
+	pendingPolls int
+}
+
+func (client *provisioningGatewayClient) Get(resourceGroupName string, applicationGatewayName string) (network.ApplicationGateway, error) {
+	gateway, err := client.fakeGatewayClient.Get(resourceGroupName, applicationGatewayName)
+	if err != nil {
+		return gateway, err
+	}
+	properties := *gateway.Properties
+	properties.ProvisioningState = to.StringPtr(provisioningSucceeded)
+	if client.pendingPolls > 0 {
+		client.pendingPolls--
+		properties.ProvisioningState = to.StringPtr("Updating")
+	}
+	gateway.Properties = &properties
+	return gateway, nil
+}
+
+func TestOperationTrackerPreventsOverlappingWrites(t *testing.T) {
+	tracker := newOperationTracker(nil)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- tracker.run("web", OperationCreateOrUpdate, func(cancel <-chan struct{}) error {
+			close(started)
+			<-release
+			return fmt.Errorf("failed")
+		})
+	}()
+	<-started
+
+	err := tracker.run("web", OperationDelete, func(cancel <-chan struct{}) error {
+		t.Errorf("expected the overlapping delete not to run")
+		return nil
+	})
+	if _, ok := err.(OperationInProgressError); !ok {
+		t.Errorf("expected an operation in progress error, got %v", err)
+	}
+	if err := tracker.run("other", OperationDelete, func(cancel <-chan struct{}) error { return nil }); err != nil {
+		t.Errorf("expected other gateways to be written, got %v", err)
+	}
+
+	close(release)
+	<-done
+	operations := tracker.snapshot()
+	if len(operations) != 2 || operations[1].Gateway != "web" || operations[1].Running() || operations[1].Err != "failed" {
+		t.Errorf("expected the failed operation to be recorded, got %+v", operations)
+	}
+}
+
+func TestSyncWaitsForProvisioningInFlight(t *testing.T) {
+	controller, gatewayClient, _ := newTestController()
+	ingress := newTestIngress(newTestRule("foo.example.com", newTestPath("/", "web", 80)))
+	createTestGateway(t, controller, ingress)
+	gatewayClient.gateways["web"] = decorateLiveGateway(gatewayClient.gateways["web"])
+	gatewayClient.writes = nil
+
+	controller.gatewayClient = &provisioningGatewayClient{fakeGatewayClient: gatewayClient, pendingPolls: 2}
+	controller.pollInterval = time.Millisecond
+
+	ingress.Spec.Rules = append(ingress.Spec.Rules, newTestRule("bar.example.com", newTestPath("/", "web", 80)))
+	if _, err := controller.SyncApplicationGateway("web", []IngressState{{Ingress: ingress}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(gatewayClient.writes) != 1 {
+		t.Errorf("expected a single write once provisioning finished, got %v", gatewayClient.writes)
+	}
+}
+
+func TestAwaitProvisioningStopsOnCancel(t *testing.T) {
+	controller, gatewayClient, _ := newTestController()
+	ingress := newTestIngress(newTestRule("foo.example.com", newTestPath("/", "web", 80)))
+	createTestGateway(t, controller, ingress)
+	gatewayClient.writes = nil
+
+	cancel := make(chan struct{})
+	close(cancel)
+	controller.operations = newOperationTracker(cancel)
+	controller.gatewayClient = &provisioningGatewayClient{fakeGatewayClient: gatewayClient, pendingPolls: 1000}
+
+	if _, err := controller.SyncApplicationGateway("web", []IngressState{{Ingress: ingress}}); err == nil {
+		t.Errorf("expected the sync to stop waiting on shutdown")
+	}
+	if len(gatewayClient.writes) != 0 {
+		t.Errorf("expected no duplicate write, got %v", gatewayClient.writes)
+	}
+}
@@ -0,0 +1,401 @@
+package azurecontroller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/arm/network"
+	"github.com/Azure/go-autorest/autorest/to"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/logging"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/redact"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	utilerrors "k8s.io/kubernetes/pkg/util/errors"
+)
+
+const (
+	clusterTagKey   = "kubernetes-cluster"
+	versionTagKey   = "kubernetes-ingress-controller"
+	ingressesTagKey = "kubernetes-ingresses"
+	gatewayTagKey   = "kubernetes-gateway"
+
+	// Azure rejects longer tag values
+	maxTagValueLength = 256
+)
+
+//gatewayTags marks a gateway as owned by the controller. The ingress list only
+//informs humans, it is truncated on gateways shared by many ingresses.
+func (controller *AzureGatewayClientController) gatewayTags(states []IngressState) map[string]*string {
+	ingresses := []string{}
+	for _, state := range states {
+		ingresses = append(ingresses, state.Ingress.Namespace+"/"+state.Ingress.Name)
+	}
+	sort.Strings(ingresses)
+
+	value := strings.Join(ingresses, ",")
+	if len(value) > maxTagValueLength {
+		value = value[:maxTagValueLength]
+	}
+
+	tags := controller.ownershipTags()
+	tags[ingressesTagKey] = to.StringPtr(value)
+	return tags
+}
+
+//publicIPTags marks a public IP as owned by the controller and names the
+//gateway it fronts
+func (controller *AzureGatewayClientController) publicIPTags(gatewayName string) map[string]*string {
+	tags := controller.ownershipTags()
+	tags[gatewayTagKey] = to.StringPtr(gatewayName)
+	return tags
+}
+
+func (controller *AzureGatewayClientController) ownershipTags() map[string]*string {
+	tags := map[string]*string{versionTagKey: to.StringPtr(controller.ControllerVersion)}
+	if controller.ClusterID != "" {
+		tags[clusterTagKey] = to.StringPtr(controller.ClusterID)
+	}
+	return tags
+}
+
+//mergeTags applies the controller tags over the live ones, keeping the tags
+//users added in the portal
+func mergeTags(live *map[string]*string, owned map[string]*string) *map[string]*string {
+	merged := map[string]*string{}
+	if live != nil {
+		for key, value := range *live {
+			merged[key] = value
+		}
+	}
+	for key, value := range owned {
+		merged[key] = value
+	}
+	return &merged
+}
+
+//ownedByCluster determines if the tags mark a resource as created by a
+//controller of this cluster. Without a cluster ID nothing is considered owned.
+func (controller *AzureGatewayClientController) ownedByCluster(tags *map[string]*string) bool {
+	if controller.ClusterID == "" || tags == nil {
+		return false
+	}
+	return to.String((*tags)[clusterTagKey]) == controller.ClusterID
+}
+
+//createdByController determines if the tags mark a resource as created by the
+//controller. Without a cluster ID the controller tag is enough as long as no
+//cluster claimed the resource.
+func (controller *AzureGatewayClientController) createdByController(tags *map[string]*string) bool {
+	if controller.ClusterID != "" || tags == nil {
+		return controller.ownedByCluster(tags)
+	}
+	_, tagged := (*tags)[versionTagKey]
+	_, claimed := (*tags)[clusterTagKey]
+	return tagged && !claimed
+}
+
+//withoutIngress removes the listeners, routing rules, path maps and backends
+//translated from an ingress from a gateway, together with the certificates
+//only they referenced. Everything else is kept as found in Azure.
+func withoutIngress(gateway network.ApplicationGateway, ingress *extensions.Ingress) network.ApplicationGateway {
+	removed := map[string]bool{}
+	removeBackend := func(key backendKey) {
+		removed[key.poolName()] = true
+		removed[key.settingsName()] = true
+		removed[key.probeName()] = true
+	}
+	for _, listener := range ingressListeners(ingress) {
+		for _, name := range []string{listener.name(), listener.httpsName(), listener.urlPathMapName(), listener.ruleName(), listener.httpsRuleName()} {
+			removed[name] = true
+		}
+		for _, rule := range listener.rules {
+			removeBackend(rule.backend)
+		}
+	}
+	if key, ok := ingressDefaultBackend(ingress); ok {
+		removeBackend(key)
+	}
+
+	properties := network.ApplicationGatewayPropertiesFormat{}
+	if gateway.Properties != nil {
+		properties = *gateway.Properties
+	}
+	// the operational state is read only and rejected by CreateOrUpdate
+	properties.OperationalState = ""
+
+	// certificates are shared by name, they go once nothing kept uses them
+	releasedCertificates, keptCertificates := map[string]bool{}, map[string]bool{}
+	if properties.HTTPListeners != nil {
+		listeners := []network.ApplicationGatewayHTTPListener{}
+		for _, listener := range *properties.HTTPListeners {
+			certificates := keptCertificates
+			if removed[to.String(listener.Name)] {
+				certificates = releasedCertificates
+			} else {
+				listeners = append(listeners, listener)
+			}
+			if listener.Properties != nil && listener.Properties.SslCertificate != nil {
+				certificates[subResourceName(listener.Properties.SslCertificate)] = true
+			}
+		}
+		properties.HTTPListeners = &listeners
+	}
+	releasedAuthentication, keptAuthentication := map[string]bool{}, map[string]bool{}
+	if properties.BackendHTTPSettingsCollection != nil {
+		settings := []network.ApplicationGatewayBackendHTTPSettings{}
+		for _, setting := range *properties.BackendHTTPSettingsCollection {
+			certificates := keptAuthentication
+			if removed[to.String(setting.Name)] {
+				certificates = releasedAuthentication
+			} else {
+				settings = append(settings, setting)
+			}
+			if setting.Properties != nil && setting.Properties.AuthenticationCertificates != nil {
+				for _, certificate := range *setting.Properties.AuthenticationCertificates {
+					certificates[subResourceName(&certificate)] = true
+				}
+			}
+		}
+		properties.BackendHTTPSettingsCollection = &settings
+	}
+	if properties.RequestRoutingRules != nil {
+		rules := []network.ApplicationGatewayRequestRoutingRule{}
+		for _, rule := range *properties.RequestRoutingRules {
+			if !removed[to.String(rule.Name)] {
+				rules = append(rules, rule)
+			}
+		}
+		properties.RequestRoutingRules = &rules
+	}
+	if properties.URLPathMaps != nil {
+		pathMaps := []network.ApplicationGatewayURLPathMap{}
+		for _, pathMap := range *properties.URLPathMaps {
+			if !removed[to.String(pathMap.Name)] {
+				pathMaps = append(pathMaps, pathMap)
+			}
+		}
+		properties.URLPathMaps = &pathMaps
+	}
+	if properties.BackendAddressPools != nil {
+		pools := []network.ApplicationGatewayBackendAddressPool{}
+		for _, pool := range *properties.BackendAddressPools {
+			if !removed[to.String(pool.Name)] {
+				pools = append(pools, pool)
+			}
+		}
+		properties.BackendAddressPools = &pools
+	}
+	if properties.Probes != nil {
+		probes := []network.ApplicationGatewayProbe{}
+		for _, probe := range *properties.Probes {
+			if !removed[to.String(probe.Name)] {
+				probes = append(probes, probe)
+			}
+		}
+		properties.Probes = &probes
+	}
+	if properties.SslCertificates != nil {
+		certificates := []network.ApplicationGatewaySslCertificate{}
+		for _, certificate := range *properties.SslCertificates {
+			name := strings.ToLower(to.String(certificate.Name))
+			if !releasedCertificates[name] || keptCertificates[name] {
+				certificates = append(certificates, certificate)
+			}
+		}
+		properties.SslCertificates = &certificates
+	}
+	if properties.AuthenticationCertificates != nil {
+		certificates := []network.ApplicationGatewayAuthenticationCertificate{}
+		for _, certificate := range *properties.AuthenticationCertificates {
+			name := strings.ToLower(to.String(certificate.Name))
+			if !releasedAuthentication[name] || keptAuthentication[name] {
+				certificates = append(certificates, certificate)
+			}
+		}
+		properties.AuthenticationCertificates = &certificates
+	}
+
+	released := gateway
+	released.Properties = &properties
+	return released
+}
+
+//subResourceName returns the name of the sub resource a reference points to,
+//in lower case as Azure does not preserve the case of identifiers
+func subResourceName(reference *network.SubResource) string {
+	_, name := parseResourceID(to.String(reference.ID))
+	return strings.ToLower(name)
+}
+
+//DeletedResource records the deletion of an Azure resource owned by the cluster
+type DeletedResource struct {
+	Kind  string `json:"kind"`
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+}
+
+const (
+	//GatewayKind is the kind of deleted application gateways
+	GatewayKind = "applicationGateway"
+	//PublicIPKind is the kind of deleted public IP addresses
+	PublicIPKind = "publicIPAddress"
+	//CertificateKind is the kind of the certificates deleted together with
+	//the gateway holding them
+	CertificateKind = "certificate"
+)
+
+//CollectGarbage deletes the gateways and public IPs this cluster created which
+//no longer serve any ingress, e.g. because their ingresses were deleted while
+//the controller was down. Untagged resources are never touched. The gateways
+//in use are those of the ingresses at inUseSince, gateways written to since
+//then may serve ingresses the caller did not see and are kept as well.
+func (controller *AzureGatewayClientController) CollectGarbage(gatewaysInUse map[string]bool, inUseSince time.Time) error {
+	if controller.ClusterID == "" {
+		logging.V(3).Infof("No cluster ID configured, skipping garbage collection")
+		return nil
+	}
+	keep := func(gatewayName string) bool {
+		return gatewaysInUse[gatewayName] || controller.operations.writtenSince(gatewayName, inUseSince)
+	}
+	return controller.deleteOwned("no longer serves any ingress", keep, func(DeletedResource) {})
+}
+
+//DeleteAll deletes every gateway and public IP tagged with the cluster ID,
+//reporting each resource as its deletion completes. The certificates of the
+//cluster live inside its gateways and are reported with them. A failed
+//deletion does not stop the others, the failures are returned together.
+func (controller *AzureGatewayClientController) DeleteAll(report func(DeletedResource)) error {
+	if controller.ClusterID == "" {
+		return fmt.Errorf("no cluster ID configured, the resources of the cluster cannot be told apart from others")
+	}
+	return controller.deleteOwned("belongs to the cluster being torn down", func(string) bool { return false }, report)
+}
+
+//deleteOwned deletes the resources of the cluster except those of the gateways
+//keep is true for, reason tells in the log why they are deleted
+func (controller *AzureGatewayClientController) deleteOwned(reason string, keep func(gatewayName string) bool, report func(DeletedResource)) error {
+	errs := []error{}
+	gateways, err := controller.listGateways()
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, gateway := range gateways {
+		name := to.String(gateway.Name)
+		if keep(name) || !controller.ownedByCluster(gateway.Tags) {
+			continue
+		}
+		logging.Infof("Gateway %v %v, deleting it", name, reason)
+		deleted, err := controller.DeleteApplicationGateway(name)
+		reportGateway(gateway, deleted, report)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// public IPs outlive their gateway when deleting the gateway succeeded
+	// but deleting the address did not
+	addresses, err := controller.listPublicIPs()
+	if err != nil {
+		return utilerrors.NewAggregate(append(errs, err))
+	}
+	for _, address := range addresses {
+		name := to.String(address.Name)
+		if !controller.ownedByCluster(address.Tags) || keep(to.String((*address.Tags)[gatewayTagKey])) {
+			continue
+		}
+		if address.Properties != nil && address.Properties.IPConfiguration != nil {
+			logging.V(3).Infof("Public IP %v is still in use, keeping it", name)
+			continue
+		}
+		logging.Infof("Public IP %v no longer fronts a gateway and %v, deleting it", name, reason)
+		err := controller.operations.run(to.String((*address.Tags)[gatewayTagKey]), OperationDelete, func(cancel <-chan struct{}) error {
+			_, err := controller.publicIPClient.Delete(controller.ResourceGroupName, name, cancel)
+			if err != nil && !isResourceNotFound(err) {
+				logging.Errorf("[AZURE] Failed to delete Public IP %v: %v", name, redact.Value(err))
+				return err
+			}
+			return nil
+		})
+		report(deletedResource(PublicIPKind, name, err))
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+//reportGateway reports the resources deleting a gateway removed, the
+//certificates inside the gateway along with it
+func reportGateway(gateway network.ApplicationGateway, deleted []DeletedResource, report func(DeletedResource)) {
+	for _, resource := range deleted {
+		report(resource)
+		if resource.Kind != GatewayKind || gateway.Properties == nil {
+			continue
+		}
+		certificates := []string{}
+		if gateway.Properties.SslCertificates != nil {
+			for _, certificate := range *gateway.Properties.SslCertificates {
+				certificates = append(certificates, to.String(certificate.Name))
+			}
+		}
+		if gateway.Properties.AuthenticationCertificates != nil {
+			for _, certificate := range *gateway.Properties.AuthenticationCertificates {
+				certificates = append(certificates, to.String(certificate.Name))
+			}
+		}
+		for _, certificate := range certificates {
+			report(DeletedResource{Kind: CertificateKind, Name: resource.Name + "/" + certificate, Error: resource.Error})
+		}
+	}
+}
+
+func deletedResource(kind, name string, err error) DeletedResource {
+	resource := DeletedResource{Kind: kind, Name: name}
+	if err != nil {
+		resource.Error = redact.Value(err)
+	}
+	return resource
+}
+
+//listGateways returns every gateway of the resource group, following the pages
+//of the result
+func (controller *AzureGatewayClientController) listGateways() ([]network.ApplicationGateway, error) {
+	gateways := []network.ApplicationGateway{}
+	result, err := controller.gatewayClient.List(controller.ResourceGroupName)
+	for {
+		if err != nil {
+			logging.Errorf("Failure listing the gateways in the resource group %v: %v", controller.ResourceGroupName, redact.Value(err))
+			return nil, err
+		}
+		if result.Value != nil {
+			gateways = append(gateways, *result.Value...)
+		}
+		if to.String(result.NextLink) == "" {
+			return gateways, nil
+		}
+		result, err = controller.gatewayClient.ListNextResults(result)
+	}
+}
+
+//listPublicIPs returns every public IP of the resource group, following the
+//pages of the result
+func (controller *AzureGatewayClientController) listPublicIPs() ([]network.PublicIPAddress, error) {
+	addresses := []network.PublicIPAddress{}
+	result, err := controller.publicIPClient.List(controller.ResourceGroupName)
+	for {
+		if err != nil {
+			logging.Errorf("Failure listing the public IPs in the resource group %v: %v", controller.ResourceGroupName, redact.Value(err))
+			return nil, err
+		}
+		if result.Value != nil {
+			addresses = append(addresses, *result.Value...)
+		}
+		if to.String(result.NextLink) == "" {
+			return addresses, nil
+		}
+		result, err = controller.publicIPClient.ListNextResults(result)
+	}
+}
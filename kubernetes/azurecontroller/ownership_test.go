@@ -0,0 +1,278 @@
+package azurecontroller
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/arm/network"
+	"github.com/Azure/go-autorest/autorest/to"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+func TestSyncTagsOwnedResources(t *testing.T) {
+	controller, gatewayClient, publicIPClient := newTestController()
+	controller.ClusterID = "cluster"
+	controller.ControllerVersion = "1.0"
+	ingress := newTestIngress(newTestRule("foo.example.com", newTestPath("/", "web", 80)))
+
+	if _, err := controller.SyncApplicationGateway("web", []IngressState{{Ingress: ingress}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	tags := *gatewayClient.gateways["web"].Tags
+	if to.String(tags[clusterTagKey]) != "cluster" || to.String(tags[versionTagKey]) != "1.0" || to.String(tags[ingressesTagKey]) != "default/web" {
+		t.Errorf("unexpected gateway tags %v", tags)
+	}
+	addressTags := *publicIPClient.addresses["web-pip"].Tags
+	if to.String(addressTags[clusterTagKey]) != "cluster" || to.String(addressTags[gatewayTagKey]) != "web" {
+		t.Errorf("unexpected public IP tags %v", addressTags)
+	}
+}
+
+func TestCollectGarbage(t *testing.T) {
+	controller, gatewayClient, publicIPClient := newTestController()
+	controller.ClusterID = "cluster"
+
+	for _, name := range []string{"orphan", "served"} {
+		ingress := newTestIngress(newTestRule("foo.example.com", newTestPath("/", "web", 80)))
+		if _, err := controller.SyncApplicationGateway(name, []IngressState{{Ingress: ingress}}); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	gatewayClient.gateways["untagged"] = network.ApplicationGateway{Name: to.StringPtr("untagged")}
+	gatewayClient.gateways["foreign"] = network.ApplicationGateway{
+		Name: to.StringPtr("foreign"),
+		Tags: &map[string]*string{clusterTagKey: to.StringPtr("other")},
+	}
+	// an address left behind by a gateway deleted earlier
+	publicIPClient.addresses["stale-pip"] = network.PublicIPAddress{
+		Name: to.StringPtr("stale-pip"),
+		Tags: mergeTags(nil, controller.publicIPTags("stale")),
+	}
+
+	if err := controller.CollectGarbage(map[string]bool{"served": true}, time.Now()); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	for name, expected := range map[string]bool{"orphan": false, "served": true, "untagged": true, "foreign": true} {
+		if _, ok := gatewayClient.gateways[name]; ok != expected {
+			t.Errorf("expected gateway %v to exist: %v", name, expected)
+		}
+	}
+	for name, expected := range map[string]bool{"orphan-pip": false, "served-pip": true, "stale-pip": false} {
+		if _, ok := publicIPClient.addresses[name]; ok != expected {
+			t.Errorf("expected public IP %v to exist: %v", name, expected)
+		}
+	}
+}
+
+// a gateway created after the ingresses were listed serves an ingress the
+// garbage collection did not see
+func TestCollectGarbageKeepsGatewaysWrittenMeanwhile(t *testing.T) {
+	controller, gatewayClient, publicIPClient := newTestController()
+	controller.ClusterID = "cluster"
+	ingress := newTestIngress(newTestRule("foo.example.com", newTestPath("/", "web", 80)))
+	if _, err := controller.SyncApplicationGateway("orphan", []IngressState{{Ingress: ingress}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	inUseSince := time.Now()
+	if _, err := controller.SyncApplicationGateway("fresh", []IngressState{{Ingress: ingress}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	// the public IP of a gateway still being created is not attached yet
+	publicIPClient.addresses["creating-pip"] = network.PublicIPAddress{
+		Name: to.StringPtr("creating-pip"),
+		Tags: mergeTags(nil, controller.publicIPTags("creating")),
+	}
+	controller.operations.operations["creating"] = Operation{Gateway: "creating", Kind: OperationCreateOrUpdate, Started: inUseSince.Add(-time.Minute)}
+
+	if err := controller.CollectGarbage(map[string]bool{}, inUseSince); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	for name, expected := range map[string]bool{"orphan": false, "fresh": true} {
+		if _, ok := gatewayClient.gateways[name]; ok != expected {
+			t.Errorf("expected gateway %v to exist: %v", name, expected)
+		}
+	}
+	for name, expected := range map[string]bool{"orphan-pip": false, "fresh-pip": true, "creating-pip": true} {
+		if _, ok := publicIPClient.addresses[name]; ok != expected {
+			t.Errorf("expected public IP %v to exist: %v", name, expected)
+		}
+	}
+}
+
+func TestCollectGarbageWithoutClusterID(t *testing.T) {
+	controller, gatewayClient, _ := newTestController()
+	ingress := newTestIngress(newTestRule("foo.example.com", newTestPath("/", "web", 80)))
+	if _, err := controller.SyncApplicationGateway("web", []IngressState{{Ingress: ingress}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if err := controller.CollectGarbage(map[string]bool{}, time.Now()); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, ok := gatewayClient.gateways["web"]; !ok {
+		t.Errorf("expected nothing to be collected without a cluster ID")
+	}
+}
+
+func TestDeleteAll(t *testing.T) {
+	controller, gatewayClient, publicIPClient := newTestController()
+	controller.ClusterID = "cluster"
+	certPEM, keyPEM := newTestCertificatePEM(t, "foo.example.com")
+	certificate, err := NewTLSCertificate(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ingress := newTestIngress(newTestRule("foo.example.com", newTestPath("/", "web", 80)))
+	ingress.Spec.TLS = []extensions.IngressTLS{{Hosts: []string{"foo.example.com"}, SecretName: "web-tls"}}
+	state := IngressState{Ingress: ingress, Certificates: map[string]TLSCertificate{"web-tls": certificate}}
+	if _, err := controller.SyncApplicationGateway("web", []IngressState{state}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	gatewayClient.gateways["untagged"] = network.ApplicationGateway{Name: to.StringPtr("untagged")}
+
+	reported := []DeletedResource{}
+	if err := controller.DeleteAll(func(resource DeletedResource) { reported = append(reported, resource) }); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if len(reported) != 3 || reported[0].Kind != GatewayKind || reported[1].Kind != CertificateKind || reported[2].Name != "web-pip" {
+		t.Errorf("expected the gateway, its certificate and its public IP to be reported, got %+v", reported)
+	}
+	if _, ok := gatewayClient.gateways["web"]; ok {
+		t.Errorf("expected the gateway to be deleted")
+	}
+	if _, ok := gatewayClient.gateways["untagged"]; !ok {
+		t.Errorf("expected gateways of others to be kept")
+	}
+	if _, ok := publicIPClient.addresses["web-pip"]; ok {
+		t.Errorf("expected the public IP to be deleted")
+	}
+
+	controller.ClusterID = ""
+	if err := controller.DeleteAll(func(DeletedResource) {}); err == nil {
+		t.Errorf("expected a teardown without cluster ID to be refused")
+	}
+}
+
+func TestDeleteAllContinuesPastFailures(t *testing.T) {
+	controller, gatewayClient, publicIPClient := newTestController()
+	controller.ClusterID = "cluster"
+	for _, name := range []string{"broken", "web"} {
+		ingress := newTestIngress(newTestRule("foo.example.com", newTestPath("/", "web", 80)))
+		if _, err := controller.SyncApplicationGateway(name, []IngressState{{Ingress: ingress}}); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	gatewayClient.failDeletes = map[string]bool{"broken": true}
+	// an address the controller did not create is neither deleted nor reported
+	webIP := publicIPClient.addresses["web-pip"]
+	webIP.Tags = nil
+	publicIPClient.addresses["web-pip"] = webIP
+
+	reported := []DeletedResource{}
+	if err := controller.DeleteAll(func(resource DeletedResource) { reported = append(reported, resource) }); err == nil {
+		t.Errorf("expected the failed deletion to be returned")
+	}
+
+	expected := []DeletedResource{{Kind: GatewayKind, Name: "broken"}, {Kind: GatewayKind, Name: "web"}, {Kind: PublicIPKind, Name: "broken-pip"}}
+	if len(reported) != len(expected) || reported[0].Error == "" {
+		t.Fatalf("expected %v, got %+v", expected, reported)
+	}
+	reported[0].Error = ""
+	if !reflect.DeepEqual(reported, expected) {
+		t.Errorf("expected %v, got %+v", expected, reported)
+	}
+	if _, ok := gatewayClient.gateways["web"]; ok {
+		t.Errorf("expected the gateway after the failed one to be deleted")
+	}
+	if _, ok := publicIPClient.addresses["web-pip"]; !ok {
+		t.Errorf("expected the address the controller did not create to be kept")
+	}
+}
+
+func TestReleaseDeletesCreatedGateway(t *testing.T) {
+	controller, gatewayClient, publicIPClient := newTestController()
+	ingress := newTestIngress(newTestRule("foo.example.com", newTestPath("/", "web", 80)))
+	if _, err := controller.SyncApplicationGateway("web", []IngressState{{Ingress: ingress}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if err := controller.ReleaseApplicationGateway("web", ingress); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(gatewayClient.gateways) != 0 || len(publicIPClient.addresses) != 0 {
+		t.Errorf("expected the gateway and its public IP to be deleted, got %v and %v", gatewayClient.gateways, publicIPClient.addresses)
+	}
+}
+
+func TestReleaseKeepsGatewaysOfOthers(t *testing.T) {
+	controller, gatewayClient, publicIPClient := newTestController()
+	controller.ClusterID = "cluster"
+	certPEM, keyPEM := newTestCertificatePEM(t, "foo.example.com")
+	certificate, err := NewTLSCertificate(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	web := newTestIngress(newTestRule("foo.example.com", newTestPath("/", "web", 80)))
+	web.Spec.TLS = []extensions.IngressTLS{{Hosts: []string{"foo.example.com"}, SecretName: "web-tls"}}
+	other := newTestIngress(newTestRule("bar.example.com", newTestPath("/", "other", 80)))
+	other.Name = "other"
+	states := []IngressState{
+		{Ingress: web, Certificates: map[string]TLSCertificate{"web-tls": certificate}},
+		{Ingress: other},
+	}
+	if _, err := controller.SyncApplicationGateway("web", states); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// the gateway and the address existed before the controller used them
+	live := decorateLiveGateway(gatewayClient.gateways["web"])
+	live.Tags = &map[string]*string{"owner": to.StringPtr("portal")}
+	gatewayClient.gateways["web"] = live
+	address := publicIPClient.addresses["web-pip"]
+	address.Tags = nil
+	publicIPClient.addresses["web-pip"] = address
+
+	if err := controller.ReleaseApplicationGateway("web", web); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	released, ok := gatewayClient.gateways["web"]
+	if !ok {
+		t.Fatalf("expected the gateway of others to be kept")
+	}
+	if got := names(released.Properties.HTTPListeners); len(got) != 1 || got[0] != "listener-bar.example.com" {
+		t.Errorf("expected only the listener of the other ingress to be kept, got %v", got)
+	}
+	if got := names(released.Properties.RequestRoutingRules); len(got) != 1 || got[0] != "rule-bar.example.com" {
+		t.Errorf("expected only the rule of the other ingress to be kept, got %v", got)
+	}
+	for _, pool := range names(released.Properties.BackendAddressPools) {
+		if pool == newBackendKey(web, web.Spec.Rules[0].HTTP.Paths[0].Backend).poolName() {
+			t.Errorf("expected the pool of the released ingress to be removed, got %v", pool)
+		}
+	}
+	if got := names(released.Properties.SslCertificates); len(got) != 0 {
+		t.Errorf("expected the certificate only the released ingress used to be removed, got %v", got)
+	}
+	if to.String((*released.Tags)["owner"]) != "portal" {
+		t.Errorf("expected the tags to be kept, got %v", released.Tags)
+	}
+	if _, ok := publicIPClient.addresses["web-pip"]; !ok {
+		t.Errorf("expected the public IP of others to be kept")
+	}
+
+	// the controller never claims a gateway it only syncs
+	if _, err := controller.SyncApplicationGateway("web", []IngressState{{Ingress: other}}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if tags := *gatewayClient.gateways["web"].Tags; tags[clusterTagKey] != nil {
+		t.Errorf("expected the gateway not to be tagged, got %v", tags)
+	}
+}
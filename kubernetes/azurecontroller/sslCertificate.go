@@ -0,0 +1,116 @@
+package azurecontroller
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+const (
+	httpsPort int32 = 443
+
+	// the fingerprint is part of the certificate resource name, a prefix of
+	// the digest is enough to tell rotated certificates apart
+	fingerprintLength = 8
+	passwordLength    = 24
+)
+
+//TLSCertificate is a certificate and key encoded the way application gateways expect them
+type TLSCertificate struct {
+	// Fingerprint identifies the certificate chain, it changes whenever the
+	// certificate is rotated
+	Fingerprint string
+
+	// Data is the base64 encoded PFX archive protected by Password
+	Data     string
+	Password string
+}
+
+//NewTLSCertificate converts a PEM encoded certificate chain and private key, as
+//found in kubernetes.io/tls secrets, into a PFX archive with a random password
+func NewTLSCertificate(certPEM, keyPEM []byte) (TLSCertificate, error) {
+	keyPair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return TLSCertificate{}, err
+	}
+
+	passwordBytes := make([]byte, passwordLength)
+	if _, err := rand.Read(passwordBytes); err != nil {
+		return TLSCertificate{}, err
+	}
+	password := base64.RawURLEncoding.EncodeToString(passwordBytes)
+
+	chain := make([]*x509.Certificate, len(keyPair.Certificate))
+	for index, certificate := range keyPair.Certificate {
+		if chain[index], err = x509.ParseCertificate(certificate); err != nil {
+			return TLSCertificate{}, err
+		}
+	}
+	// application gateways only read archives encrypted the legacy way
+	pfx, err := pkcs12.LegacyDES.Encode(keyPair.PrivateKey, chain[0], chain[1:], password)
+	if err != nil {
+		return TLSCertificate{}, err
+	}
+
+	digest := sha256.New()
+	for _, certificate := range keyPair.Certificate {
+		digest.Write(certificate)
+	}
+
+	return TLSCertificate{
+		Fingerprint: hex.EncodeToString(digest.Sum(nil)[:fingerprintLength]),
+		Data:        base64.StdEncoding.EncodeToString(pfx),
+		Password:    password,
+	}, nil
+}
+
+//AuthenticationCertificate is a certificate the gateway trusts when it connects to backends over HTTPS
+type AuthenticationCertificate struct {
+	Fingerprint string
+
+	// Data is the base64 encoded DER certificate
+	Data string
+}
+
+//NewAuthenticationCertificates converts every certificate of a PEM bundle into
+//a certificate the gateway accepts from HTTPS backends
+func NewAuthenticationCertificates(bundle []byte) ([]AuthenticationCertificate, error) {
+	certificates := []AuthenticationCertificate{}
+	seen := map[string]bool{}
+	for {
+		var block *pem.Block
+		block, bundle = pem.Decode(bundle)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return nil, err
+		}
+
+		digest := sha256.Sum256(block.Bytes)
+		fingerprint := hex.EncodeToString(digest[:fingerprintLength])
+		if seen[fingerprint] {
+			continue
+		}
+		seen[fingerprint] = true
+		certificates = append(certificates, AuthenticationCertificate{
+			Fingerprint: fingerprint,
+			Data:        base64.StdEncoding.EncodeToString(block.Bytes),
+		})
+	}
+
+	if len(certificates) == 0 {
+		return nil, errors.New("no PEM encoded certificate found")
+	}
+	return certificates, nil
+}
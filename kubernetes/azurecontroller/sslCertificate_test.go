@@ -0,0 +1,128 @@
+package azurecontroller
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/pkcs12"
+)
+
+func newTestCertificatePEM(t *testing.T, host string) ([]byte, []byte) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return certPEM, keyPEM
+}
+
+func TestNewTLSCertificateEncodesPFX(t *testing.T) {
+	certPEM, keyPEM := newTestCertificatePEM(t, "foo.example.com")
+	certificate, err := NewTLSCertificate(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(certificate.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey, decoded, err := pkcs12.Decode(data, certificate.Password)
+	if err != nil {
+		t.Fatalf("could not decode the PFX: %v", err)
+	}
+	certBlock, _ := pem.Decode(certPEM)
+	if !bytes.Equal(decoded.Raw, certBlock.Bytes) {
+		t.Errorf("the decoded certificate differs from the secret certificate")
+	}
+	keyBlock, _ := pem.Decode(keyPEM)
+	rsaKey, ok := privateKey.(*rsa.PrivateKey)
+	if !ok || !bytes.Equal(x509.MarshalPKCS1PrivateKey(rsaKey), keyBlock.Bytes) {
+		t.Errorf("the decoded key differs from the secret key")
+	}
+}
+
+func TestNewTLSCertificateReadByOpenSSL(t *testing.T) {
+	openssl, err := exec.LookPath("openssl")
+	if err != nil {
+		t.Skip("openssl is not installed")
+	}
+
+	certPEM, keyPEM := newTestCertificatePEM(t, "foo.example.com")
+	certificate, err := NewTLSCertificate(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pfx, err := base64.StdEncoding.DecodeString(certificate.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := ioutil.TempFile("", "pfx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.Write(pfx)
+	file.Close()
+
+	// OpenSSL verifies the MAC and decrypts both bags
+	output, err := exec.Command(openssl, "pkcs12", "-in", file.Name(), "-passin", "pass:"+certificate.Password, "-nodes").CombinedOutput()
+	if err != nil {
+		t.Fatalf("openssl rejected the archive: %v\n%s", err, output)
+	}
+	if _, err := tls.X509KeyPair(output, output); err != nil {
+		t.Errorf("expected openssl to read the certificate and its key, got %v\n%s", err, output)
+	}
+}
+
+func TestNewTLSCertificateFingerprint(t *testing.T) {
+	certPEM, keyPEM := newTestCertificatePEM(t, "foo.example.com")
+	first, err := NewTLSCertificate(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewTLSCertificate(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Fingerprint != second.Fingerprint {
+		t.Errorf("expected the same certificate to keep its fingerprint, got %v and %v", first.Fingerprint, second.Fingerprint)
+	}
+
+	rotatedCertPEM, rotatedKeyPEM := newTestCertificatePEM(t, "foo.example.com")
+	rotated, err := NewTLSCertificate(rotatedCertPEM, rotatedKeyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.Fingerprint == first.Fingerprint {
+		t.Errorf("expected a rotated certificate to change its fingerprint")
+	}
+
+	if _, err := NewTLSCertificate(certPEM, rotatedKeyPEM); err == nil {
+		t.Errorf("expected an error for a key not matching the certificate")
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/jargoonpard/appGatewaySample/kubernetes/azurecontroller"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/logging"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/redact"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/client/cache"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/runtime"
+	"k8s.io/kubernetes/pkg/util/intstr"
+	"k8s.io/kubernetes/pkg/watch"
+)
+
+// ingressBackends returns every backend referenced by an ingress, including
+// its default backend.
+func ingressBackends(ingress *extensions.Ingress) []extensions.IngressBackend {
+	backends := []extensions.IngressBackend{}
+	if ingress.Spec.Backend != nil {
+		backends = append(backends, *ingress.Spec.Backend)
+	}
+	for _, rule := range ingress.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			backends = append(backends, path.Backend)
+		}
+	}
+	return backends
+}
+
+// ingressReferencesService determines if any backend of the ingress is served
+// by the given service.
+func ingressReferencesService(ingress *extensions.Ingress, namespace, name string) bool {
+	if ingress.Namespace != namespace {
+		return false
+	}
+	for _, backend := range ingressBackends(ingress) {
+		if backend.ServiceName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// enqueueIngressesForService queues every Azure ingress routing traffic to the
+// given service so its backend pools pick up address changes.
+func (lbc *loadBalancerController) enqueueIngressesForService(namespace, name string) {
+	for _, obj := range lbc.ingressStore.List() {
+		ingress := obj.(*extensions.Ingress)
+		if isAzureIngress(ingress) && ingressReferencesService(ingress, namespace, name) {
+			logging.V(3).Infof("service %v/%v changed, requeuing ingress %v/%v", namespace, name, ingress.Namespace, ingress.Name)
+			lbc.ingressQueue.enqueue(ingress)
+		}
+	}
+}
+
+// serviceEventHandler requeues the ingresses of a service or endpoints object
+// whenever it changes.
+func (lbc *loadBalancerController) serviceEventHandler() cache.ResourceEventHandlerFuncs {
+	enqueue := func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		meta, err := api.ObjectMetaFor(obj.(runtime.Object))
+		if err != nil {
+			logging.Infof("could not get metadata for object %v: %v", redact.Value(obj), redact.Value(err))
+			return
+		}
+		lbc.enqueueIngressesForService(meta.Namespace, meta.Name)
+	}
+
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: enqueue,
+		UpdateFunc: func(old, cur interface{}) {
+			if !reflect.DeepEqual(old, cur) {
+				enqueue(cur)
+			}
+		},
+		DeleteFunc: enqueue,
+	}
+}
+
+// resolveBackends maps every backend of the ingress onto the addresses the
+// gateway can reach it on and the probe checking them. Backends that cannot be
+// resolved are reported as events and left out, which leaves their pools empty.
+func (lbc *loadBalancerController) resolveBackends(ingress *extensions.Ingress, parsed gatewayAnnotations) map[extensions.IngressBackend]azurecontroller.BackendTarget {
+	annotations := ingressAnnotations(ingress.Annotations)
+	addressType := annotations.backendAddressType()
+	probeProtocol := parsed.backendProtocol(annotations)
+
+	targets := map[extensions.IngressBackend]azurecontroller.BackendTarget{}
+	for _, backend := range ingressBackends(ingress) {
+		if _, ok := targets[backend]; ok {
+			continue
+		}
+
+		target, err := lbc.resolveBackend(ingress.Namespace, backend, addressType)
+		if err != nil {
+			logging.Warningf("ingress %v/%v: %v", ingress.Namespace, ingress.Name, err)
+			lbc.recorder.Eventf(ingress, api.EventTypeWarning, "BACKEND", "%v", err)
+			continue
+		}
+		target.Probe = parsed.probe.apply(target.Probe, probeProtocol)
+		targets[backend] = target
+	}
+	return targets
+}
+
+func (lbc *loadBalancerController) resolveBackend(namespace string, backend extensions.IngressBackend, addressType string) (azurecontroller.BackendTarget, error) {
+	target := azurecontroller.BackendTarget{}
+
+	obj, exists, err := lbc.serviceStore.GetByKey(fmt.Sprintf("%s/%s", namespace, backend.ServiceName))
+	if err != nil {
+		return target, err
+	}
+	if !exists {
+		return target, fmt.Errorf("service %v/%v does not exist", namespace, backend.ServiceName)
+	}
+	service := obj.(*api.Service)
+
+	servicePort, ok := findServicePort(service, backend.ServicePort)
+	if !ok {
+		return target, fmt.Errorf("service %v/%v has no port %v", namespace, backend.ServiceName, backend.ServicePort.String())
+	}
+
+	switch addressType {
+	case podAddressType:
+		target, err = lbc.podTarget(service, servicePort)
+		if err != nil {
+			return target, err
+		}
+		target.Probe = lbc.readinessProbe(service, servicePort)
+		return target, nil
+	case nodeAddressType:
+		if servicePort.NodePort == 0 {
+			return target, fmt.Errorf("service %v/%v has no node port for port %v", namespace, backend.ServiceName, backend.ServicePort.String())
+		}
+		target.Addresses = lbc.nodeAddresses()
+		target.Port = servicePort.NodePort
+		target.Probe = lbc.readinessProbe(service, servicePort)
+		return target, nil
+	}
+
+	return target, fmt.Errorf("unknown %v %q, expected %q or %q", backendAddressTypeKey, addressType, podAddressType, nodeAddressType)
+}
+
+// podTarget collects the ready pod addresses behind a service port from the
+// endpoints of the service.
+func (lbc *loadBalancerController) podTarget(service *api.Service, servicePort api.ServicePort) (azurecontroller.BackendTarget, error) {
+	target := azurecontroller.BackendTarget{}
+
+	obj, exists, err := lbc.endpointsStore.GetByKey(fmt.Sprintf("%s/%s", service.Namespace, service.Name))
+	if err != nil {
+		return target, err
+	}
+	if !exists {
+		return target, fmt.Errorf("service %v/%v has no endpoints", service.Namespace, service.Name)
+	}
+
+	// the gateway uses a single port per pool, subsets exposing the port
+	// under a different number are skipped
+	for _, subset := range obj.(*api.Endpoints).Subsets {
+		for _, port := range subset.Ports {
+			if port.Name != servicePort.Name {
+				continue
+			}
+			if target.Port == 0 {
+				target.Port = port.Port
+			}
+			if port.Port != target.Port {
+				continue
+			}
+			for _, address := range subset.Addresses {
+				target.Addresses = append(target.Addresses, address.IP)
+			}
+		}
+	}
+	sort.Strings(target.Addresses)
+
+	return target, nil
+}
+
+// findServicePort returns the service port an ingress backend refers to, either
+// by number or by name.
+func findServicePort(service *api.Service, port intstr.IntOrString) (api.ServicePort, bool) {
+	for _, servicePort := range service.Spec.Ports {
+		if port.Type == intstr.Int && servicePort.Port == port.IntVal {
+			return servicePort, true
+		}
+		if port.Type == intstr.String && servicePort.Name == port.StrVal {
+			return servicePort, true
+		}
+	}
+	return api.ServicePort{}, false
+}
+
+func serviceListFunc(kubeClient *client.Client, namespace string) func(api.ListOptions) (runtime.Object, error) {
+	return func(opts api.ListOptions) (runtime.Object, error) {
+		return kubeClient.Services(namespace).List(opts)
+	}
+}
+
+func serviceWatchFunc(kubeClient *client.Client, namespace string) func(options api.ListOptions) (watch.Interface, error) {
+	return func(options api.ListOptions) (watch.Interface, error) {
+		return kubeClient.Services(namespace).Watch(options)
+	}
+}
+
+func endpointsListFunc(kubeClient *client.Client, namespace string) func(api.ListOptions) (runtime.Object, error) {
+	return func(opts api.ListOptions) (runtime.Object, error) {
+		return kubeClient.Endpoints(namespace).List(opts)
+	}
+}
+
+func endpointsWatchFunc(kubeClient *client.Client, namespace string) func(options api.ListOptions) (watch.Interface, error) {
+	return func(options api.ListOptions) (watch.Interface, error) {
+		return kubeClient.Endpoints(namespace).Watch(options)
+	}
+}
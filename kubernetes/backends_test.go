@@ -0,0 +1,128 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jargoonpard/appGatewaySample/kubernetes/azurecontroller"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/client/cache"
+	"k8s.io/kubernetes/pkg/client/record"
+	"k8s.io/kubernetes/pkg/util/intstr"
+)
+
+func newTestLoadBalancerController() *loadBalancerController {
+	return &loadBalancerController{
+		recorder:       record.NewFakeRecorder(100),
+		ingressStore:   cache.NewStore(cache.MetaNamespaceKeyFunc),
+		serviceStore:   cache.NewStore(cache.MetaNamespaceKeyFunc),
+		endpointsStore: cache.NewStore(cache.MetaNamespaceKeyFunc),
+		nodeStore:      cache.NewStore(cache.MetaNamespaceKeyFunc),
+		podStore:       cache.NewStore(cache.MetaNamespaceKeyFunc),
+		secretStore:    cache.NewStore(cache.MetaNamespaceKeyFunc),
+		configMapStore: cache.NewStore(cache.MetaNamespaceKeyFunc),
+		certificates:   map[string]cachedCertificate{},
+		gatewayPrefix:  "ingress",
+		gatewayLimits:  azurecontroller.DefaultGatewayLimits,
+		assignments:    map[string]string{},
+	}
+}
+
+func newTestService() *api.Service {
+	return &api.Service{
+		ObjectMeta: api.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: api.ServiceSpec{
+			Ports: []api.ServicePort{
+				{Name: "http", Port: 80, TargetPort: intstr.FromInt(8080), NodePort: 30080},
+				{Name: "admin", Port: 9000, TargetPort: intstr.FromInt(9000)},
+			},
+		},
+	}
+}
+
+func newTestEndpoints() *api.Endpoints {
+	return &api.Endpoints{
+		ObjectMeta: api.ObjectMeta{Name: "web", Namespace: "default"},
+		Subsets: []api.EndpointSubset{
+			{
+				Addresses:         []api.EndpointAddress{{IP: "10.244.1.7"}, {IP: "10.244.0.3"}},
+				NotReadyAddresses: []api.EndpointAddress{{IP: "10.244.2.9"}},
+				Ports: []api.EndpointPort{
+					{Name: "http", Port: 8080},
+					{Name: "admin", Port: 9000},
+				},
+			},
+		},
+	}
+}
+
+func TestResolveBackendPodAddresses(t *testing.T) {
+	lbc := newTestLoadBalancerController()
+	lbc.serviceStore.Add(newTestService())
+	lbc.endpointsStore.Add(newTestEndpoints())
+
+	for _, port := range []intstr.IntOrString{intstr.FromInt(80), intstr.FromString("http")} {
+		backend := extensions.IngressBackend{ServiceName: "web", ServicePort: port}
+		target, err := lbc.resolveBackend("default", backend, podAddressType)
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if target.Port != 8080 {
+			t.Errorf("expected the target port 8080 for %v, got %v", port.String(), target.Port)
+		}
+		if !reflect.DeepEqual(target.Addresses, []string{"10.244.0.3", "10.244.1.7"}) {
+			t.Errorf("expected only ready pod addresses for %v, got %v", port.String(), target.Addresses)
+		}
+	}
+}
+
+func TestResolveBackendErrors(t *testing.T) {
+	lbc := newTestLoadBalancerController()
+	lbc.serviceStore.Add(newTestService())
+
+	tests := []struct {
+		description string
+		backend     extensions.IngressBackend
+		addressType string
+	}{
+		{"missing service", extensions.IngressBackend{ServiceName: "api", ServicePort: intstr.FromInt(80)}, podAddressType},
+		{"missing port", extensions.IngressBackend{ServiceName: "web", ServicePort: intstr.FromInt(81)}, podAddressType},
+		{"missing endpoints", extensions.IngressBackend{ServiceName: "web", ServicePort: intstr.FromInt(80)}, podAddressType},
+		{"missing node port", extensions.IngressBackend{ServiceName: "web", ServicePort: intstr.FromInt(9000)}, nodeAddressType},
+		{"unknown address type", extensions.IngressBackend{ServiceName: "web", ServicePort: intstr.FromInt(80)}, "vm"},
+	}
+
+	for _, test := range tests {
+		if _, err := lbc.resolveBackend("default", test.backend, test.addressType); err == nil {
+			t.Errorf("%v: expected an error", test.description)
+		}
+	}
+}
+
+func TestIngressReferencesService(t *testing.T) {
+	ingress := &extensions.Ingress{
+		ObjectMeta: api.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: extensions.IngressSpec{
+			Backend: &extensions.IngressBackend{ServiceName: "fallback", ServicePort: intstr.FromInt(80)},
+			Rules: []extensions.IngressRule{{
+				IngressRuleValue: extensions.IngressRuleValue{
+					HTTP: &extensions.HTTPIngressRuleValue{
+						Paths: []extensions.HTTPIngressPath{{
+							Path:    "/",
+							Backend: extensions.IngressBackend{ServiceName: "web", ServicePort: intstr.FromInt(80)},
+						}},
+					},
+				},
+			}},
+		},
+	}
+
+	if !ingressReferencesService(ingress, "default", "web") || !ingressReferencesService(ingress, "default", "fallback") {
+		t.Errorf("expected the ingress to reference web and fallback")
+	}
+	if ingressReferencesService(ingress, "other", "web") || ingressReferencesService(ingress, "default", "api") {
+		t.Errorf("expected the ingress not to reference other services")
+	}
+}
@@ -2,11 +2,13 @@ package main
 
 import (
 	"fmt"
+	"reflect"
 	"sync"
 	"time"
 
-	"github.com/golang/glog"
 	"github.com/jargoonpard/appGatewaySample/kubernetes/azurecontroller"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/logging"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/redact"
 
 	"k8s.io/kubernetes/pkg/api"
 	"k8s.io/kubernetes/pkg/apis/extensions"
@@ -14,6 +16,7 @@ import (
 	"k8s.io/kubernetes/pkg/client/record"
 	client "k8s.io/kubernetes/pkg/client/unversioned"
 	"k8s.io/kubernetes/pkg/runtime"
+	"k8s.io/kubernetes/pkg/util/wait"
 	"k8s.io/kubernetes/pkg/watch"
 )
 
@@ -27,11 +30,58 @@ type loadBalancerController struct {
 	ingressStore      cache.Store
 	ingressQueue      *taskQueue
 
+	serviceController   *cache.Controller
+	serviceStore        cache.Store
+	endpointsController *cache.Controller
+	endpointsStore      cache.Store
+	nodeController      *cache.Controller
+	nodeStore           cache.Store
+	podController       *cache.Controller
+	podStore            cache.Store
+	secretController    *cache.Controller
+	secretStore         cache.Store
+	configMapController *cache.Controller
+	configMapStore      cache.Store
+
+	// certificates caches the converted TLS secrets by namespace/name
+	certificateLock sync.Mutex
+	certificates    map[string]cachedCertificate
+
+	excludedNodeLabels []string
+
+	// removedIngresses holds the last known state of ingresses that were
+	// deleted or stopped being Azure ingresses until their teardown is synced
+	removedLock      sync.Mutex
+	removedIngresses map[string]*extensions.Ingress
+
+	// ingresses without a gateway of their own are bin-packed onto shared
+	// gateways named after gatewayPrefix, assignments holds placements until
+	// the informer sees the annotation recording them
+	gatewayPrefix  string
+	gatewayLimits  azurecontroller.GatewayLimits
+	assignmentLock sync.Mutex
+	assignments    map[string]string
+
 	podInfo *podInfo
 
-	stoplock sync.Mutex
-	shutdown bool
-	stopCh   chan struct{}
+	// informerHeartbeats records the last successful list or watch of every
+	// informer for the liveness check
+	informerHeartbeats map[string]*heartbeat
+
+	// elector is nil when leader election is disabled and this replica is
+	// the only one writing the gateways
+	elector *leaderElector
+
+	resyncPeriod time.Duration
+	gcPeriod     time.Duration
+
+	stoplock    sync.Mutex
+	shutdown    bool
+	tearingDown bool
+	stopCh      chan struct{}
+	// tornDown is closed once a teardown deleted the resources of the
+	// cluster and answered the request, main then exits
+	tornDown chan struct{}
 }
 
 var (
@@ -45,16 +95,33 @@ func newLoadBalancerController(
 	kubeClient *client.Client,
 	namespace string,
 	resyncPeriod time.Duration,
-	creds azurecontroller.AzureCredentialInfo) (*loadBalancerController, error) {
+	gcPeriod time.Duration,
+	excludedNodeLabels []string,
+	gatewayPrefix string,
+	creds azurecontroller.AzureCredentialInfo,
+	options azurecontroller.ProvisioningOptions) (*loadBalancerController, error) {
 
 	eventBroadcaster := record.NewBroadcaster()
-	eventBroadcaster.StartLogging(glog.Infof)
+	eventBroadcaster.StartLogging(logging.Infof)
 	eventBroadcaster.StartRecordingToSink(kubeClient.Events(namespace))
 
+	stopCh := make(chan struct{})
 	lbc := loadBalancerController{
 		client:        kubeClient,
-		azureGWClient: azurecontroller.NewAzureGatewayClientController(creds),
-		stopCh:        make(chan struct{}),
+		azureGWClient: azurecontroller.NewAzureGatewayClientController(creds, options, stopCh),
+		resyncPeriod:  resyncPeriod,
+		gcPeriod:      gcPeriod,
+		stopCh:        stopCh,
+		tornDown:      make(chan struct{}),
+
+		excludedNodeLabels: excludedNodeLabels,
+		gatewayPrefix:      gatewayPrefix,
+		gatewayLimits:      azurecontroller.DefaultGatewayLimits,
+
+		removedIngresses:   map[string]*extensions.Ingress{},
+		informerHeartbeats: map[string]*heartbeat{},
+		assignments:        map[string]string{},
+		certificates:       map[string]cachedCertificate{},
 		recorder: eventBroadcaster.NewRecorder(api.EventSource{
 			Component: "azure-ingress-controller",
 		}),
@@ -66,21 +133,72 @@ func newLoadBalancerController(
 		AddFunc: func(obj interface{}) {
 			addIngress := obj.(*extensions.Ingress)
 			if !isAzureIngress(addIngress) {
-				glog.Infof("ignoring add for ingress %v based on annotation %v", addIngress.Name, ingressClassKey)
+				logging.Infof("ignoring add for ingress %v based on annotation %v", addIngress.Name, ingressClassKey)
 				return
 			}
-			lbc.recorder.Eventf(addIngress, api.EventTypeNormal, "CREATE", fmt.Sprintf("%s/%s", addIngress.Namespace, addIngress.Name))
+			if lbc.isLeader() {
+				lbc.recorder.Eventf(addIngress, api.EventTypeNormal, "CREATE", "%s/%s", addIngress.Namespace, addIngress.Name)
+			}
 			lbc.ingressQueue.enqueue(obj)
 		},
+		UpdateFunc: func(old, cur interface{}) {
+			lbc.ingressUpdated(old.(*extensions.Ingress), cur.(*extensions.Ingress))
+		},
+		DeleteFunc: func(obj interface{}) {
+			delIngress, ok := obj.(*extensions.Ingress)
+			if !ok {
+				tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+				if !ok {
+					logging.Errorf("couldn't get object from tombstone %v", redact.Value(obj))
+					return
+				}
+				delIngress, ok = tombstone.Obj.(*extensions.Ingress)
+				if !ok {
+					logging.Errorf("tombstone contained object that is not an ingress %v", redact.Value(obj))
+					return
+				}
+			}
+			if !isAzureIngress(delIngress) {
+				logging.Infof("ignoring delete for ingress %v based on annotation %v", delIngress.Name, ingressClassKey)
+				return
+			}
+			if lbc.isLeader() {
+				lbc.recorder.Eventf(delIngress, api.EventTypeNormal, "DELETE", "%s/%s", delIngress.Namespace, delIngress.Name)
+			}
+			lbc.removeIngress(delIngress)
+		},
 	}
 
 	lbc.ingressStore, lbc.ingressController = cache.NewInformer(
-		&cache.ListWatch{
-			ListFunc:  ingressListFunc(lbc.client, namespace),
-			WatchFunc: ingressWatchFunc(lbc.client, namespace),
-		},
+		lbc.listWatch("ingress", ingressListFunc(lbc.client, namespace), ingressWatchFunc(lbc.client, namespace)),
 		&extensions.Ingress{}, resyncPeriod, ingressEventHandler)
 
+	lbc.serviceStore, lbc.serviceController = cache.NewInformer(
+		lbc.listWatch("service", serviceListFunc(lbc.client, namespace), serviceWatchFunc(lbc.client, namespace)),
+		&api.Service{}, resyncPeriod, lbc.serviceEventHandler())
+
+	lbc.endpointsStore, lbc.endpointsController = cache.NewInformer(
+		lbc.listWatch("endpoints", endpointsListFunc(lbc.client, namespace), endpointsWatchFunc(lbc.client, namespace)),
+		&api.Endpoints{}, resyncPeriod, lbc.serviceEventHandler())
+
+	lbc.nodeStore, lbc.nodeController = cache.NewInformer(
+		lbc.listWatch("node", nodeListFunc(lbc.client), nodeWatchFunc(lbc.client)),
+		&api.Node{}, resyncPeriod, lbc.nodeEventHandler())
+
+	// pods are only looked up for their readiness probes, changes reach the
+	// ingresses through the endpoints of their services
+	lbc.podStore, lbc.podController = cache.NewInformer(
+		lbc.listWatch("pod", podListFunc(lbc.client, namespace), podWatchFunc(lbc.client, namespace)),
+		&api.Pod{}, resyncPeriod, cache.ResourceEventHandlerFuncs{})
+
+	lbc.secretStore, lbc.secretController = cache.NewInformer(
+		lbc.listWatch("secret", secretListFunc(lbc.client, namespace), secretWatchFunc(lbc.client, namespace)),
+		&api.Secret{}, resyncPeriod, lbc.certificateEventHandler(secretKind))
+
+	lbc.configMapStore, lbc.configMapController = cache.NewInformer(
+		lbc.listWatch("configMap", configMapListFunc(lbc.client, namespace), configMapWatchFunc(lbc.client, namespace)),
+		&api.ConfigMap{}, resyncPeriod, lbc.certificateEventHandler(configMapKind))
+
 	return &lbc, nil
 }
 
@@ -104,15 +222,25 @@ func (lbc *loadBalancerController) Stop() error {
 		lbc.shutdown = true
 		close(lbc.stopCh)
 
-		glog.Infof("Shutting down controller queues")
+		logging.Infof("Shutting down controller queues")
 		lbc.ingressQueue.shutdown()
 	}
 
 	return nil
 }
 
+func (lbc *loadBalancerController) controllersInSync() bool {
+	return lbc.ingressController.HasSynced() &&
+		lbc.serviceController.HasSynced() &&
+		lbc.endpointsController.HasSynced() &&
+		lbc.nodeController.HasSynced() &&
+		lbc.podController.HasSynced() &&
+		lbc.secretController.HasSynced() &&
+		lbc.configMapController.HasSynced()
+}
+
 func (lbc *loadBalancerController) updateIngress(key string) error {
-	if !lbc.ingressController.HasSynced() {
+	if !lbc.controllersInSync() {
 		time.Sleep(storeSyncPollPeriod)
 		return fmt.Errorf("deferring sync till endpoints controller has synced")
 	}
@@ -122,25 +250,275 @@ func (lbc *loadBalancerController) updateIngress(key string) error {
 		return err
 	}
 
-	if !ingressExists {
-		// TODO: what's the correct behavior here?
+	if !ingressExists || !isAzureIngress(obj.(*extensions.Ingress)) {
+		if err := lbc.teardownIngress(key); err != nil {
+			return err
+		}
+		forgetSync(key)
 		return nil
 	}
 
 	ingress := obj.(*extensions.Ingress)
-	glog.Infof("Ingress client retrieved %v", ingress.Name)
+
+	// an ingress that moved to another gateway releases the previous one,
+	// one that was recreated is served again and has nothing to tear down
+	lbc.removedLock.Lock()
+	removed, wasRemoved := lbc.removedIngresses[key]
+	lbc.removedLock.Unlock()
+	if wasRemoved && lbc.releasesGateway(removed, ingress) {
+		if err := lbc.teardownIngress(key); err != nil {
+			return err
+		}
+	}
+	lbc.removedLock.Lock()
+	delete(lbc.removedIngresses, key)
+	lbc.removedLock.Unlock()
+
+	logging.Infof("Ingress client retrieved %v", ingress.Name)
+
+	gatewayName, err := lbc.placeIngress(ingress)
+	if err != nil {
+		return err
+	}
+	states, err := lbc.gatewayIngressStates(ingress, gatewayName)
+	if err != nil {
+		return err
+	}
+	if err := azurecontroller.MeasureGateway(states).Exceeds(lbc.gatewayLimits); err != nil {
+		logging.Warningf("gateway %v of ingress %v: %v", gatewayName, key, err)
+		lbc.recorder.Eventf(ingress, api.EventTypeWarning, "CAPACITY", "gateway %v: %v", gatewayName, err)
+	}
+	for _, conflict := range azurecontroller.Conflicts(states) {
+		logging.Warningf("ingress %v/%v: %v", conflict.Ingress.Namespace, conflict.Ingress.Name, conflict)
+		lbc.recorder.Eventf(conflict.Ingress, api.EventTypeWarning, "CONFLICT", "%v", conflict)
+	}
 
 	//synchronize with Azure
-	lbc.azureGWClient.SyncApplicationGateway(ingress)
+	result, err := lbc.azureGWClient.SyncApplicationGateway(gatewayName, states)
+	if err != nil {
+		return err
+	}
+	if result.ConcurrentUpdates > 0 {
+		lbc.recorder.Eventf(ingress, api.EventTypeNormal, "CONCURRENT_UPDATE",
+			"gateway %v was changed concurrently, the update was recomputed %d times", gatewayName, result.ConcurrentUpdates)
+	}
+
+	address, err := lbc.azureGWClient.FrontendAddress(gatewayName)
+	if err != nil {
+		return err
+	}
+	if address == "" {
+		// Azure allocates dynamic public addresses once the gateway runs
+		logging.Infof("Gateway of ingress %v has no address yet", key)
+		return nil
+	}
+	return lbc.updateIngressStatus(ingress, address)
+}
+
+// gatewayIngressStates resolves every Azure ingress served by the gateway, the
+// gateway is always translated from all of them so syncing one ingress never
+// drops the routes of the others.
+func (lbc *loadBalancerController) gatewayIngressStates(ingress *extensions.Ingress, gatewayName string) ([]azurecontroller.IngressState, error) {
+	states := []azurecontroller.IngressState{}
+	for _, obj := range lbc.ingressStore.List() {
+		other := obj.(*extensions.Ingress)
+		if other.Namespace == ingress.Namespace && other.Name == ingress.Name {
+			continue
+		}
+		if !isAzureIngress(other) || lbc.gatewayName(other) != gatewayName {
+			continue
+		}
+
+		// invalid annotations are reported when the ingress itself syncs
+		parsed, _ := parseGatewayAnnotations(ingressAnnotations(other.Annotations))
+		state, err := lbc.ingressState(other, parsed)
+		if err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+
+	state, err := lbc.ingressState(ingress, lbc.gatewayAnnotations(ingress))
+	if err != nil {
+		return nil, err
+	}
+	return append(states, state), nil
+}
+
+// ingressState resolves the cluster state an ingress is translated from.
+func (lbc *loadBalancerController) ingressState(ingress *extensions.Ingress, parsed gatewayAnnotations) (azurecontroller.IngressState, error) {
+	backendCertificates, err := lbc.resolveBackendCertificates(ingress)
+	if err != nil {
+		return azurecontroller.IngressState{}, err
+	}
+
+	return azurecontroller.IngressState{
+		Ingress:             ingress,
+		Backends:            lbc.resolveBackends(ingress, parsed),
+		Certificates:        lbc.resolveCertificates(ingress),
+		BackendCertificates: backendCertificates,
+		Settings:            parsed.settings,
+	}, nil
+}
+
+// ingressUpdated queues a changed ingress. An ingress that moved to another
+// gateway is also removed from the previous one.
+func (lbc *loadBalancerController) ingressUpdated(oldIngress, curIngress *extensions.Ingress) {
+	if !isAzureIngress(curIngress) {
+		if isAzureIngress(oldIngress) {
+			logging.Infof("ingress %v/%v is no longer an azure ingress, removing it", curIngress.Namespace, curIngress.Name)
+			lbc.removeIngress(oldIngress)
+		}
+		return
+	}
+	if reflect.DeepEqual(oldIngress.Spec, curIngress.Spec) && reflect.DeepEqual(oldIngress.Annotations, curIngress.Annotations) {
+		return
+	}
+	if lbc.releasesGateway(oldIngress, curIngress) {
+		logging.Infof("ingress %v/%v moved to gateway %v, releasing gateway %v", curIngress.Namespace, curIngress.Name, lbc.gatewayName(curIngress), lbc.gatewayName(oldIngress))
+		lbc.removeIngress(oldIngress)
+	}
+	if lbc.isLeader() {
+		lbc.recorder.Eventf(curIngress, api.EventTypeNormal, "UPDATE", "%s/%s", curIngress.Namespace, curIngress.Name)
+	}
+	lbc.ingressQueue.enqueue(curIngress)
+}
+
+// releasesGateway determines if an ingress left the gateway of its previous
+// version. Both versions resolve pending placements, so recording the
+// placement of an ingress is not mistaken for a move.
+func (lbc *loadBalancerController) releasesGateway(previous, ingress *extensions.Ingress) bool {
+	previousGateway := lbc.gatewayName(previous)
+	return previousGateway != "" && previousGateway != lbc.gatewayName(ingress)
+}
+
+// removeIngress remembers an ingress that should no longer be served and
+// queues it so its Azure resources are torn down.
+func (lbc *loadBalancerController) removeIngress(ingress *extensions.Ingress) {
+	key, err := keyFunc(ingress)
+	if err != nil {
+		logging.Infof("could not get key for object %v: %v", redact.Value(ingress), redact.Value(err))
+		return
+	}
+
+	lbc.removedLock.Lock()
+	lbc.removedIngresses[key] = ingress
+	lbc.removedLock.Unlock()
+
+	lbc.ingressQueue.enqueue(ingress)
+}
+
+// teardownIngress removes the Azure resources of an ingress that was deleted.
+// A gateway still used by other ingresses is resynced from them, which drops
+// the listeners, rules and pools of the removed ingress. Otherwise a gateway
+// the controller created is deleted with its public IP, any other gateway only
+// loses the parts of the removed ingress.
+func (lbc *loadBalancerController) teardownIngress(key string) error {
+	lbc.removedLock.Lock()
+	ingress, ok := lbc.removedIngresses[key]
+	lbc.removedLock.Unlock()
+	if !ok {
+		logging.V(3).Infof("no Azure resources known for removed ingress %v", key)
+		return nil
+	}
+
+	// ingresses that were never placed on a gateway have nothing to release
+	if gatewayName := lbc.gatewayName(ingress); gatewayName != "" {
+		shared := false
+		for _, obj := range lbc.ingressStore.List() {
+			other := obj.(*extensions.Ingress)
+			otherKey, err := keyFunc(other)
+			if err != nil || otherKey == key || !isAzureIngress(other) {
+				continue
+			}
+			if lbc.gatewayName(other) == gatewayName {
+				shared = true
+				lbc.ingressQueue.enqueue(other)
+			}
+		}
+
+		if !shared {
+			logging.Infof("Releasing gateway %v of ingress %v", gatewayName, key)
+			if err := lbc.azureGWClient.ReleaseApplicationGateway(gatewayName, ingress); err != nil {
+				return err
+			}
+		}
+	}
+
+	// an ingress that still exists but is no longer served must not keep
+	// advertising the gateway address
+	if obj, exists, err := lbc.ingressStore.GetByKey(key); err == nil && exists {
+		if err := lbc.updateIngressStatus(obj.(*extensions.Ingress), ""); err != nil {
+			return err
+		}
+	}
+
+	lbc.forgetAssignment(key)
+	lbc.removedLock.Lock()
+	if lbc.removedIngresses[key] == ingress {
+		delete(lbc.removedIngresses, key)
+	}
+	lbc.removedLock.Unlock()
 
 	return nil
 }
 
+// enqueueAllIngresses queues every Azure ingress so that gateways which drifted
+// from their ingress, e.g. through edits in the portal, are corrected.
+func (lbc *loadBalancerController) enqueueAllIngresses() {
+	for _, obj := range lbc.ingressStore.List() {
+		if isAzureIngress(obj.(*extensions.Ingress)) {
+			lbc.ingressQueue.enqueue(obj)
+		}
+	}
+}
+
+// Run starts the informers and, once this replica leads, the workers writing
+// the gateways. Standbys keep their informer caches warm to take over quickly.
 func (lbc *loadBalancerController) Run() {
-	glog.Infof("Starting Azure ingress controller")
+	logging.Infof("Starting Azure ingress controller")
 
 	go lbc.ingressController.Run(lbc.stopCh)
-	go lbc.ingressQueue.run(time.Second, lbc.stopCh)
+	go lbc.serviceController.Run(lbc.stopCh)
+	go lbc.endpointsController.Run(lbc.stopCh)
+	go lbc.nodeController.Run(lbc.stopCh)
+	go lbc.podController.Run(lbc.stopCh)
+	go lbc.secretController.Run(lbc.stopCh)
+	go lbc.configMapController.Run(lbc.stopCh)
+	go wait.Until(lbc.checkAzure, azureCheckPeriod, lbc.stopCh)
+
+	if lbc.elector == nil {
+		lbc.runWorkers()
+	} else {
+		go lbc.elector.run(lbc.runWorkers, lbc.stopLeading, lbc.stopCh)
+	}
 	<-lbc.stopCh
-	glog.Infof("Shutting down Azure ingress controller")
+	logging.Infof("Shutting down Azure ingress controller")
+}
+
+func (lbc *loadBalancerController) runWorkers() {
+	logging.Infof("Starting the gateway sync workers")
+
+	lbc.ingressQueue.run(time.Second, lbc.stopCh)
+	go wait.Until(lbc.enqueueAllIngresses, lbc.resyncPeriod, lbc.stopCh)
+	go wait.Until(lbc.collectGarbage, lbc.gcPeriod, lbc.stopCh)
+}
+
+// stopLeading exits once the lease is lost, the workers cannot be stopped
+// half way through a sync and a restarted replica rejoins as a standby
+func (lbc *loadBalancerController) stopLeading() {
+	lbc.Stop()
+	logging.Fatalf("Lost the leader lease, exiting")
+}
+
+// isLeader determines if this replica writes the gateways
+func (lbc *loadBalancerController) isLeader() bool {
+	return lbc.elector == nil || lbc.elector.isLeader()
+}
+
+func (lbc *loadBalancerController) leaderStatus() leaderStatus {
+	if lbc.elector == nil {
+		return leaderStatus{IsLeader: true}
+	}
+	return lbc.elector.status()
 }
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/azurecontroller"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/logging"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/client/cache"
+	"k8s.io/kubernetes/pkg/runtime"
+	"k8s.io/kubernetes/pkg/watch"
+)
+
+func TestGatewayIngressStates(t *testing.T) {
+	lbc := newTestLoadBalancerController()
+	newIngress := func(namespace, name string, annotations map[string]string) *extensions.Ingress {
+		ingress := &extensions.Ingress{ObjectMeta: api.ObjectMeta{Name: name, Namespace: namespace, Annotations: annotations}}
+		lbc.ingressStore.Add(ingress)
+		return ingress
+	}
+
+	web := newIngress("default", "web", map[string]string{gatewayNameKey: "shared"})
+	newIngress("shop", "store", map[string]string{gatewayNameKey: "shared"})
+	newIngress("default", "other", nil)
+	newIngress("default", "nginx", map[string]string{gatewayNameKey: "shared", ingressClassKey: "nginx"})
+
+	states, err := lbc.gatewayIngressStates(web, ingressGatewayName(web))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 2 {
+		t.Fatalf("expected the two Azure ingresses of the shared gateway, got %v", len(states))
+	}
+	if states[1].Ingress != web {
+		t.Errorf("expected the synced ingress to be resolved last")
+	}
+}
+
+// newSyncedInformer returns an informer of an empty cluster that has synced
+func newSyncedInformer(t *testing.T, stopCh <-chan struct{}) *cache.Controller {
+	_, controller := cache.NewInformer(&cache.ListWatch{
+		ListFunc:  func(api.ListOptions) (runtime.Object, error) { return &api.List{}, nil },
+		WatchFunc: func(api.ListOptions) (watch.Interface, error) { return watch.NewFake(), nil },
+	}, &api.List{}, 0, cache.ResourceEventHandlerFuncs{})
+	go controller.Run(stopCh)
+	for start := time.Now(); !controller.HasSynced(); time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("the informer did not sync")
+		}
+	}
+	return controller
+}
+
+// captureLogs returns what glog writes to standard error while run runs, at
+// the highest verbosity
+func captureLogs(t *testing.T, run func()) string {
+	for name, value := range map[string]string{"logtostderr": "true", "v": "10"} {
+		previous := flag.Lookup(name).Value.String()
+		if err := flag.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+		defer flag.Set(name, previous)
+	}
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = writer
+	output := make(chan []byte)
+	go func() {
+		data, _ := ioutil.ReadAll(reader)
+		output <- data
+	}()
+
+	run()
+	logging.Flush()
+	os.Stderr = stderr
+	writer.Close()
+	return string(<-output)
+}
+
+// Azure echoes the rejected gateway, certificates included, in its error
+func TestSyncLogsNoSecret(t *testing.T) {
+	created := map[string][]byte{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		body, _ := ioutil.ReadAll(r.Body)
+		switch {
+		case r.Method == "PUT" && strings.Contains(r.URL.Path, "/applicationGateways/"):
+			message, _ := json.Marshal("invalid gateway " + string(body))
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error": {"code": "InvalidRequestFormat", "message": ` + string(message) + `}}`))
+		case r.Method == "PUT":
+			created[r.URL.Path] = body
+			w.Write(body)
+		case created[r.URL.Path] != nil:
+			w.Write(created[r.URL.Path])
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"code": "ResourceNotFound", "message": "not found"}}`))
+		}
+	}))
+	defer server.Close()
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	lbc := newTestLoadBalancerController()
+	lbc.ingressController = newSyncedInformer(t, stopCh)
+	lbc.serviceController = newSyncedInformer(t, stopCh)
+	lbc.endpointsController = newSyncedInformer(t, stopCh)
+	lbc.nodeController = newSyncedInformer(t, stopCh)
+	lbc.podController = newSyncedInformer(t, stopCh)
+	lbc.secretController = newSyncedInformer(t, stopCh)
+	lbc.configMapController = newSyncedInformer(t, stopCh)
+	environment := azure.PublicCloud
+	environment.ResourceManagerEndpoint = server.URL
+	lbc.azureGWClient = azurecontroller.NewAzureGatewayClientController(azurecontroller.AzureCredentialInfo{
+		ResourceGroupName: "group",
+		SubscriptionID:    "subscription",
+		Region:            "westus",
+		Authorizer:        autorest.NullAuthorizer{},
+		Environment:       environment,
+	}, azurecontroller.ProvisioningOptions{
+		SubnetID: "/subscriptions/subscription/resourceGroups/group/providers/Microsoft.Network/virtualNetworks/vnet/subnets/gateways",
+		Capacity: 1,
+	}, stopCh)
+
+	secret := newTestSecret(t, "1")
+	lbc.secretStore.Add(secret)
+	ingress := newTestTLSIngress()
+	ingress.Annotations = map[string]string{gatewayNameKey: "web"}
+	ingress.Spec.Rules = newTestHostIngress("web", "foo.example.com").Spec.Rules
+	lbc.ingressStore.Add(ingress)
+	certificate := lbc.resolveCertificates(ingress)["web-tls"]
+
+	var err error
+	logs := captureLogs(t, func() {
+		err = lbc.updateIngress("default/web")
+	})
+	if err == nil || !strings.Contains(logs, "InvalidRequestFormat") {
+		t.Fatalf("expected the rejected gateway to be logged, got %v\n%s", err, logs)
+	}
+	keyBlock := bytes.Split(secret.Data[api.TLSPrivateKeyKey], []byte("\n"))[1]
+	for name, value := range map[string]string{"PFX": certificate.Data[:64], "password": certificate.Password, "private key": string(keyBlock)} {
+		if strings.Contains(logs, value) {
+			t.Errorf("the %v of the certificate reached the logs:\n%s", name, logs)
+		}
+	}
+}
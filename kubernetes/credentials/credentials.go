@@ -0,0 +1,180 @@
+// Package credentials loads the Azure settings of the binaries from their
+// flags, the environment and the azure.json file of the Kubernetes Azure
+// cloud provider, which is usually mounted from a secret. It only depends on
+// the standard library so that every binary of the repository can use it.
+package credentials
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the Azure settings, the JSON names are those of azure.json
+type Config struct {
+	TenantID       string `json:"tenantId"`
+	SubscriptionID string `json:"subscriptionId"`
+	ClientID       string `json:"aadClientId"`
+	ClientSecret   string `json:"aadClientSecret"`
+	Region         string `json:"location"`
+	ResourceGroup  string `json:"resourceGroup"`
+
+	// ClientCertificatePath is a PEM or PFX file authenticating the client
+	// instead of the secret
+	ClientCertificatePath     string `json:"aadClientCertPath"`
+	ClientCertificatePassword string `json:"aadClientCertPassword"`
+	// UseManagedIdentity authenticates with the identity of the VM, neither
+	// the tenant nor a secret are needed then
+	UseManagedIdentity bool `json:"useManagedIdentityExtension"`
+
+	// Cloud names the Azure cloud, e.g. AzureChinaCloud, CloudEnvironmentFile
+	// holds the endpoints of a custom cloud such as Azure Stack instead
+	Cloud                string `json:"cloud"`
+	CloudEnvironmentFile string `json:"cloudEnvironmentFile"`
+}
+
+// settings names every setting in each of its sources and when it is required
+var settings = []struct {
+	flag     string
+	env      string
+	key      string
+	field    func(*Config) *string
+	required func(Config) bool
+}{
+	{"tenantID", "AZURE_TENANT_ID", "tenantId", func(config *Config) *string { return &config.TenantID }, usesServicePrincipal},
+	{"subscriptionID", "AZURE_SUBSCRIPTION_ID", "subscriptionId", func(config *Config) *string { return &config.SubscriptionID }, always},
+	{"clientID", "AZURE_CLIENT_ID", "aadClientId", func(config *Config) *string { return &config.ClientID }, usesServicePrincipal},
+	{"clientSecret", "AZURE_CLIENT_SECRET", "aadClientSecret", func(config *Config) *string { return &config.ClientSecret }, usesClientSecret},
+	{"region", "AZURE_REGION", "location", func(config *Config) *string { return &config.Region }, always},
+	{"resourceGroup", "AZURE_RESOURCE_GROUP", "resourceGroup", func(config *Config) *string { return &config.ResourceGroup }, always},
+	{"clientCertificatePath", "AZURE_CLIENT_CERTIFICATE_PATH", "aadClientCertPath", func(config *Config) *string { return &config.ClientCertificatePath }, never},
+	{"clientCertificatePassword", "AZURE_CLIENT_CERTIFICATE_PASSWORD", "aadClientCertPassword", func(config *Config) *string { return &config.ClientCertificatePassword }, never},
+	{"cloud", "AZURE_CLOUD", "cloud", func(config *Config) *string { return &config.Cloud }, never},
+	{"cloudEnvironmentFile", "AZURE_ENVIRONMENT_FILEPATH", "cloudEnvironmentFile", func(config *Config) *string { return &config.CloudEnvironmentFile }, never},
+}
+
+// useManagedIdentityEnv enables the managed identity, the only setting that
+// is not a string
+const useManagedIdentityEnv = "AZURE_USE_MANAGED_IDENTITY"
+
+func always(Config) bool { return true }
+
+func never(Config) bool { return false }
+
+func usesServicePrincipal(config Config) bool { return !config.UseManagedIdentity }
+
+func usesClientSecret(config Config) bool {
+	return !config.UseManagedIdentity && config.ClientCertificatePath == ""
+}
+
+// MissingError lists the settings no source provided
+type MissingError struct {
+	Missing []string
+}
+
+func (err MissingError) Error() string {
+	return fmt.Sprintf("missing Azure settings, set the flag, the environment variable or the azure.json key: %v", strings.Join(err.Missing, "; "))
+}
+
+// Loader merges the settings of the sources. Flags take precedence over the
+// environment, which takes precedence over the file.
+type Loader struct {
+	// Flags holds the values of the command line, empty values are unset
+	Flags Config
+	// Getenv looks up environment variables, it defaults to os.Getenv
+	Getenv func(string) string
+	// File is the path of an azure.json file, empty when there is none
+	File string
+}
+
+// Load merges the sources and fails listing every setting still missing
+func (loader Loader) Load() (Config, error) {
+	config, _, err := loader.load()
+	return config, err
+}
+
+func (loader Loader) load() (Config, []byte, error) {
+	config := Config{}
+	var content []byte
+	if loader.File != "" {
+		var err error
+		content, err = ioutil.ReadFile(loader.File)
+		if err != nil {
+			return config, nil, fmt.Errorf("reading the Azure config file %v: %v", loader.File, err)
+		}
+		if err := json.Unmarshal(content, &config); err != nil {
+			return config, content, fmt.Errorf("parsing the Azure config file %v: %v", loader.File, err)
+		}
+	}
+
+	getenv := loader.Getenv
+	if getenv == nil {
+		getenv = os.Getenv
+	}
+	for _, setting := range settings {
+		if value := getenv(setting.env); value != "" {
+			*setting.field(&config) = value
+		}
+		if value := *setting.field(&loader.Flags); value != "" {
+			*setting.field(&config) = value
+		}
+	}
+	if value := getenv(useManagedIdentityEnv); value != "" {
+		useManagedIdentity, err := strconv.ParseBool(value)
+		if err != nil {
+			return config, content, fmt.Errorf("parsing %v: %v", useManagedIdentityEnv, err)
+		}
+		config.UseManagedIdentity = useManagedIdentity
+	}
+	if loader.Flags.UseManagedIdentity {
+		config.UseManagedIdentity = true
+	}
+
+	// which settings are required depends on how the client authenticates
+	missing := []string{}
+	for _, setting := range settings {
+		if setting.required(config) && *setting.field(&config) == "" {
+			missing = append(missing, fmt.Sprintf("--%v, %v or %v", setting.flag, setting.env, setting.key))
+		}
+	}
+	if len(missing) > 0 {
+		return config, content, MissingError{Missing: missing}
+	}
+	return config, content, nil
+}
+
+// Watch reloads the settings in the background whenever the content of the
+// file changes, checking every period until stopCh is closed. Changes that
+// leave settings missing or the file unparsable are passed to onError and
+// skipped.
+func (loader Loader) Watch(period time.Duration, stopCh <-chan struct{}, onChange func(Config), onError func(error)) {
+	if loader.File == "" {
+		return
+	}
+	_, last, _ := loader.load()
+	go func() {
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-time.After(period):
+			}
+
+			config, content, err := loader.load()
+			if content == nil || bytes.Equal(content, last) {
+				continue
+			}
+			last = content
+			if err != nil {
+				onError(err)
+				continue
+			}
+			onChange(config)
+		}
+	}()
+}
@@ -0,0 +1,140 @@
+package credentials
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const azureJSON = `{
+	"cloud": "AzurePublicCloud",
+	"tenantId": "file-tenant",
+	"subscriptionId": "file-subscription",
+	"aadClientId": "file-client",
+	"aadClientSecret": "file-secret",
+	"resourceGroup": "file-group",
+	"location": "westus"
+}`
+
+func writeConfigFile(t *testing.T, dir, content string) string {
+	// replaced in one step like the files of mounted secrets
+	path := filepath.Join(dir, "azure.json")
+	if err := ioutil.WriteFile(path+".tmp", []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	loader := Loader{
+		Flags: Config{ClientSecret: "flag-secret"},
+		Getenv: func(name string) string {
+			return map[string]string{"AZURE_CLIENT_SECRET": "env-secret", "AZURE_CLIENT_ID": "env-client"}[name]
+		},
+		File: writeConfigFile(t, dir, azureJSON),
+	}
+	config, err := loader.Load()
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	expected := Config{
+		TenantID:       "file-tenant",
+		SubscriptionID: "file-subscription",
+		ClientID:       "env-client",
+		ClientSecret:   "flag-secret",
+		Region:         "westus",
+		ResourceGroup:  "file-group",
+		Cloud:          "AzurePublicCloud",
+	}
+	if config != expected {
+		t.Errorf("expected %+v, got %+v", expected, config)
+	}
+}
+
+func TestLoadListsMissingSettings(t *testing.T) {
+	loader := Loader{
+		Flags:  Config{TenantID: "tenant", ClientID: "client", ClientSecret: "secret", Region: "westus"},
+		Getenv: func(string) string { return "" },
+	}
+	_, err := loader.Load()
+	missing, ok := err.(MissingError)
+	if !ok || len(missing.Missing) != 2 {
+		t.Fatalf("expected the subscription and resource group to be missing, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "AZURE_SUBSCRIPTION_ID") || !strings.Contains(err.Error(), "--resourceGroup") {
+		t.Errorf("expected the error to name every source of the missing settings, got %v", err)
+	}
+}
+
+func TestWatchReloadsChangedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	loader := Loader{Getenv: func(string) string { return "" }, File: writeConfigFile(t, dir, azureJSON)}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	reloaded := make(chan Config)
+	failed := make(chan error)
+	loader.Watch(time.Millisecond, stopCh, func(config Config) { reloaded <- config }, func(err error) { failed <- err })
+
+	writeConfigFile(t, dir, `{"tenantId": "file-tenant"}`)
+	select {
+	case err := <-failed:
+		if _, ok := err.(MissingError); !ok {
+			t.Errorf("expected the incomplete file to be reported, got %v", err)
+		}
+	case <-reloaded:
+		t.Fatalf("expected an incomplete file not to be applied")
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the change to be noticed")
+	}
+
+	writeConfigFile(t, dir, strings.Replace(azureJSON, "file-secret", "rotated-secret", 1))
+	select {
+	case config := <-reloaded:
+		if config.ClientSecret != "rotated-secret" {
+			t.Errorf("expected the rotated secret, got %+v", config)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the rotated credentials to be reloaded")
+	}
+}
+
+func TestLoadRequiresSettingsOfTheAuthenticationMethod(t *testing.T) {
+	common := Config{SubscriptionID: "subscription", Region: "westus", ResourceGroup: "group"}
+
+	certificate := common
+	certificate.TenantID, certificate.ClientID, certificate.ClientCertificatePath = "tenant", "client", "/etc/azure/client.pem"
+	if _, err := (Loader{Flags: certificate, Getenv: func(string) string { return "" }}).Load(); err != nil {
+		t.Errorf("expected a certificate to replace the client secret, got %v", err)
+	}
+
+	config, err := Loader{
+		Flags:  common,
+		Getenv: func(name string) string { return map[string]string{"AZURE_USE_MANAGED_IDENTITY": "true"}[name] },
+	}.Load()
+	if err != nil || !config.UseManagedIdentity {
+		t.Errorf("expected a managed identity to need no service principal, got %+v and %v", config, err)
+	}
+
+	_, err = Loader{Flags: common, Getenv: func(string) string { return "" }}.Load()
+	if missing, ok := err.(MissingError); !ok || len(missing.Missing) != 3 {
+		t.Errorf("expected the tenant, client and secret to be missing, got %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package credentials
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// publicCloud is the name of the Azure cloud used when none is configured
+const publicCloud = "AzurePublicCloud"
+
+// CloudEnvironment resolves the Azure cloud of the settings into environment,
+// a pointer to the azure.Environment of the calling binary. The binaries
+// vendor go-autorest apart, so the endpoints are passed along as JSON: those
+// of the custom cloud described by CloudEnvironmentFile when it is set,
+// otherwise those fromName, usually azure.EnvironmentFromName, returns for
+// Cloud. Without either the public cloud is used.
+func (config Config) CloudEnvironment(environment interface{}, fromName func(name string) (interface{}, error)) error {
+	if config.CloudEnvironmentFile != "" {
+		return readEnvironmentFile(config.CloudEnvironmentFile, environment)
+	}
+
+	name := config.Cloud
+	if name == "" {
+		name = publicCloud
+	}
+	named, err := fromName(name)
+	if err != nil {
+		return fmt.Errorf("unknown Azure cloud %q, expected AzurePublicCloud, AzureChinaCloud, AzureUSGovernmentCloud or AzureGermanCloud", name)
+	}
+	content, err := json.Marshal(named)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(content, environment)
+}
+
+func readEnvironmentFile(path string, environment interface{}) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading the Azure environment file %v: %v", path, err)
+	}
+	endpoints := map[string]interface{}{}
+	if err := json.Unmarshal(content, &endpoints); err != nil {
+		return fmt.Errorf("parsing the Azure environment file %v: %v", path, err)
+	}
+
+	// the binaries only talk to the resource manager and Active Directory
+	missing := []string{}
+	for _, key := range []string{"resourceManagerEndpoint", "activeDirectoryEndpoint", "serviceManagementEndpoint"} {
+		if endpoint, _ := endpoints[key].(string); endpoint == "" {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("the Azure environment file %v lacks %v", path, strings.Join(missing, ", "))
+	}
+	if err := json.Unmarshal(content, environment); err != nil {
+		return fmt.Errorf("parsing the Azure environment file %v: %v", path, err)
+	}
+	return nil
+}
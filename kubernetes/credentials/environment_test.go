@@ -0,0 +1,59 @@
+package credentials
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/azure"
+)
+
+// the credentials package does not depend on go-autorest, its tests do
+func fromName(name string) (interface{}, error) {
+	return azure.EnvironmentFromName(name)
+}
+
+func TestCloudEnvironment(t *testing.T) {
+	named := map[string]azure.Environment{
+		"":                       azure.PublicCloud,
+		"AzureChinaCloud":        azure.ChinaCloud,
+		"azureusgovernmentcloud": azure.USGovernmentCloud,
+		"AzureGermanCloud":       azure.GermanCloud,
+	}
+	for name, expected := range named {
+		environment := azure.Environment{}
+		err := Config{Cloud: name}.CloudEnvironment(&environment, fromName)
+		if err != nil || environment != expected {
+			t.Errorf("%q: expected %v, got %v and %v", name, expected.Name, environment.Name, err)
+		}
+	}
+	environment := azure.Environment{}
+	if err := (Config{Cloud: "AzureMoonCloud"}).CloudEnvironment(&environment, fromName); err == nil || !strings.Contains(err.Error(), "AzureChinaCloud") {
+		t.Errorf("expected the unknown cloud to list the known ones, got %v", err)
+	}
+
+	file, err := ioutil.TempFile("", "environment")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	fmt.Fprint(file, `{
+		"name": "AzureStackCloud",
+		"resourceManagerEndpoint": "https://management.local.azurestack.external/",
+		"activeDirectoryEndpoint": "https://login.microsoftonline.com/",
+		"serviceManagementEndpoint": "https://management.adfs.azurestack.local/"
+	}`)
+	file.Close()
+
+	err = Config{Cloud: "AzureChinaCloud", CloudEnvironmentFile: file.Name()}.CloudEnvironment(&environment, fromName)
+	if err != nil || environment.ResourceManagerEndpoint != "https://management.local.azurestack.external/" {
+		t.Errorf("expected the custom cloud to take precedence, got %+v and %v", environment, err)
+	}
+
+	ioutil.WriteFile(file.Name(), []byte(`{"name": "AzureStackCloud"}`), 0600)
+	if err := (Config{CloudEnvironmentFile: file.Name()}).CloudEnvironment(&azure.Environment{}, fromName); err == nil || !strings.Contains(err.Error(), "resourceManagerEndpoint") {
+		t.Errorf("expected the missing endpoints to be reported, got %v", err)
+	}
+}
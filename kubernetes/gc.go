@@ -0,0 +1,47 @@
+package main
+
+import (
+	"time"
+
+	"github.com/jargoonpard/appGatewaySample/kubernetes/logging"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/redact"
+
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+// collectGarbage deletes the Azure resources of the cluster that no ingress
+// uses any more. It only runs once the informers have synced, an empty ingress
+// store would otherwise release every gateway.
+func (lbc *loadBalancerController) collectGarbage() {
+	if lbc.isTearingDown() {
+		return
+	}
+	if !lbc.controllersInSync() {
+		logging.V(3).Infof("deferring garbage collection till the informers have synced")
+		return
+	}
+
+	// gateways the workers write to while the ingresses are listed are
+	// kept by the Azure controller
+	inUseSince := time.Now()
+	inUse := map[string]bool{}
+	for _, obj := range lbc.ingressStore.List() {
+		ingress := obj.(*extensions.Ingress)
+		if name := lbc.gatewayName(ingress); isAzureIngress(ingress) && name != "" {
+			inUse[name] = true
+		}
+	}
+
+	// removed ingresses are released by their teardown
+	lbc.removedLock.Lock()
+	for _, ingress := range lbc.removedIngresses {
+		if name := ingressGatewayName(ingress); name != "" {
+			inUse[name] = true
+		}
+	}
+	lbc.removedLock.Unlock()
+
+	if err := lbc.azureGWClient.CollectGarbage(inUse, inUseSince); err != nil {
+		logging.Errorf("Garbage collection failed: %v", redact.Value(err))
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jargoonpard/appGatewaySample/kubernetes/logging"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/redact"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/client/cache"
+	"k8s.io/kubernetes/pkg/runtime"
+	"k8s.io/kubernetes/pkg/watch"
+)
+
+const (
+	// informers restart their watches at least every ten minutes
+	informerStallTimeout = 15 * time.Minute
+	// creating a gateway keeps Azure busy for up to half an hour
+	syncStallTimeout = time.Hour
+	// azureCheckPeriod is how often the token and the resource manager are
+	// checked for the readiness of the controller
+	azureCheckPeriod = time.Minute
+)
+
+// heartbeat records when something last made progress
+type heartbeat struct {
+	lock sync.Mutex
+	last time.Time
+}
+
+func (h *heartbeat) beat() {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.last = time.Now()
+}
+
+func (h *heartbeat) lastBeat() time.Time {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.last
+}
+
+// listWatch creates the list watch of an informer that records every
+// successful list and watch in the heartbeat of the informer
+func (lbc *loadBalancerController) listWatch(name string, listFunc func(api.ListOptions) (runtime.Object, error), watchFunc func(api.ListOptions) (watch.Interface, error)) *cache.ListWatch {
+	beat := &heartbeat{}
+	lbc.informerHeartbeats[name] = beat
+
+	return &cache.ListWatch{
+		ListFunc: func(options api.ListOptions) (runtime.Object, error) {
+			obj, err := listFunc(options)
+			if err == nil {
+				beat.beat()
+			}
+			return obj, err
+		},
+		WatchFunc: func(options api.ListOptions) (watch.Interface, error) {
+			w, err := watchFunc(options)
+			if err == nil {
+				beat.beat()
+			}
+			return w, err
+		},
+	}
+}
+
+// checkAzure records whether Azure can be reached for the readiness check
+func (lbc *loadBalancerController) checkAzure() {
+	if err := lbc.azureGWClient.CheckAzure(); err != nil {
+		logging.Warningf("Azure health check failed: %v", redact.Value(err))
+	}
+}
+
+// livenessProblems lists why the controller stopped making progress, an
+// informer that cannot list or watch or a sync that never returns
+func (lbc *loadBalancerController) livenessProblems(now time.Time) []string {
+	problems := []string{}
+	for name, beat := range lbc.informerHeartbeats {
+		if last := beat.lastBeat(); !last.IsZero() && now.Sub(last) > informerStallTimeout {
+			problems = append(problems, fmt.Sprintf("the %v informer has not listed or watched since %v", name, last.Format(time.RFC3339)))
+		}
+	}
+	if busy := lbc.ingressQueue.busyFor(now); busy > syncStallTimeout {
+		problems = append(problems, fmt.Sprintf("the sync worker has been busy with one ingress for %v", busy))
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// readinessProblems lists why the controller cannot serve yet, the informers
+// have to be synced and Azure must have been reached within the window
+func (lbc *loadBalancerController) readinessProblems(now time.Time, window time.Duration) []string {
+	problems := []string{}
+	if !lbc.controllersInSync() {
+		problems = append(problems, "the informers have not synced")
+	}
+
+	health := lbc.azureGWClient.Health()
+	if lbc.azureGWClient.RefreshesToken() && now.Sub(health.LastTokenRefresh) > window {
+		problems = append(problems, fmt.Sprintf("the Azure token was not refreshed within %v: %v", window, health.LastError))
+	}
+	if now.Sub(health.LastARMCall) > window {
+		problems = append(problems, fmt.Sprintf("the Azure resource manager was not reached within %v: %v", window, health.LastError))
+	}
+	return problems
+}
+
+// healthHandler answers 200 when the check finds no problems and 500 listing
+// them otherwise
+func healthHandler(check func(time.Time) []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		problems := check(time.Now())
+		if len(problems) > 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(strings.Join(problems, "\n") + "\n"))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	}
+}
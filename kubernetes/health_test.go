@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLivenessProblems(t *testing.T) {
+	lbc := newTestLoadBalancerController()
+	lbc.ingressQueue = newTaskQueue(func(string) error { return nil })
+	lbc.informerHeartbeats = map[string]*heartbeat{"ingress": {}, "service": {}}
+	now := time.Now()
+
+	if problems := lbc.livenessProblems(now); len(problems) != 0 {
+		t.Errorf("expected informers that have not listed yet to be left to the readiness check, got %v", problems)
+	}
+
+	lbc.informerHeartbeats["ingress"].last = now.Add(-time.Minute)
+	lbc.informerHeartbeats["service"].last = now.Add(-informerStallTimeout - time.Minute)
+	problems := lbc.livenessProblems(now)
+	if len(problems) != 1 {
+		t.Errorf("expected the stalled service informer to be reported, got %v", problems)
+	}
+
+	lbc.informerHeartbeats["service"].last = now
+	lbc.ingressQueue.setBusySince(now.Add(-syncStallTimeout - time.Minute))
+	if problems := lbc.livenessProblems(now); len(problems) != 1 {
+		t.Errorf("expected the stuck sync to be reported, got %v", problems)
+	}
+	lbc.ingressQueue.setBusySince(time.Time{})
+	if problems := lbc.livenessProblems(now); len(problems) != 0 {
+		t.Errorf("expected an idle worker to be healthy, got %v", problems)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jargoonpard/appGatewaySample/kubernetes/logging"
+
+	"k8s.io/kubernetes/pkg/client/restclient"
+	"k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/client/unversioned/clientcmd"
+)
+
+// the oldest API server serving the extensions/v1beta1 ingresses the
+// controller watches
+const (
+	minimumServerMajor = 1
+	minimumServerMinor = 2
+)
+
+// kubeClientConfig returns the configuration of the first source available:
+// the kubeconfig file, then the API server host, then the service account of
+// the pod. The flags are explicit so they take precedence over the pod, and
+// the host overrides the server of the kubeconfig file when both are set. The
+// description names the source for the logs.
+func kubeClientConfig(inClusterConfig func() (*restclient.Config, error), kubeconfig, apiserverHost string) (*restclient.Config, string, error) {
+	if kubeconfig != "" {
+		config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+		if err != nil {
+			return nil, "", fmt.Errorf("loading the kubeconfig file %v: %v", kubeconfig, err)
+		}
+		// the overrides of clientcmd keep the server of the current context
+		if apiserverHost != "" {
+			config.Host = apiserverHost
+		}
+		return config, "the kubeconfig file " + kubeconfig, nil
+	}
+
+	if apiserverHost != "" {
+		return &restclient.Config{Host: apiserverHost}, "--apiserver-host", nil
+	}
+
+	config, err := inClusterConfig()
+	if err != nil {
+		return nil, "", fmt.Errorf("no Kubernetes API server configured, neither --kubeconfig nor --apiserver-host is set and the controller is not running in a pod (%v)", err)
+	}
+	return config, "the in-cluster service account", nil
+}
+
+// newKubeClient connects to the Kubernetes API server of the first source
+// available, see kubeClientConfig
+func newKubeClient(kubeconfig, apiserverHost string) (*unversioned.Client, error) {
+	config, source, err := kubeClientConfig(restclient.InClusterConfig, kubeconfig, apiserverHost)
+	if err != nil {
+		return nil, err
+	}
+	return connectKubeClient(config, source)
+}
+
+// connectKubeClient checks the API server is reachable and recent enough
+// before any informer starts
+func connectKubeClient(config *restclient.Config, source string) (*unversioned.Client, error) {
+	kubeClient, err := unversioned.New(config)
+	if err != nil {
+		return nil, fmt.Errorf("creating the Kubernetes client for %v from %v: %v", config.Host, source, err)
+	}
+
+	serverVersion, err := kubeClient.ServerVersion()
+	if err != nil {
+		return nil, fmt.Errorf("reaching the Kubernetes API server at %v configured by %v: %v", config.Host, source, err)
+	}
+	if err := checkServerVersion(serverVersion.Major, serverVersion.Minor); err != nil {
+		return nil, fmt.Errorf("the Kubernetes API server at %v: %v", config.Host, err)
+	}
+
+	logging.Infof("Connected to the Kubernetes API server %v at %v configured by %v", serverVersion.GitVersion, config.Host, source)
+	return kubeClient, nil
+}
+
+// checkServerVersion fails for servers older than the minimum version
+func checkServerVersion(majorVersion, minorVersion string) error {
+	major, majorErr := strconv.Atoi(majorVersion)
+	// providers append a + to the minor version of patched releases
+	minor, minorErr := strconv.Atoi(strings.TrimSuffix(minorVersion, "+"))
+	if majorErr != nil || minorErr != nil {
+		return fmt.Errorf("cannot parse the server version %q.%q", majorVersion, minorVersion)
+	}
+
+	if major < minimumServerMajor || (major == minimumServerMajor && minor < minimumServerMinor) {
+		return fmt.Errorf("server version %v.%v is not supported, the controller requires %v.%v or newer", major, minor, minimumServerMajor, minimumServerMinor)
+	}
+	return nil
+}
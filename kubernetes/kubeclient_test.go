@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/client/restclient"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: https://kubeconfig.example.com
+contexts:
+- name: test
+  context:
+    cluster: test
+current-context: test
+`
+
+func TestKubeClientConfigPrecedence(t *testing.T) {
+	file, err := ioutil.TempFile("", "kubeconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(testKubeconfig)
+	file.Close()
+
+	inCluster := func() (*restclient.Config, error) { return &restclient.Config{Host: "https://10.0.0.1:443"}, nil }
+	notInCluster := func() (*restclient.Config, error) { return nil, errors.New("KUBERNETES_SERVICE_HOST must be defined") }
+
+	testCases := []struct {
+		name          string
+		inCluster     func() (*restclient.Config, error)
+		kubeconfig    string
+		apiserverHost string
+		expectedHost  string
+	}{
+		{"in-cluster", inCluster, "", "", "https://10.0.0.1:443"},
+		{"kubeconfig in a pod", inCluster, file.Name(), "", "https://kubeconfig.example.com"},
+		{"host in a pod", inCluster, "", "http://localhost:8001", "http://localhost:8001"},
+		{"kubeconfig", notInCluster, file.Name(), "", "https://kubeconfig.example.com"},
+		{"kubeconfig with host", notInCluster, file.Name(), "http://localhost:8001", "http://localhost:8001"},
+		{"host", notInCluster, "", "http://localhost:8001", "http://localhost:8001"},
+	}
+	for _, testCase := range testCases {
+		config, _, err := kubeClientConfig(testCase.inCluster, testCase.kubeconfig, testCase.apiserverHost)
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", testCase.name, err)
+			continue
+		}
+		if config.Host != testCase.expectedHost {
+			t.Errorf("%v: expected %v, got %v", testCase.name, testCase.expectedHost, config.Host)
+		}
+	}
+
+	_, _, err = kubeClientConfig(notInCluster, "", "")
+	if err == nil || !strings.Contains(err.Error(), "--apiserver-host") {
+		t.Errorf("expected the missing configuration to name the flags, got %v", err)
+	}
+	_, _, err = kubeClientConfig(notInCluster, file.Name()+".missing", "")
+	if err == nil || !strings.Contains(err.Error(), "kubeconfig") {
+		t.Errorf("expected the missing kubeconfig file to be reported, got %v", err)
+	}
+}
+
+func TestConnectKubeClientChecksTheServerVersion(t *testing.T) {
+	minor := "3"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/version" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"major": "1", "minor": %q, "gitVersion": "v1.%v.0"}`, minor, minor)
+	}))
+	defer server.Close()
+
+	if _, err := connectKubeClient(&restclient.Config{Host: server.URL}, "--apiserver-host"); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	minor = "1"
+	if _, err := connectKubeClient(&restclient.Config{Host: server.URL}, "--apiserver-host"); err == nil || !strings.Contains(err.Error(), "1.2 or newer") {
+		t.Errorf("expected the old server to be rejected, got %v", err)
+	}
+
+	server.Close()
+	if _, err := connectKubeClient(&restclient.Config{Host: server.URL}, "--apiserver-host"); err == nil || !strings.Contains(err.Error(), server.URL) {
+		t.Errorf("expected the unreachable server to be named, got %v", err)
+	}
+}
+
+func TestCheckServerVersion(t *testing.T) {
+	for _, supported := range [][2]string{{"1", "2"}, {"1", "5+"}, {"2", "0"}} {
+		if err := checkServerVersion(supported[0], supported[1]); err != nil {
+			t.Errorf("expected %v to be supported, got %v", supported, err)
+		}
+	}
+	for _, unsupported := range [][2]string{{"1", "1"}, {"0", "9"}, {"", ""}} {
+		if err := checkServerVersion(unsupported[0], unsupported[1]); err == nil {
+			t.Errorf("expected %v to be rejected", unsupported)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/jargoonpard/appGatewaySample/kubernetes/logging"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+)
+
+// leaderAnnotationKey holds the lease on the lock endpoints, it is the key the
+// Kubernetes components use for their own leader election
+const leaderAnnotationKey = "control-plane.alpha.kubernetes.io/leader"
+
+// leaderElectionRecord is the lease stored on the lock endpoints
+type leaderElectionRecord struct {
+	HolderIdentity       string           `json:"holderIdentity"`
+	LeaseDurationSeconds int              `json:"leaseDurationSeconds"`
+	AcquireTime          unversioned.Time `json:"acquireTime"`
+	RenewTime            unversioned.Time `json:"renewTime"`
+	LeaderTransitions    int              `json:"leaderTransitions"`
+}
+
+// endpointsLock is the part of the endpoints client the election uses
+type endpointsLock interface {
+	Get(name string) (*api.Endpoints, error)
+	Create(endpoints *api.Endpoints) (*api.Endpoints, error)
+	Update(endpoints *api.Endpoints) (*api.Endpoints, error)
+}
+
+// leaderStatus describes the election as seen by this replica
+type leaderStatus struct {
+	Enabled       bool      `json:"enabled"`
+	Identity      string    `json:"identity,omitempty"`
+	Leader        string    `json:"leader,omitempty"`
+	IsLeader      bool      `json:"isLeader"`
+	LeaseDuration string    `json:"leaseDuration,omitempty"`
+	RenewTime     time.Time `json:"renewTime"`
+}
+
+// leaderElector holds a lease on an endpoints object so that only one replica
+// writes the gateways. Standbys retry every retryPeriod and take over once the
+// lease of the leader was not renewed for leaseDuration.
+type leaderElector struct {
+	endpoints endpointsLock
+	namespace string
+	name      string
+	identity  string
+
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
+
+	// observedTime is when the record last changed according to the local
+	// clock, clocks of the replicas are never compared
+	lock           sync.Mutex
+	leading        bool
+	observedRecord leaderElectionRecord
+	observedTime   time.Time
+
+	now func() time.Time
+}
+
+func newLeaderElector(endpoints endpointsLock, namespace, name, identity string, leaseDuration, renewDeadline, retryPeriod time.Duration) (*leaderElector, error) {
+	if identity == "" {
+		return nil, fmt.Errorf("leader election requires an identity")
+	}
+	if leaseDuration <= renewDeadline {
+		return nil, fmt.Errorf("the lease duration %v must be longer than the renew deadline %v", leaseDuration, renewDeadline)
+	}
+	if renewDeadline <= retryPeriod {
+		return nil, fmt.Errorf("the renew deadline %v must be longer than the retry period %v", renewDeadline, retryPeriod)
+	}
+
+	return &leaderElector{
+		endpoints:     endpoints,
+		namespace:     namespace,
+		name:          name,
+		identity:      identity,
+		leaseDuration: leaseDuration,
+		renewDeadline: renewDeadline,
+		retryPeriod:   retryPeriod,
+		now:           time.Now,
+	}, nil
+}
+
+// run blocks until the lease is acquired, calls onStartedLeading and keeps
+// renewing the lease. onStoppedLeading is called once a renewal did not
+// succeed within renewDeadline.
+func (le *leaderElector) run(onStartedLeading, onStoppedLeading func(), stopCh <-chan struct{}) {
+	logging.Infof("Waiting to acquire the leader lease %v/%v as %v", le.namespace, le.name, le.identity)
+	for !le.tryAcquireOrRenew() {
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(le.retryPeriod):
+		}
+	}
+	logging.Infof("Acquired the leader lease %v/%v", le.namespace, le.name)
+	le.setLeading(true)
+	go onStartedLeading()
+
+	renewed := le.now()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-time.After(le.retryPeriod):
+		}
+		if le.tryAcquireOrRenew() {
+			renewed = le.now()
+			continue
+		}
+		if le.now().Sub(renewed) > le.renewDeadline {
+			logging.Errorf("Failed to renew the leader lease %v/%v within %v", le.namespace, le.name, le.renewDeadline)
+			le.setLeading(false)
+			onStoppedLeading()
+			return
+		}
+	}
+}
+
+// tryAcquireOrRenew takes the lease if it is free or expired, or renews it if
+// this replica holds it already
+func (le *leaderElector) tryAcquireOrRenew() bool {
+	now := unversioned.NewTime(le.now())
+	record := leaderElectionRecord{
+		HolderIdentity:       le.identity,
+		LeaseDurationSeconds: int(le.leaseDuration / time.Second),
+		AcquireTime:          now,
+		RenewTime:            now,
+	}
+
+	endpoints, err := le.endpoints.Get(le.name)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			logging.Errorf("Error retrieving the leader lease %v/%v: %v", le.namespace, le.name, err)
+			return false
+		}
+		value, err := json.Marshal(record)
+		if err != nil {
+			logging.Errorf("Error encoding the leader lease: %v", err)
+			return false
+		}
+		_, err = le.endpoints.Create(&api.Endpoints{
+			ObjectMeta: api.ObjectMeta{
+				Namespace:   le.namespace,
+				Name:        le.name,
+				Annotations: map[string]string{leaderAnnotationKey: string(value)},
+			},
+		})
+		if err != nil {
+			logging.Errorf("Error creating the leader lease %v/%v: %v", le.namespace, le.name, err)
+			return false
+		}
+		le.observe(record)
+		return true
+	}
+
+	current := leaderElectionRecord{}
+	if value, ok := endpoints.Annotations[leaderAnnotationKey]; ok {
+		if err := json.Unmarshal([]byte(value), &current); err != nil {
+			logging.Errorf("Error decoding the leader lease %v/%v: %v", le.namespace, le.name, err)
+			return false
+		}
+	}
+	observedTime := le.observe(current)
+
+	if current.HolderIdentity != "" && current.HolderIdentity != le.identity && le.now().Before(observedTime.Add(le.leaseDuration)) {
+		logging.V(4).Infof("The leader lease is held by %v and has not expired yet", current.HolderIdentity)
+		return false
+	}
+
+	if current.HolderIdentity == le.identity {
+		record.AcquireTime = current.AcquireTime
+		record.LeaderTransitions = current.LeaderTransitions
+	} else {
+		record.LeaderTransitions = current.LeaderTransitions + 1
+	}
+
+	value, err := json.Marshal(record)
+	if err != nil {
+		logging.Errorf("Error encoding the leader lease: %v", err)
+		return false
+	}
+	annotations := map[string]string{}
+	for key, value := range endpoints.Annotations {
+		annotations[key] = value
+	}
+	annotations[leaderAnnotationKey] = string(value)
+	endpoints.Annotations = annotations
+
+	// the update carries the resource version that was read, a replica that
+	// wrote in the meantime makes it fail with a conflict
+	if _, err := le.endpoints.Update(endpoints); err != nil {
+		logging.Errorf("Error updating the leader lease %v/%v: %v", le.namespace, le.name, err)
+		return false
+	}
+	le.observe(record)
+	return true
+}
+
+// observe records a changed lease and returns when it was last seen changing
+func (le *leaderElector) observe(record leaderElectionRecord) time.Time {
+	le.lock.Lock()
+	defer le.lock.Unlock()
+
+	if le.observedTime.IsZero() || !reflect.DeepEqual(le.observedRecord, record) {
+		le.observedRecord = record
+		le.observedTime = le.now()
+	}
+	return le.observedTime
+}
+
+func (le *leaderElector) setLeading(leading bool) {
+	le.lock.Lock()
+	defer le.lock.Unlock()
+	le.leading = leading
+}
+
+// isLeader determines if this replica holds the lease
+func (le *leaderElector) isLeader() bool {
+	le.lock.Lock()
+	defer le.lock.Unlock()
+	return le.leading
+}
+
+func (le *leaderElector) status() leaderStatus {
+	le.lock.Lock()
+	defer le.lock.Unlock()
+
+	return leaderStatus{
+		Enabled:       true,
+		Identity:      le.identity,
+		Leader:        le.observedRecord.HolderIdentity,
+		IsLeader:      le.leading,
+		LeaseDuration: le.leaseDuration.String(),
+		RenewTime:     le.observedRecord.RenewTime.Time,
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/errors"
+)
+
+// fakeEndpointsLock stores a single endpoints object and rejects updates of
+// stale resource versions like the API server
+type fakeEndpointsLock struct {
+	endpoints *api.Endpoints
+	version   int
+}
+
+func (lock *fakeEndpointsLock) Get(name string) (*api.Endpoints, error) {
+	if lock.endpoints == nil {
+		return nil, errors.NewNotFound(api.Resource("endpoints"), name)
+	}
+	endpoints := *lock.endpoints
+	return &endpoints, nil
+}
+
+func (lock *fakeEndpointsLock) Create(endpoints *api.Endpoints) (*api.Endpoints, error) {
+	if lock.endpoints != nil {
+		return nil, errors.NewAlreadyExists(api.Resource("endpoints"), endpoints.Name)
+	}
+	return lock.Update(endpoints)
+}
+
+func (lock *fakeEndpointsLock) Update(endpoints *api.Endpoints) (*api.Endpoints, error) {
+	if lock.endpoints != nil && endpoints.ResourceVersion != lock.endpoints.ResourceVersion {
+		return nil, errors.NewConflict(api.Resource("endpoints"), endpoints.Name, nil)
+	}
+	lock.version++
+	stored := *endpoints
+	stored.ResourceVersion = strconv.Itoa(lock.version)
+	lock.endpoints = &stored
+	return &stored, nil
+}
+
+func newTestLeaderElector(t *testing.T, lock *fakeEndpointsLock, identity string, clock *time.Time) *leaderElector {
+	le, err := newLeaderElector(lock, "default", "leader", identity, 15*time.Second, 10*time.Second, 2*time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	le.now = func() time.Time { return *clock }
+	return le
+}
+
+func TestLeaderElection(t *testing.T) {
+	clock := time.Unix(1500000000, 0)
+	lock := &fakeEndpointsLock{}
+	first := newTestLeaderElector(t, lock, "first", &clock)
+	second := newTestLeaderElector(t, lock, "second", &clock)
+
+	if !first.tryAcquireOrRenew() {
+		t.Fatalf("expected the first replica to acquire the free lease")
+	}
+	if second.tryAcquireOrRenew() {
+		t.Errorf("expected the standby not to take a held lease")
+	}
+
+	clock = clock.Add(10 * time.Second)
+	if !first.tryAcquireOrRenew() {
+		t.Errorf("expected the leader to renew its lease")
+	}
+	clock = clock.Add(10 * time.Second)
+	if second.tryAcquireOrRenew() {
+		t.Errorf("expected the standby to see the renewal and keep waiting")
+	}
+	if status := second.status(); status.Leader != "first" || status.IsLeader {
+		t.Errorf("expected the standby to report the leader, got %+v", status)
+	}
+
+	// the leader stops renewing
+	clock = clock.Add(16 * time.Second)
+	if !second.tryAcquireOrRenew() {
+		t.Fatalf("expected the standby to take over the expired lease")
+	}
+	if first.tryAcquireOrRenew() {
+		t.Errorf("expected the former leader not to take the lease back")
+	}
+	if record := second.observedRecord; record.HolderIdentity != "second" || record.LeaderTransitions != 1 {
+		t.Errorf("expected the takeover to be recorded, got %+v", record)
+	}
+}
+
+func TestLeaderElectionConflict(t *testing.T) {
+	clock := time.Unix(1500000000, 0)
+	lock := &fakeEndpointsLock{}
+	first := newTestLeaderElector(t, lock, "first", &clock)
+	if !first.tryAcquireOrRenew() {
+		t.Fatalf("expected the first replica to acquire the free lease")
+	}
+	clock = clock.Add(time.Minute)
+
+	// another replica writes between the read and the update of the standby
+	stale, _ := lock.Get("leader")
+	second := newTestLeaderElector(t, lock, "second", &clock)
+	second.endpoints = &staleEndpointsLock{fakeEndpointsLock: lock, stale: stale}
+	lock.Update(stale)
+
+	if second.tryAcquireOrRenew() {
+		t.Errorf("expected the update of a stale lease to lose")
+	}
+}
+
+// staleEndpointsLock returns an outdated copy of the endpoints
+type staleEndpointsLock struct {
+	*fakeEndpointsLock
+	stale *api.Endpoints
+}
+
+func (lock *staleEndpointsLock) Get(name string) (*api.Endpoints, error) {
+	endpoints := *lock.stale
+	return &endpoints, nil
+}
+
+func TestNewLeaderElectorValidatesDurations(t *testing.T) {
+	if _, err := newLeaderElector(&fakeEndpointsLock{}, "default", "leader", "first", 10*time.Second, 10*time.Second, 2*time.Second); err == nil {
+		t.Errorf("expected a lease no longer than the renew deadline to be rejected")
+	}
+	if _, err := newLeaderElector(&fakeEndpointsLock{}, "default", "leader", "", 15*time.Second, 10*time.Second, 2*time.Second); err == nil {
+		t.Errorf("expected an empty identity to be rejected")
+	}
+}
+
+// a standby never starts the sync worker, stopping it must not wait for one
+func TestStopStandby(t *testing.T) {
+	lbc := newTestLoadBalancerController()
+	lbc.ingressQueue = newTaskQueue(func(string) error { return nil })
+	lbc.stopCh = make(chan struct{})
+	var err error
+	lbc.elector, err = newLeaderElector(&fakeEndpointsLock{}, "default", "leader", "standby", 15*time.Second, 10*time.Second, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		lbc.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected a controller that is not leading to stop")
+	}
+}
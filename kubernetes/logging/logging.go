@@ -0,0 +1,51 @@
+// Package logging writes the log messages of the ingress controller through
+// glog with their credentials masked. Every message of the controller goes
+// through it, values whose secrets are only found by their field names are
+// still formatted with redact.Value.
+package logging
+
+import (
+	"github.com/golang/glog"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/redact"
+)
+
+// Infof logs an informational message
+func Infof(format string, args ...interface{}) {
+	glog.InfoDepth(1, redact.Sprintf(format, args...))
+}
+
+// Warningf logs a warning
+func Warningf(format string, args ...interface{}) {
+	glog.WarningDepth(1, redact.Sprintf(format, args...))
+}
+
+// Errorf logs an error
+func Errorf(format string, args ...interface{}) {
+	glog.ErrorDepth(1, redact.Sprintf(format, args...))
+}
+
+// Fatalf logs a message and exits
+func Fatalf(format string, args ...interface{}) {
+	glog.FatalDepth(1, redact.Sprintf(format, args...))
+}
+
+// Verbose logs only when the verbosity of the process is high enough
+type Verbose bool
+
+// V tells whether messages of the given level are logged. glog sees this
+// package as the caller, -vmodule has to name logging.go.
+func V(level glog.Level) Verbose {
+	return Verbose(glog.V(level))
+}
+
+// Infof logs an informational message when the level is enabled
+func (v Verbose) Infof(format string, args ...interface{}) {
+	if v {
+		glog.InfoDepth(1, redact.Sprintf(format, args...))
+	}
+}
+
+// Flush writes the buffered messages out
+func Flush() {
+	glog.Flush()
+}
@@ -1,20 +1,25 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
-	"github.com/golang/glog"
+	"github.com/Azure/azure-sdk-for-go/arm/network"
+	"github.com/Azure/go-autorest/autorest/azure"
 	"github.com/jargoonpard/appGatewaySample/kubernetes/azurecontroller"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/credentials"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/logging"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/redact"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/pflag"
 
 	"k8s.io/kubernetes/pkg/api"
-	"k8s.io/kubernetes/pkg/client/unversioned"
-	kubectl_util "k8s.io/kubernetes/pkg/kubectl/cmd/util"
 )
 
 var (
@@ -23,17 +28,84 @@ var (
 	resyncPeriod = flags.Duration("sync-period", 30*time.Second,
 		`Relist and confirm cloud resources this often.`)
 
+	kubeconfig = flags.String("kubeconfig", "",
+		`Path of a kubeconfig file. It takes precedence over the service account of the pod.`)
+
+	apiserverHost = flags.String("apiserver-host", "",
+		`Address of the Kubernetes API server, e.g. http://localhost:8001 for kubectl proxy. It overrides the server of --kubeconfig and takes precedence over the service account of the pod, which is used when neither flag is set.`)
+
 	watchNamespace = flags.String("watch-namespace", api.NamespaceAll,
 		`Namespace to watch for Ingress. Default is to watch all namespaces`)
 
+	excludeNodeLabels = flags.StringSlice("exclude-node-labels", []string{"alpha.service-controller.kubernetes.io/exclude-balancer"},
+		`Nodes carrying any of these labels never receive traffic from node port based backend pools.`)
+
 	tenantID       = flags.String("tenantID", "", "Azure tenantId")
 	subscriptionID = flags.String("subscriptionID", "", "Azure subscription Id")
 	clientID       = flags.String("clientID", "", "Azure client id")
 	clientSecret   = flags.String("clientSecret", "", "Azure client secret key")
 	region         = flags.String("region", "", "Azure region that hosts the Kubernetes cluster (e.g. westus, southcentralasia, etc.)")
 	resourceGroup  = flags.String("resourceGroup", "", "Azure resource group that hosts the Kubernetes cluster")
+
+	clientCertificatePath = flags.String("clientCertificatePath", "",
+		`PEM or PFX file authenticating the Azure client instead of the client secret, e.g. mounted from a secret. The PFX password is read from AZURE_CLIENT_CERTIFICATE_PASSWORD or aadClientCertPassword.`)
+
+	useManagedIdentity = flags.Bool("useManagedIdentity", false,
+		`Authenticate with the managed identity of the VM instead of a service principal, clientID selects a user assigned identity.`)
+
+	identityEndpoint = flags.String("identity-endpoint", azurecontroller.DefaultIdentityEndpoint,
+		`Token endpoint of the managed identity.`)
+
+	cloud = flags.String("cloud", "",
+		`Azure cloud hosting the cluster: AzurePublicCloud, AzureChinaCloud, AzureUSGovernmentCloud or AzureGermanCloud. Defaults to AzurePublicCloud.`)
+
+	cloudEnvironmentFile = flags.String("cloudEnvironmentFile", "",
+		`JSON file holding the endpoints of a custom Azure cloud such as Azure Stack, it takes precedence over --cloud.`)
+
+	azureConfigFile = flags.String("azure-config-file", "",
+		`Path of an azure.json file as used by the Kubernetes Azure cloud provider, e.g. mounted from a secret. Flags and AZURE_* environment variables override its values.`)
+
+	azureConfigReloadPeriod = flags.Duration("azure-config-reload-period", 30*time.Second,
+		`Check the Azure config file for rotated credentials this often.`)
+
+	gatewaySubnetID = flags.String("gatewaySubnetID", "", "Azure resource ID of the subnet new application gateways are deployed into")
+	gatewaySku      = flags.String("gatewaySku", string(network.StandardSmall), "SKU of new application gateways (Standard_Small, Standard_Medium or Standard_Large)")
+	gatewayCapacity = flags.Int32("gatewayCapacity", 2, "Number of instances of new application gateways")
+	clusterID       = flags.String("clusterID", "", "Identifier tagged on every Azure resource the controller creates, resources tagged with it are garbage collected once no ingress uses them")
+	gcPeriod        = flags.Duration("gcPeriod", 10*time.Minute, "Look for Azure resources no ingress uses any more this often")
+	gatewayPrefix   = flags.String("gatewayPrefix", "ingress", "Name prefix of the shared application gateways ingresses without a gateway-name annotation are placed on")
+
+	healthzPort = flags.Int("healthz-port", 10254,
+		`Port serving the health, readiness, leader election and metrics endpoints.`)
+
+	readinessWindow = flags.Duration("readiness-window", 5*time.Minute,
+		`The controller is ready while Azure answered within this window, it is checked every minute.`)
+
+	teardownTokenFile = flags.String("teardown-token-file", "",
+		`File holding the bearer token POST /delete-all-and-quit requires, e.g. mounted from a secret. The endpoint is disabled without it.`)
+
+	leaderElect = flags.Bool("leader-elect", false,
+		`Elect a leader among the replicas of the controller, only the leader writes the application gateways.`)
+
+	leaderElectionNamespace = flags.String("leader-election-namespace", api.NamespaceDefault,
+		`Namespace of the endpoints object holding the leader lease.`)
+
+	leaderElectionID = flags.String("leader-election-id", "azure-ingress-controller-leader",
+		`Name of the endpoints object holding the leader lease.`)
+
+	leaseDuration = flags.Duration("leader-elect-lease-duration", 15*time.Second,
+		`Time a standby waits after the last renewal of the leader before it takes over.`)
+
+	renewDeadline = flags.Duration("leader-elect-renew-deadline", 10*time.Second,
+		`Time the leader keeps retrying to renew its lease before it steps down.`)
+
+	retryPeriod = flags.Duration("leader-elect-retry-period", 2*time.Second,
+		`Time between attempts to acquire or renew the leader lease.`)
 )
 
+// version is set at build time with -ldflags "-X main.version=<version>"
+var version = "unknown"
+
 // podInfo contains runtime information about the pod
 type podInfo struct {
 	PodName      string
@@ -48,98 +120,166 @@ func main() {
 	//https://github.com/kubernetes/kubernetes/issues/17162
 	flag.CommandLine.Parse([]string{})
 
-	kubeClient, err := newKubeClient(flags)
+	if !gatewayNamePattern.MatchString(*gatewayPrefix) {
+		logging.Fatalf("Invalid gatewayPrefix %q", *gatewayPrefix)
+	}
+
+	kubeClient, err := newKubeClient(*kubeconfig, *apiserverHost)
+	if err != nil {
+		logging.Fatalf("Failed to connect to Kubernetes: %v", err)
+	}
+
+	loader := azureConfigLoader()
+	azureConfig, err := loader.Load()
 	if err != nil {
-		glog.Fatalf("Failed to create kubeclient %v", err)
+		logging.Fatalf("Failed to load the Azure settings: %v", err)
 	}
 
-	getNodeIPAddresses(kubeClient)
+	environment := azure.Environment{}
+	err = azureConfig.CloudEnvironment(&environment, func(name string) (interface{}, error) { return azure.EnvironmentFromName(name) })
+	if err != nil {
+		logging.Fatalf("Failed to determine the Azure cloud: %v", err)
+	}
+	logging.Infof("Using the Azure cloud %v at %v", environment.Name, environment.ResourceManagerEndpoint)
 
-	servicePrincipalToken, err := newServicePrincipalToken(*tenantID, *clientID, *clientSecret)
+	authorizer, err := newAuthorizer(azureConfig, environment)
 	if err != nil {
-		glog.Fatalf("Failed to create Azure servicePrincipalToken %v", err)
+		logging.Fatalf("Failed to create the Azure authorizer: %v", redact.Value(err))
 	}
 
 	creds := azurecontroller.AzureCredentialInfo{
-		ResourceGroupName:     *resourceGroup,
-		Region:                *region,
-		SubscriptionID:        *subscriptionID,
-		ServicePrincipalToken: servicePrincipalToken,
+		ResourceGroupName: azureConfig.ResourceGroup,
+		Region:            azureConfig.Region,
+		SubscriptionID:    azureConfig.SubscriptionID,
+		Authorizer:        authorizer,
+		Environment:       environment,
 	}
 
-	lbc, err := newLoadBalancerController(kubeClient, *watchNamespace, *resyncPeriod, creds)
+	options := azurecontroller.ProvisioningOptions{
+		SubnetID: *gatewaySubnetID,
+		SkuName:  network.ApplicationGatewaySkuName(*gatewaySku),
+		Capacity: *gatewayCapacity,
+
+		ClusterID:         *clusterID,
+		ControllerVersion: version,
+	}
+
+	lbc, err := newLoadBalancerController(kubeClient, *watchNamespace, *resyncPeriod, *gcPeriod, *excludeNodeLabels, *gatewayPrefix, creds, options)
 	if err != nil {
-		glog.Fatalf("Failed to create loadBalancerController: %v", err)
+		logging.Fatalf("Failed to create loadBalancerController: %v", err)
 	}
 
+	if *leaderElect {
+		identity, err := os.Hostname()
+		if err != nil {
+			logging.Fatalf("Failed to determine the leader election identity: %v", err)
+		}
+		lbc.elector, err = newLeaderElector(kubeClient.Endpoints(*leaderElectionNamespace), *leaderElectionNamespace, *leaderElectionID, identity, *leaseDuration, *renewDeadline, *retryPeriod)
+		if err != nil {
+			logging.Fatalf("Failed to set up leader election: %v", err)
+		}
+	}
+
+	// changes are reported against the settings in effect, not those of the
+	// start, so each is only reported once
+	appliedConfig := azureConfig
+	loader.Watch(*azureConfigReloadPeriod, lbc.stopCh, func(reloaded credentials.Config) {
+		appliedConfig = reloadCredentials(lbc, appliedConfig, reloaded)
+	}, func(err error) {
+		logging.Errorf("Ignoring the changed Azure config file: %v", redact.Value(err))
+	})
+
+	registerQueueMetrics(lbc.ingressQueue)
 	go registerHTTPHandlers(lbc)
 	go handleSigterm(lbc)
 
 	lbc.Run()
 
+	// nothing is left to control after a teardown, otherwise the pod is
+	// being deleted
 	for {
-		glog.Infof("Handled quit, awaiting pod deletion.")
-		time.Sleep(30 * time.Second)
+		logging.Infof("Handled quit, awaiting pod deletion.")
+		select {
+		case <-lbc.tornDown:
+			logging.Infof("Teardown complete, exiting")
+			logging.Flush()
+			return
+		case <-time.After(30 * time.Second):
+		}
 	}
 }
 
-func newKubeClient(flags *pflag.FlagSet) (*unversioned.Client, error) {
-	clientConfig := kubectl_util.DefaultClientConfig(flags)
+// azureConfigLoader reads the Azure settings from the flags, the environment
+// and the Azure config file in this order of precedence
+func azureConfigLoader() credentials.Loader {
+	return credentials.Loader{
+		Flags: credentials.Config{
+			TenantID:       *tenantID,
+			SubscriptionID: *subscriptionID,
+			ClientID:       *clientID,
+			ClientSecret:   *clientSecret,
+			Region:         *region,
+			ResourceGroup:  *resourceGroup,
 
-	config, err := clientConfig.ClientConfig()
-	if err != nil {
-		glog.Fatalf("error getting the client configuration: %v", err)
+			ClientCertificatePath: *clientCertificatePath,
+			UseManagedIdentity:    *useManagedIdentity,
+
+			Cloud:                *cloud,
+			CloudEnvironmentFile: *cloudEnvironmentFile,
+		},
+		File: *azureConfigFile,
 	}
+}
 
-	config.Host = "http://localhost:8001"
+// reloadCredentials switches the controller to rotated credentials and
+// returns the settings in effect afterwards. The subscription, region,
+// resource group and cloud only change with a restart.
+func reloadCredentials(lbc *loadBalancerController, applied, reloaded credentials.Config) credentials.Config {
+	if reloaded.SubscriptionID != applied.SubscriptionID || reloaded.Region != applied.Region || reloaded.ResourceGroup != applied.ResourceGroup {
+		logging.Warningf("The Azure subscription, region or resource group changed, restart the controller to apply them")
+	}
+	if reloaded.Cloud != applied.Cloud || reloaded.CloudEnvironmentFile != applied.CloudEnvironmentFile {
+		logging.Warningf("The Azure cloud changed, restart the controller to apply it")
+	}
 
-	kubeclient, err := unversioned.New(config)
+	authorizer, err := newAuthorizer(reloaded, lbc.azureGWClient.Environment)
 	if err != nil {
-		glog.Fatalf("Failed to create Kubernetes client: %v", err)
+		logging.Errorf("Failed to create an authorizer from the reloaded credentials: %v", redact.Value(err))
+		return applied
 	}
-
-	return kubeclient, err
+	logging.Infof("Reloaded the Azure credentials from %v", *azureConfigFile)
+	lbc.azureGWClient.UpdateAuthorizer(authorizer)
+	return reloaded
 }
 
 func handleSigterm(lbc *loadBalancerController) {
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, syscall.SIGTERM)
 	<-signalChan
-	glog.Infof("Received SIGTERM, shutting down")
+	logging.Infof("Received SIGTERM, shutting down")
 
 	exitCode := 0
 	if err := lbc.Stop(); err != nil {
-		glog.Infof("Error during shutdown: %v", err)
+		logging.Infof("Error during shutdown: %v", err)
 		exitCode = 1
 	}
 
-	glog.Infof("Exiting with %v", exitCode)
+	logging.Infof("Exiting with %v", exitCode)
 	os.Exit(exitCode)
 }
 
 func registerHTTPHandlers(lbc *loadBalancerController) {
-	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		//TODO: add in determination of what defines healthy
-		w.WriteHeader(200)
-		w.Write([]byte("ok"))
-	})
-	http.HandleFunc("/delete-all-and-quit", func(w http.ResponseWriter, r *http.Request) {
-		lbc.Stop()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", healthHandler(lbc.livenessProblems))
+	mux.HandleFunc("/readyz", healthHandler(func(now time.Time) []string {
+		return lbc.readinessProblems(now, *readinessWindow)
+	}))
+	mux.HandleFunc("/leader", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(lbc.leaderStatus())
 	})
+	mux.Handle("/metrics", prometheus.Handler())
+	mux.HandleFunc("/delete-all-and-quit", teardownHandler(lbc, *teardownTokenFile))
 
-	//glog.Fatal(http.ListenAndServe(fmt.Sprintf(":%v", *healthzPort), nil))
-}
-
-func getNodeIPAddresses(kubeClient *unversioned.Client) []string {
-	nodelist, err := kubeClient.Nodes().List(api.ListOptions{})
-
-	if err != nil {
-		glog.Errorf("Error getting nodelist %v", err)
-	} else {
-		for index := 0; index < len(nodelist.Items); index++ {
-			glog.Info(nodelist.Items[index])
-		}
-	}
-
-	return nil
+	logging.Fatalf("%v", http.ListenAndServe(fmt.Sprintf(":%v", *healthzPort), mux))
 }
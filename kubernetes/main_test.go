@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/azurecontroller"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/credentials"
+)
+
+func TestReloadCredentialsReturnsTheAppliedConfig(t *testing.T) {
+	lbc := newTestLoadBalancerController()
+	lbc.azureGWClient = azurecontroller.NewAzureGatewayClientController(azurecontroller.AzureCredentialInfo{Authorizer: autorest.NullAuthorizer{}}, azurecontroller.ProvisioningOptions{}, nil)
+	started := credentials.Config{TenantID: "tenant", ClientID: "client", ClientSecret: "first", SubscriptionID: "subscription"}
+
+	rotated := started
+	rotated.ClientSecret = "second"
+	rotated.SubscriptionID = "other"
+	if applied := reloadCredentials(lbc, started, rotated); applied != rotated {
+		t.Errorf("expected the rotated credentials to be applied, got %+v", applied)
+	}
+
+	// the next rotation is compared against the settings in effect
+	broken := rotated
+	broken.ClientSecret = ""
+	if applied := reloadCredentials(lbc, rotated, broken); applied != rotated {
+		t.Errorf("expected the credentials in effect to be kept, got %+v", applied)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "azure_ingress"
+
+var (
+	queueRetries = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "queue_retries_total",
+		Help:      "Ingress syncs that failed and were requeued.",
+	})
+
+	syncDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "ingress_sync_duration_seconds",
+		Help:      "Duration of the syncs of every ingress by result.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 15),
+	}, []string{"ingress", "result"})
+
+	// forgottenSyncs holds the ingresses whose series were deleted by the
+	// sync still running, which is not recorded either
+	forgottenSyncsLock sync.Mutex
+	forgottenSyncs     = map[string]bool{}
+)
+
+func init() {
+	prometheus.MustRegister(queueRetries, syncDuration)
+}
+
+// registerQueueMetrics exposes the depth of the queue, there is a single
+// queue per process
+func registerQueueMetrics(queue *taskQueue) {
+	prometheus.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "queue_depth",
+		Help:      "Ingresses waiting to be synced.",
+	}, func() float64 { return float64(queue.workQueue().Len()) }))
+}
+
+// observeSync records the sync of an ingress
+func observeSync(key string, duration time.Duration, err error) {
+	forgottenSyncsLock.Lock()
+	forgotten := forgottenSyncs[key]
+	delete(forgottenSyncs, key)
+	forgottenSyncsLock.Unlock()
+	if forgotten {
+		return
+	}
+
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	syncDuration.WithLabelValues(key, result).Observe(duration.Seconds())
+}
+
+// forgetSync deletes the series of an ingress that is no longer synced, the
+// ingress label would otherwise keep every ingress ever created
+func forgetSync(key string) {
+	forgottenSyncsLock.Lock()
+	defer forgottenSyncsLock.Unlock()
+	forgottenSyncs[key] = true
+	for _, result := range []string{"success", "error"} {
+		syncDuration.DeleteLabelValues(key, result)
+	}
+}
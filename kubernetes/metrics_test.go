@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// syncSeries counts the sync duration series of an ingress
+func syncSeries(t *testing.T, key string) int {
+	metrics := make(chan prometheus.Metric, 100)
+	syncDuration.Collect(metrics)
+	close(metrics)
+
+	series := 0
+	for metric := range metrics {
+		// the labels of a series are only exposed by writing it out
+		written := &dto.Metric{}
+		if err := metric.Write(written); err != nil {
+			t.Fatal(err)
+		}
+		for _, label := range written.GetLabel() {
+			if label.GetName() == "ingress" && label.GetValue() == key {
+				series++
+			}
+		}
+	}
+	return series
+}
+
+func TestForgetSync(t *testing.T) {
+	observeSync("default/forgotten", time.Second, nil)
+	if got := syncSeries(t, "default/forgotten"); got != 1 {
+		t.Fatalf("expected the sync to be recorded, got %v series", got)
+	}
+
+	// the teardown forgets the ingress before its own sync is observed
+	forgetSync("default/forgotten")
+	observeSync("default/forgotten", time.Second, nil)
+	if got := syncSeries(t, "default/forgotten"); got != 0 {
+		t.Errorf("expected the series of the removed ingress to be deleted, got %v", got)
+	}
+
+	// an ingress recreated under the same name is recorded again
+	observeSync("default/forgotten", time.Second, nil)
+	if got := syncSeries(t, "default/forgotten"); got != 1 {
+		t.Errorf("expected the recreated ingress to be recorded, got %v series", got)
+	}
+}
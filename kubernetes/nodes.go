@@ -0,0 +1,128 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/jargoonpard/appGatewaySample/kubernetes/logging"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/redact"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/client/cache"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/runtime"
+	"k8s.io/kubernetes/pkg/watch"
+)
+
+// nodeInternalIP returns the address the gateway uses to reach a node.
+func nodeInternalIP(node *api.Node) string {
+	for _, address := range node.Status.Addresses {
+		if address.Type == api.NodeInternalIP {
+			return address.Address
+		}
+	}
+	return ""
+}
+
+// isNodeReady determines if the kubelet of the node reports it as ready.
+func isNodeReady(node *api.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == api.NodeReady {
+			return condition.Status == api.ConditionTrue
+		}
+	}
+	return false
+}
+
+// nodeBackendAddress returns the address of a node if it may receive traffic
+// from the gateway: it must be ready, schedulable, carry none of the excluded
+// labels and have an internal IP.
+func nodeBackendAddress(node *api.Node, excludedLabels []string) (string, bool) {
+	if node.Spec.Unschedulable || !isNodeReady(node) {
+		return "", false
+	}
+	for _, label := range excludedLabels {
+		if _, ok := node.Labels[label]; ok {
+			return "", false
+		}
+	}
+
+	address := nodeInternalIP(node)
+	return address, address != ""
+}
+
+// nodeAddresses returns the addresses of the nodes serving node ports.
+func (lbc *loadBalancerController) nodeAddresses() []string {
+	addresses := []string{}
+	for _, obj := range lbc.nodeStore.List() {
+		if address, ok := nodeBackendAddress(obj.(*api.Node), lbc.excludedNodeLabels); ok {
+			addresses = append(addresses, address)
+		}
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+// enqueueNodeIngresses queues every Azure ingress whose backend pools are made
+// of node addresses.
+func (lbc *loadBalancerController) enqueueNodeIngresses() {
+	for _, obj := range lbc.ingressStore.List() {
+		ingress := obj.(*extensions.Ingress)
+		if isAzureIngress(ingress) && ingressAnnotations(ingress.Annotations).backendAddressType() == nodeAddressType {
+			lbc.ingressQueue.enqueue(ingress)
+		}
+	}
+}
+
+// nodeEventHandler requeues the node based ingresses whenever a node joins,
+// leaves or its eligibility to receive traffic changes.
+func (lbc *loadBalancerController) nodeEventHandler() cache.ResourceEventHandlerFuncs {
+	changed := func(node *api.Node, reason string) {
+		if _, ok := nodeBackendAddress(node, lbc.excludedNodeLabels); ok {
+			logging.Infof("node %v %v, requeuing node based ingresses", node.Name, reason)
+			lbc.enqueueNodeIngresses()
+		}
+	}
+
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			changed(obj.(*api.Node), "joined")
+		},
+		UpdateFunc: func(old, cur interface{}) {
+			oldAddress, oldOk := nodeBackendAddress(old.(*api.Node), lbc.excludedNodeLabels)
+			curAddress, curOk := nodeBackendAddress(cur.(*api.Node), lbc.excludedNodeLabels)
+			if oldAddress != curAddress || oldOk != curOk {
+				logging.Infof("node %v changed eligibility, requeuing node based ingresses", cur.(*api.Node).Name)
+				lbc.enqueueNodeIngresses()
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			node, ok := obj.(*api.Node)
+			if !ok {
+				tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+				if !ok {
+					logging.Errorf("couldn't get object from tombstone %v", redact.Value(obj))
+					return
+				}
+				node, ok = tombstone.Obj.(*api.Node)
+				if !ok {
+					logging.Errorf("tombstone contained object that is not a node %v", redact.Value(obj))
+					return
+				}
+			}
+			changed(node, "left")
+		},
+	}
+}
+
+func nodeListFunc(kubeClient *client.Client) func(api.ListOptions) (runtime.Object, error) {
+	return func(opts api.ListOptions) (runtime.Object, error) {
+		return kubeClient.Nodes().List(opts)
+	}
+}
+
+func nodeWatchFunc(kubeClient *client.Client) func(options api.ListOptions) (watch.Interface, error) {
+	return func(options api.ListOptions) (watch.Interface, error) {
+		return kubeClient.Nodes().Watch(options)
+	}
+}
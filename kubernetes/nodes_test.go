@@ -0,0 +1,62 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/util/intstr"
+)
+
+func newTestNode(name, address string, ready bool) *api.Node {
+	status := api.ConditionFalse
+	if ready {
+		status = api.ConditionTrue
+	}
+	return &api.Node{
+		ObjectMeta: api.ObjectMeta{Name: name},
+		Status: api.NodeStatus{
+			Addresses: []api.NodeAddress{
+				{Type: api.NodeExternalIP, Address: "52.0.0.1"},
+				{Type: api.NodeInternalIP, Address: address},
+			},
+			Conditions: []api.NodeCondition{{Type: api.NodeReady, Status: status}},
+		},
+	}
+}
+
+func TestNodeAddressesOnlyIncludesEligibleNodes(t *testing.T) {
+	lbc := newTestLoadBalancerController()
+	lbc.excludedNodeLabels = []string{"exclude-from-gateway"}
+
+	unschedulable := newTestNode("cordoned", "10.240.0.7", true)
+	unschedulable.Spec.Unschedulable = true
+	excluded := newTestNode("excluded", "10.240.0.8", true)
+	excluded.Labels = map[string]string{"exclude-from-gateway": "true"}
+
+	lbc.nodeStore.Add(newTestNode("agent-1", "10.240.0.5", true))
+	lbc.nodeStore.Add(newTestNode("agent-0", "10.240.0.4", true))
+	lbc.nodeStore.Add(newTestNode("notready", "10.240.0.6", false))
+	lbc.nodeStore.Add(unschedulable)
+	lbc.nodeStore.Add(excluded)
+
+	if addresses := lbc.nodeAddresses(); !reflect.DeepEqual(addresses, []string{"10.240.0.4", "10.240.0.5"}) {
+		t.Errorf("unexpected node addresses %v", addresses)
+	}
+}
+
+func TestResolveBackendNodeAddresses(t *testing.T) {
+	lbc := newTestLoadBalancerController()
+	lbc.serviceStore.Add(newTestService())
+	lbc.nodeStore.Add(newTestNode("agent-0", "10.240.0.4", true))
+
+	backend := extensions.IngressBackend{ServiceName: "web", ServicePort: intstr.FromString("http")}
+	target, err := lbc.resolveBackend("default", backend, nodeAddressType)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if target.Port != 30080 || !reflect.DeepEqual(target.Addresses, []string{"10.240.0.4"}) {
+		t.Errorf("unexpected target %+v", target)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/Azure/azure-sdk-for-go/arm/network"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/azurecontroller"
+
+	"k8s.io/kubernetes/pkg/api"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/runtime"
+	"k8s.io/kubernetes/pkg/util/intstr"
+	"k8s.io/kubernetes/pkg/watch"
+)
+
+const (
+	// defaults of the kubelet for readiness probe settings left empty
+	defaultProbePeriod           int32 = 10
+	defaultProbeTimeout          int32 = 1
+	defaultProbeFailureThreshold int32 = 3
+
+	// defaults of the gateway for probes only configured by annotations
+	defaultGatewayProbeInterval           int32 = 30
+	defaultGatewayProbeTimeout            int32 = 30
+	defaultGatewayProbeUnhealthyThreshold int32 = 3
+)
+
+// probeOverrides holds the health probe annotations of an ingress.
+type probeOverrides struct {
+	path               string
+	host               string
+	interval           int32
+	timeout            int32
+	unhealthyThreshold int32
+}
+
+// apply overrides the settings of a probe. Without a probe one is only built
+// when a path is given.
+func (overrides probeOverrides) apply(probe *azurecontroller.HealthProbe, protocol network.ApplicationGatewayProtocol) *azurecontroller.HealthProbe {
+	if probe == nil {
+		if overrides.path == "" {
+			return nil
+		}
+		probe = &azurecontroller.HealthProbe{
+			Protocol:           protocol,
+			Interval:           defaultGatewayProbeInterval,
+			Timeout:            defaultGatewayProbeTimeout,
+			UnhealthyThreshold: defaultGatewayProbeUnhealthyThreshold,
+		}
+	}
+
+	result := *probe
+	if overrides.path != "" {
+		result.Path = overrides.path
+	}
+	if overrides.host != "" {
+		result.Host = overrides.host
+	}
+	if overrides.interval > 0 {
+		result.Interval = overrides.interval
+	}
+	if overrides.timeout > 0 {
+		result.Timeout = overrides.timeout
+	}
+	if overrides.unhealthyThreshold > 0 {
+		result.UnhealthyThreshold = overrides.unhealthyThreshold
+	}
+	return &result
+}
+
+// readinessProbe derives a gateway probe from the HTTP readiness probe of the
+// pods behind a service port. Only probes checking the container port the
+// service forwards to qualify, the gateway probes through the backend port.
+func (lbc *loadBalancerController) readinessProbe(service *api.Service, servicePort api.ServicePort) *azurecontroller.HealthProbe {
+	if len(service.Spec.Selector) == 0 {
+		return nil
+	}
+	selector := labels.SelectorFromSet(service.Spec.Selector)
+
+	// pods are visited in a stable order so the probe does not flap while
+	// pods with different probes coexist during a rollout
+	pods := []*api.Pod{}
+	for _, obj := range lbc.podStore.List() {
+		pod := obj.(*api.Pod)
+		if pod.Namespace == service.Namespace && selector.Matches(labels.Set(pod.Labels)) {
+			pods = append(pods, pod)
+		}
+	}
+	sort.Sort(podsByName(pods))
+
+	for _, pod := range pods {
+		targetPort, ok := podContainerPort(pod, nil, servicePortTarget(servicePort))
+		if !ok {
+			continue
+		}
+		for index := range pod.Spec.Containers {
+			container := &pod.Spec.Containers[index]
+			if container.ReadinessProbe == nil || container.ReadinessProbe.HTTPGet == nil {
+				continue
+			}
+			httpGet := container.ReadinessProbe.HTTPGet
+			if probePort, ok := podContainerPort(pod, container, httpGet.Port); !ok || probePort != targetPort {
+				continue
+			}
+			return healthProbe(container.ReadinessProbe)
+		}
+	}
+	return nil
+}
+
+func healthProbe(probe *api.Probe) *azurecontroller.HealthProbe {
+	httpGet := probe.HTTPGet
+
+	result := &azurecontroller.HealthProbe{
+		Protocol:           network.HTTP,
+		Host:               httpGet.Host,
+		Path:               httpGet.Path,
+		Interval:           probe.PeriodSeconds,
+		Timeout:            probe.TimeoutSeconds,
+		UnhealthyThreshold: probe.FailureThreshold,
+	}
+	if httpGet.Scheme == api.URISchemeHTTPS {
+		result.Protocol = network.HTTPS
+	}
+	for _, header := range httpGet.HTTPHeaders {
+		if result.Host == "" && http.CanonicalHeaderKey(header.Name) == "Host" {
+			result.Host = header.Value
+		}
+	}
+	if result.Interval <= 0 {
+		result.Interval = defaultProbePeriod
+	}
+	if result.Timeout <= 0 {
+		result.Timeout = defaultProbeTimeout
+	}
+	if result.UnhealthyThreshold <= 0 {
+		result.UnhealthyThreshold = defaultProbeFailureThreshold
+	}
+	return result
+}
+
+// servicePortTarget returns the container port a service port forwards to, an
+// unset target port forwards to the same port number.
+func servicePortTarget(servicePort api.ServicePort) intstr.IntOrString {
+	if servicePort.TargetPort.Type == intstr.String && servicePort.TargetPort.StrVal != "" {
+		return servicePort.TargetPort
+	}
+	if servicePort.TargetPort.IntVal > 0 {
+		return servicePort.TargetPort
+	}
+	return intstr.FromInt(int(servicePort.Port))
+}
+
+// podContainerPort resolves a port by number or by name. Names are looked up in
+// the given container, or in every container of the pod if it is nil.
+func podContainerPort(pod *api.Pod, container *api.Container, port intstr.IntOrString) (int32, bool) {
+	if port.Type == intstr.Int {
+		return port.IntVal, port.IntVal > 0
+	}
+
+	containers := pod.Spec.Containers
+	if container != nil {
+		containers = []api.Container{*container}
+	}
+	for _, candidate := range containers {
+		for _, containerPort := range candidate.Ports {
+			if containerPort.Name == port.StrVal {
+				return containerPort.ContainerPort, true
+			}
+		}
+	}
+	return 0, false
+}
+
+type podsByName []*api.Pod
+
+func (pods podsByName) Len() int           { return len(pods) }
+func (pods podsByName) Swap(i, j int)      { pods[i], pods[j] = pods[j], pods[i] }
+func (pods podsByName) Less(i, j int) bool { return pods[i].Name < pods[j].Name }
+
+func podListFunc(kubeClient *client.Client, namespace string) func(api.ListOptions) (runtime.Object, error) {
+	return func(opts api.ListOptions) (runtime.Object, error) {
+		return kubeClient.Pods(namespace).List(opts)
+	}
+}
+
+func podWatchFunc(kubeClient *client.Client, namespace string) func(options api.ListOptions) (watch.Interface, error) {
+	return func(options api.ListOptions) (watch.Interface, error) {
+		return kubeClient.Pods(namespace).Watch(options)
+	}
+}
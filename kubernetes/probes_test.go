@@ -0,0 +1,99 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/arm/network"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/azurecontroller"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/util/intstr"
+)
+
+func newTestPod(name string, probePort intstr.IntOrString) *api.Pod {
+	return &api.Pod{
+		ObjectMeta: api.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{"app": "web"}},
+		Spec: api.PodSpec{
+			Containers: []api.Container{{
+				Name:  "web",
+				Ports: []api.ContainerPort{{Name: "http", ContainerPort: 8080}},
+				ReadinessProbe: &api.Probe{
+					Handler: api.Handler{
+						HTTPGet: &api.HTTPGetAction{
+							Path:        "/healthz",
+							Port:        probePort,
+							HTTPHeaders: []api.HTTPHeader{{Name: "host", Value: "web.example.com"}},
+						},
+					},
+					PeriodSeconds: 5,
+				},
+			}},
+		},
+	}
+}
+
+func TestResolveBackendReadinessProbe(t *testing.T) {
+	lbc := newTestLoadBalancerController()
+	service := newTestService()
+	service.Spec.Selector = map[string]string{"app": "web"}
+	lbc.serviceStore.Add(service)
+	lbc.endpointsStore.Add(newTestEndpoints())
+	lbc.podStore.Add(newTestPod("web-b", intstr.FromInt(9090)))
+	lbc.podStore.Add(newTestPod("web-a", intstr.FromString("http")))
+
+	backend := extensions.IngressBackend{ServiceName: "web", ServicePort: intstr.FromInt(80)}
+	target, err := lbc.resolveBackend("default", backend, podAddressType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &azurecontroller.HealthProbe{
+		Protocol:           network.HTTP,
+		Host:               "web.example.com",
+		Path:               "/healthz",
+		Interval:           5,
+		Timeout:            defaultProbeTimeout,
+		UnhealthyThreshold: defaultProbeFailureThreshold,
+	}
+	if !reflect.DeepEqual(target.Probe, expected) {
+		t.Errorf("unexpected probe %+v", target.Probe)
+	}
+
+	// the admin port is not checked by any readiness probe
+	backend.ServicePort = intstr.FromInt(9000)
+	if target, err := lbc.resolveBackend("default", backend, podAddressType); err != nil || target.Probe != nil {
+		t.Errorf("expected no probe for the admin port, got %+v, %v", target.Probe, err)
+	}
+}
+
+func TestProbeOverrides(t *testing.T) {
+	parsed, errs := parseGatewayAnnotations(ingressAnnotations{
+		probePathKey:     "/status",
+		probeIntervalKey: "15",
+	})
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	overrides := parsed.probe
+
+	derived := &azurecontroller.HealthProbe{Protocol: network.HTTP, Path: "/healthz", Interval: 5, Timeout: 1, UnhealthyThreshold: 3}
+	if probe := overrides.apply(derived, network.HTTP); probe.Path != "/status" || probe.Interval != 15 || probe.Timeout != 1 {
+		t.Errorf("unexpected overridden probe %+v", probe)
+	}
+	if derived.Path != "/healthz" {
+		t.Errorf("expected the derived probe to be left untouched")
+	}
+
+	probe := overrides.apply(nil, network.HTTPS)
+	if probe == nil || probe.Protocol != network.HTTPS || probe.Timeout != defaultGatewayProbeTimeout {
+		t.Errorf("expected a probe built from the annotations, got %+v", probe)
+	}
+	if probe := (probeOverrides{}).apply(nil, network.HTTP); probe != nil {
+		t.Errorf("expected no probe without a path, got %+v", probe)
+	}
+
+	if parsed, errs := parseGatewayAnnotations(ingressAnnotations{probeTimeoutKey: "-1"}); len(errs) != 1 || parsed.probe.timeout != 0 {
+		t.Errorf("expected a negative timeout to be rejected, got %v", errs)
+	}
+}
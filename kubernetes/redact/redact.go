@@ -0,0 +1,107 @@
+// Package redact masks credentials before values reach the logs. It only
+// depends on the standard library so that every binary of the repository
+// can use it.
+package redact
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Mask replaces every secret
+const Mask = "[REDACTED]"
+
+// secretKeys are the lower case JSON keys whose values are never logged. They
+// cover Kubernetes secrets, the certificates and passwords of gateways and
+// the fields of Azure tokens and credentials.
+var secretKeys = map[string]bool{
+	"password":                  true,
+	"secret":                    true,
+	"clientsecret":              true,
+	"client_secret":             true,
+	"aadclientsecret":           true,
+	"aadclientcertpassword":     true,
+	"clientcertificatepassword": true,
+	"certificatepassword":       true,
+	"certificate":               true,
+	"accesstoken":               true,
+	"access_token":              true,
+	"refreshtoken":              true,
+	"refresh_token":             true,
+	"privatekey":                true,
+	"data":                      true,
+	"stringdata":                true,
+}
+
+var (
+	bearerPattern     = regexp.MustCompile(`(?i)(bearer\s+)[A-Za-z0-9\-._~+/]+=*`)
+	privateKeyPattern = regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----[\s\S]*?-----END [A-Z ]*PRIVATE KEY-----`)
+	formPattern       = regexp.MustCompile(`(?i)\b(client_secret|password|refresh_token|access_token|client_assertion)=[^&\s]+`)
+	// the quotes are escaped when an error message quotes a JSON document,
+	// e.g. Azure rejecting a request
+	jsonPattern = regexp.MustCompile(`(?i)(\\*)"(password|secret|(?:aad)?client_?secret|(?:aad)?client_?cert(?:ificate)?_?password|certificate_?password|access_?token|refresh_?token|private_?key|data|string_?data)\\*"\s*:\s*\\*"(?:[^"\\]|\\[^"])*\\*"`)
+)
+
+// String masks the bearer tokens, private keys and secret form or JSON
+// fields found in free text such as error messages
+func String(s string) string {
+	s = bearerPattern.ReplaceAllString(s, "${1}"+Mask)
+	s = privateKeyPattern.ReplaceAllString(s, Mask)
+	s = formPattern.ReplaceAllString(s, "${1}="+Mask)
+	return jsonPattern.ReplaceAllString(s, `${1}"${2}${1}":${1}"`+Mask+`${1}"`)
+}
+
+// Sprintf formats like fmt.Sprintf and masks the secrets of the result, the
+// log messages of both binaries are formatted with it
+func Sprintf(format string, args ...interface{}) string {
+	return String(fmt.Sprintf(format, args...))
+}
+
+// Printf writes a message to standard output with its secrets masked
+func Printf(format string, args ...interface{}) {
+	fmt.Print(Sprintf(format, args...))
+}
+
+// Value formats a value for the logs with its secret fields masked. Values
+// are formatted as JSON, which unlike %+v follows pointers and so is able to
+// find every field.
+func Value(value interface{}) string {
+	if err, ok := value.(error); ok {
+		return String(err.Error())
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return String(fmt.Sprintf("%+v", value))
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		return String(string(encoded))
+	}
+	encoded, err = json.Marshal(mask(decoded))
+	if err != nil {
+		return Mask
+	}
+	return String(string(encoded))
+}
+
+// mask replaces the values of secret keys throughout decoded JSON
+func mask(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, field := range value {
+			if secretKeys[strings.ToLower(key)] {
+				value[key] = Mask
+			} else {
+				value[key] = mask(field)
+			}
+		}
+	case []interface{}:
+		for index, item := range value {
+			value[index] = mask(item)
+		}
+	}
+	return value
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/jargoonpard/appGatewaySample/kubernetes/azurecontroller"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/logging"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+// gatewayName returns the gateway serving an ingress, including placements
+// that were recorded but have not reached the informer cache yet.
+func (lbc *loadBalancerController) gatewayName(ingress *extensions.Ingress) string {
+	if name := ingressGatewayName(ingress); name != "" {
+		return name
+	}
+
+	key, err := keyFunc(ingress)
+	if err != nil {
+		return ""
+	}
+	lbc.assignmentLock.Lock()
+	defer lbc.assignmentLock.Unlock()
+	return lbc.assignments[key]
+}
+
+// forgetAssignment drops the pending placement of an ingress.
+func (lbc *loadBalancerController) forgetAssignment(key string) {
+	lbc.assignmentLock.Lock()
+	delete(lbc.assignments, key)
+	lbc.assignmentLock.Unlock()
+}
+
+// placeIngress returns the gateway serving an ingress. Ingresses without a
+// gateway are placed on the first shared gateway with room for them and the
+// choice is recorded on the ingress, so it survives restarts and the ingress
+// never moves once it is served.
+func (lbc *loadBalancerController) placeIngress(ingress *extensions.Ingress) (string, error) {
+	if name := lbc.gatewayName(ingress); name != "" {
+		return name, nil
+	}
+
+	name := lbc.chooseGateway(ingress)
+	if err := lbc.recordAssignment(ingress, name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// chooseGateway bin-packs an ingress onto the shared gateways. Gateways are
+// filled in order and a new one is started once the ingress no longer fits
+// within the Azure limits of the existing ones.
+func (lbc *loadBalancerController) chooseGateway(ingress *extensions.Ingress) string {
+	byGateway := map[string][]azurecontroller.IngressState{}
+	for _, obj := range lbc.ingressStore.List() {
+		other := obj.(*extensions.Ingress)
+		if other.Namespace == ingress.Namespace && other.Name == ingress.Name {
+			continue
+		}
+		if !isAzureIngress(other) {
+			continue
+		}
+		if name := lbc.gatewayName(other); name != "" {
+			byGateway[name] = append(byGateway[name], lbc.placementState(other))
+		}
+	}
+
+	state := lbc.placementState(ingress)
+	for shard := 0; ; shard++ {
+		name := shardGatewayName(lbc.gatewayPrefix, shard)
+		states := append([]azurecontroller.IngressState{state}, byGateway[name]...)
+		err := azurecontroller.MeasureGateway(states).Exceeds(lbc.gatewayLimits)
+		if err == nil {
+			return name
+		}
+		// an ingress too large for any gateway still gets one of its own,
+		// syncing it reports the limits it exceeds
+		if len(byGateway[name]) == 0 {
+			logging.Warningf("ingress %v/%v does not fit on a single gateway: %v", ingress.Namespace, ingress.Name, err)
+			return name
+		}
+	}
+}
+
+// recordAssignment stores the gateway of an ingress in its annotations.
+func (lbc *loadBalancerController) recordAssignment(ingress *extensions.Ingress, gatewayName string) error {
+	key, err := keyFunc(ingress)
+	if err != nil {
+		return err
+	}
+
+	// the ingress belongs to the informer cache and must not be modified
+	updated := *ingress
+	updated.Annotations = map[string]string{}
+	for name, value := range ingress.Annotations {
+		updated.Annotations[name] = value
+	}
+	updated.Annotations[assignedGatewayKey] = gatewayName
+
+	// the placement is pending before the update, so the update event that
+	// follows already resolves the same gateway for the previous version
+	lbc.assignmentLock.Lock()
+	lbc.assignments[key] = gatewayName
+	lbc.assignmentLock.Unlock()
+
+	if _, err := lbc.client.Extensions().Ingress(ingress.Namespace).Update(&updated); err != nil {
+		logging.Errorf("Failed to assign ingress %v to gateway %v: %v", key, gatewayName, err)
+		lbc.forgetAssignment(key)
+		return err
+	}
+
+	logging.Infof("Assigned ingress %v to gateway %v", key, gatewayName)
+	lbc.recorder.Eventf(ingress, api.EventTypeNormal, "ASSIGN", "assigned to gateway %v", gatewayName)
+	return nil
+}
+
+// placementState is the part of an ingress state the gateway usage depends
+// on. Certificates count by secret, so secrets that are missing or not yet
+// converted still reserve room on the gateway.
+func (lbc *loadBalancerController) placementState(ingress *extensions.Ingress) azurecontroller.IngressState {
+	certificates := map[string]azurecontroller.TLSCertificate{}
+	for _, tls := range ingress.Spec.TLS {
+		if tls.SecretName != "" {
+			certificates[tls.SecretName] = azurecontroller.TLSCertificate{}
+		}
+	}
+
+	// an unusable backend CA is reported when the ingress syncs
+	var backendCertificates []azurecontroller.AuthenticationCertificate
+	if reference := ingressAnnotations(ingress.Annotations).backendCA(); reference != "" {
+		backendCertificates, _ = lbc.loadBackendCertificates(ingress.Namespace, reference)
+	}
+
+	return azurecontroller.IngressState{
+		Ingress:             ingress,
+		Certificates:        certificates,
+		BackendCertificates: backendCertificates,
+	}
+}
+
+func shardGatewayName(prefix string, shard int) string {
+	return fmt.Sprintf("%s-%d", prefix, shard)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jargoonpard/appGatewaySample/kubernetes/azurecontroller"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/util/intstr"
+)
+
+func newTestHostIngress(name string, hosts ...string) *extensions.Ingress {
+	ingress := &extensions.Ingress{ObjectMeta: api.ObjectMeta{Name: name, Namespace: "default"}}
+	for _, host := range hosts {
+		ingress.Spec.Rules = append(ingress.Spec.Rules, extensions.IngressRule{
+			Host: host,
+			IngressRuleValue: extensions.IngressRuleValue{
+				HTTP: &extensions.HTTPIngressRuleValue{Paths: []extensions.HTTPIngressPath{{
+					Path:    "/",
+					Backend: extensions.IngressBackend{ServiceName: name, ServicePort: intstr.FromInt(80)},
+				}}},
+			},
+		})
+	}
+	return ingress
+}
+
+func TestChooseGateway(t *testing.T) {
+	lbc := newTestLoadBalancerController()
+	lbc.gatewayLimits = azurecontroller.GatewayLimits{Listeners: 2, BackendPools: 3, PathRules: 100, SslCertificates: 1, AuthenticationCertificates: 1}
+
+	first := newTestHostIngress("first", "first.example.com")
+	if name := lbc.chooseGateway(first); name != "ingress-0" {
+		t.Fatalf("expected the first gateway, got %v", name)
+	}
+	lbc.assignments["default/first"] = "ingress-0"
+	lbc.ingressStore.Add(first)
+
+	second := newTestHostIngress("second", "second.example.com")
+	if name := lbc.chooseGateway(second); name != "ingress-0" {
+		t.Errorf("expected the ingress to share the first gateway, got %v", name)
+	}
+
+	third := newTestHostIngress("third", "third.example.com", "other.example.com")
+	if name := lbc.chooseGateway(third); name != "ingress-1" {
+		t.Errorf("expected a full gateway to start a new one, got %v", name)
+	}
+
+	huge := newTestHostIngress("huge", "a.example.com", "b.example.com", "c.example.com")
+	if name := lbc.chooseGateway(huge); name != "ingress-1" {
+		t.Errorf("expected an oversized ingress to get an empty gateway, got %v", name)
+	}
+}
+
+func TestGatewayNameAssignments(t *testing.T) {
+	lbc := newTestLoadBalancerController()
+	ingress := newTestHostIngress("web", "foo.example.com")
+	if name := lbc.gatewayName(ingress); name != "" {
+		t.Errorf("expected an unplaced ingress to have no gateway, got %v", name)
+	}
+
+	lbc.assignments["default/web"] = "ingress-3"
+	if name := lbc.gatewayName(ingress); name != "ingress-3" {
+		t.Errorf("expected the pending assignment, got %v", name)
+	}
+
+	ingress.Annotations = map[string]string{assignedGatewayKey: "ingress-2"}
+	if name := lbc.gatewayName(ingress); name != "ingress-2" {
+		t.Errorf("expected the recorded assignment, got %v", name)
+	}
+
+	ingress.Annotations[gatewayNameKey] = "dedicated"
+	if name := lbc.gatewayName(ingress); name != "dedicated" {
+		t.Errorf("expected the gateway-name annotation to win, got %v", name)
+	}
+
+	lbc.forgetAssignment("default/web")
+	if _, ok := lbc.assignments["default/web"]; ok {
+		t.Errorf("expected the pending assignment to be forgotten")
+	}
+}
+
+// recording a placement changes the annotations of the ingress, the update
+// event that follows must not release the gateway it was just placed on
+func TestRecordedAssignmentIsNotAMove(t *testing.T) {
+	lbc := newTestLoadBalancerController()
+	lbc.ingressQueue = newTaskQueue(func(string) error { return nil })
+	lbc.removedIngresses = map[string]*extensions.Ingress{}
+
+	unplaced := newTestHostIngress("web", "web.example.com")
+	lbc.assignments["default/web"] = "ingress-0"
+	placed := newTestHostIngress("web", "web.example.com")
+	placed.Annotations = map[string]string{assignedGatewayKey: "ingress-0"}
+	lbc.ingressStore.Add(placed)
+
+	lbc.ingressUpdated(unplaced, placed)
+	if removed, ok := lbc.removedIngresses["default/web"]; ok {
+		t.Fatalf("expected the placement not to release a gateway, %v is scheduled for teardown", removed.Name)
+	}
+	if lbc.releasesGateway(unplaced, placed) {
+		t.Errorf("expected the sync not to tear down the gateway of the placed ingress")
+	}
+	if lbc.ingressQueue.queue.Len() != 1 {
+		t.Errorf("expected the ingress to be synced")
+	}
+
+	moved := newTestHostIngress("web", "web.example.com")
+	moved.Annotations = map[string]string{assignedGatewayKey: "ingress-0", gatewayNameKey: "dedicated"}
+	lbc.ingressUpdated(placed, moved)
+	if _, ok := lbc.removedIngresses["default/web"]; !ok {
+		t.Errorf("expected an ingress moved to another gateway to release the previous one")
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"reflect"
+
+	"github.com/jargoonpard/appGatewaySample/kubernetes/logging"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+// loadBalancerStatus returns the status announcing a gateway address, an empty
+// address clears the status.
+func loadBalancerStatus(address string) api.LoadBalancerStatus {
+	if address == "" {
+		return api.LoadBalancerStatus{}
+	}
+	return api.LoadBalancerStatus{
+		Ingress: []api.LoadBalancerIngress{{IP: address}},
+	}
+}
+
+// updateIngressStatus publishes the address of the gateway serving an ingress
+// in its status, which is what `kubectl get ingress` shows as ADDRESS.
+func (lbc *loadBalancerController) updateIngressStatus(ingress *extensions.Ingress, address string) error {
+	status := loadBalancerStatus(address)
+	if reflect.DeepEqual(ingress.Status.LoadBalancer, status) {
+		return nil
+	}
+
+	// the ingress belongs to the informer cache and must not be modified
+	updated := *ingress
+	updated.Status.LoadBalancer = status
+	if _, err := lbc.client.Extensions().Ingress(ingress.Namespace).UpdateStatus(&updated); err != nil {
+		logging.Errorf("Failed to update the status of ingress %v/%v: %v", ingress.Namespace, ingress.Name, err)
+		return err
+	}
+
+	logging.Infof("Updated the address of ingress %v/%v to %q", ingress.Namespace, ingress.Name, address)
+	return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/jargoonpard/appGatewaySample/kubernetes/azurecontroller"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/logging"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/redact"
+)
+
+// teardownSummary is the last line of the teardown response
+type teardownSummary struct {
+	Done    bool   `json:"done"`
+	Deleted int    `json:"deleted"`
+	Failed  int    `json:"failed"`
+	Error   string `json:"error,omitempty"`
+}
+
+// teardownHandler deletes every Azure resource of the cluster, stops the
+// controller and lets main exit. The request has to be a POST carrying the token of tokenFile as
+// a bearer token, the endpoint is disabled without a token file. Progress is
+// streamed as one JSON object per line, ending with a teardownSummary. When a
+// deletion fails the summary is not done and the controller resumes syncing,
+// the request can be retried.
+func teardownHandler(lbc *loadBalancerController, tokenFile string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			writeJSON(w, http.StatusMethodNotAllowed, teardownSummary{Error: "teardown requires a POST"})
+			return
+		}
+		if tokenFile == "" {
+			writeJSON(w, http.StatusForbidden, teardownSummary{Error: "teardown is disabled, no token file is configured"})
+			return
+		}
+		token, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			logging.Errorf("Failed to read the teardown token: %v", err)
+			writeJSON(w, http.StatusInternalServerError, teardownSummary{Error: "the teardown token cannot be read"})
+			return
+		}
+		if !validBearerToken(r, strings.TrimSpace(string(token))) {
+			writeJSON(w, http.StatusUnauthorized, teardownSummary{Error: "missing or invalid teardown token"})
+			return
+		}
+		if lbc.azureGWClient.ClusterID == "" {
+			writeJSON(w, http.StatusPreconditionFailed, teardownSummary{Error: "no cluster ID configured, the resources of the cluster cannot be told apart from others"})
+			return
+		}
+		if !lbc.isLeader() {
+			writeJSON(w, http.StatusConflict, teardownSummary{Error: "only the leader " + lbc.leaderStatus().Leader + " tears down the cluster"})
+			return
+		}
+		if !lbc.startTeardown() {
+			writeJSON(w, http.StatusConflict, teardownSummary{Error: "teardown is already in progress"})
+			return
+		}
+
+		logging.Infof("Tearing down the Azure resources of the cluster")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		encoder := json.NewEncoder(w)
+		flusher, _ := w.(http.Flusher)
+
+		summary := teardownSummary{}
+		err = lbc.azureGWClient.DeleteAll(func(resource azurecontroller.DeletedResource) {
+			if resource.Error == "" {
+				summary.Deleted++
+			} else {
+				summary.Failed++
+			}
+			encoder.Encode(resource)
+			if flusher != nil {
+				flusher.Flush()
+			}
+		})
+		summary.Done = err == nil
+		if err != nil {
+			logging.Errorf("Teardown failed: %v", redact.Value(err))
+			summary.Error = redact.Value(err)
+		}
+		encoder.Encode(summary)
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		logging.Infof("Teardown deleted %v resources, %v failed", summary.Deleted, summary.Failed)
+		if !summary.Done {
+			lbc.abortTeardown()
+			return
+		}
+		lbc.Stop()
+		close(lbc.tornDown)
+	}
+}
+
+// validBearerToken compares the bearer token of the request in constant time
+func validBearerToken(r *http.Request, token string) bool {
+	const prefix = "Bearer "
+	header := r.Header.Get("Authorization")
+	if token == "" || !strings.HasPrefix(header, prefix) {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(header, prefix)), []byte(token)) == 1
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+// startTeardown stops the sync workers so nothing recreates the gateways
+// being deleted. It returns false when a teardown started already.
+func (lbc *loadBalancerController) startTeardown() bool {
+	lbc.stoplock.Lock()
+	if lbc.tearingDown || lbc.shutdown {
+		lbc.stoplock.Unlock()
+		return false
+	}
+	lbc.tearingDown = true
+	lbc.stoplock.Unlock()
+
+	// the queue waits for the sync in flight, which may take as long as a
+	// gateway write, so the lock is released first. Closing stopCh instead
+	// would cancel the deletions as well.
+	logging.Infof("Shutting down controller queues for the teardown")
+	lbc.ingressQueue.shutdown()
+	return true
+}
+
+// abortTeardown restarts the sync workers after a failed teardown, the
+// ingresses recreate the gateways that were deleted
+func (lbc *loadBalancerController) abortTeardown() {
+	lbc.stoplock.Lock()
+	defer lbc.stoplock.Unlock()
+	lbc.tearingDown = false
+	if lbc.shutdown {
+		return
+	}
+
+	logging.Infof("Restarting controller queues after the failed teardown")
+	lbc.ingressQueue.restart()
+	lbc.enqueueAllIngresses()
+}
+
+// isTearingDown determines if the resources of the cluster are being deleted
+func (lbc *loadBalancerController) isTearingDown() bool {
+	lbc.stoplock.Lock()
+	defer lbc.stoplock.Unlock()
+	return lbc.tearingDown
+}
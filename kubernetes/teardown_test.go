@@ -0,0 +1,205 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/azurecontroller"
+)
+
+func TestTeardownHandlerRefusals(t *testing.T) {
+	dir, err := ioutil.TempDir("", "teardown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	lbc := newTestLoadBalancerController()
+	lbc.azureGWClient = azurecontroller.NewAzureGatewayClientController(azurecontroller.AzureCredentialInfo{Authorizer: autorest.NullAuthorizer{}}, azurecontroller.ProvisioningOptions{ClusterID: "cluster"}, nil)
+	lbc.elector, err = newLeaderElector(&fakeEndpointsLock{}, "default", "leader", "standby", 15*time.Second, 10*time.Second, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		method    string
+		token     string
+		tokenFile string
+		expected  int
+	}{
+		{"get", "GET", "secret", tokenFile, http.StatusMethodNotAllowed},
+		{"disabled", "POST", "secret", "", http.StatusForbidden},
+		{"missing token", "POST", "", tokenFile, http.StatusUnauthorized},
+		{"wrong token", "POST", "guess", tokenFile, http.StatusUnauthorized},
+		{"standby", "POST", "secret", tokenFile, http.StatusConflict},
+	}
+	for _, test := range tests {
+		request, _ := http.NewRequest(test.method, "/delete-all-and-quit", nil)
+		if test.token != "" {
+			request.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		recorder := httptest.NewRecorder()
+		teardownHandler(lbc, test.tokenFile)(recorder, request)
+		if recorder.Code != test.expected {
+			t.Errorf("%v: expected %v, got %v %v", test.name, test.expected, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	// without a cluster ID the resources of the cluster cannot be found
+	lbc.azureGWClient.ClusterID = ""
+	request, _ := http.NewRequest("POST", "/delete-all-and-quit", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+	recorder := httptest.NewRecorder()
+	teardownHandler(lbc, tokenFile)(recorder, request)
+	if recorder.Code != http.StatusPreconditionFailed {
+		t.Errorf("no cluster ID: expected %v, got %v %v", http.StatusPreconditionFailed, recorder.Code, recorder.Body.String())
+	}
+
+	if lbc.isTearingDown() {
+		t.Errorf("expected no refused request to start the teardown")
+	}
+}
+
+// readiness and leadership checks take the stop lock while a teardown waits
+// for the sync in flight, which can take as long as a gateway write
+func TestStartTeardownReleasesTheLockWhileDraining(t *testing.T) {
+	lbc := newTestLoadBalancerController()
+	syncing, release := make(chan struct{}), make(chan struct{})
+	lbc.ingressQueue = newTaskQueue(func(string) error {
+		close(syncing)
+		<-release
+		return nil
+	})
+	lbc.stopCh = make(chan struct{})
+	defer close(lbc.stopCh)
+	lbc.ingressQueue.run(time.Millisecond, lbc.stopCh)
+	lbc.ingressQueue.enqueue(newTestHostIngress("web", "foo.example.com"))
+	<-syncing
+
+	started := make(chan bool)
+	go func() {
+		started <- lbc.startTeardown()
+	}()
+	observed := make(chan struct{})
+	go func() {
+		for !lbc.isTearingDown() {
+			time.Sleep(time.Millisecond)
+		}
+		close(observed)
+	}()
+	select {
+	case <-observed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the teardown to be observable while the sync in flight drains")
+	}
+
+	close(release)
+	if !<-started {
+		t.Errorf("expected the teardown to start")
+	}
+}
+
+//tearDownAgainst runs a teardown against an Azure API answering every request
+//with status and no resources
+func tearDownAgainst(t *testing.T, lbc *loadBalancerController, status int) string {
+	dir, err := ioutil.TempDir("", "teardown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"value": []}`))
+	}))
+	defer server.Close()
+
+	environment := azure.PublicCloud
+	environment.ResourceManagerEndpoint = server.URL
+	lbc.azureGWClient = azurecontroller.NewAzureGatewayClientController(azurecontroller.AzureCredentialInfo{
+		ResourceGroupName: "group",
+		SubscriptionID:    "subscription",
+		Authorizer:        autorest.NullAuthorizer{},
+		Environment:       environment,
+	}, azurecontroller.ProvisioningOptions{ClusterID: "cluster"}, lbc.stopCh)
+
+	request, _ := http.NewRequest("POST", "/delete-all-and-quit", nil)
+	request.Header.Set("Authorization", "Bearer secret")
+	recorder := httptest.NewRecorder()
+	teardownHandler(lbc, tokenFile)(recorder, request)
+	return recorder.Body.String()
+}
+
+func TestTeardownLetsMainExit(t *testing.T) {
+	lbc := newTestLoadBalancerController()
+	lbc.ingressQueue = newTaskQueue(func(string) error { return nil })
+	lbc.stopCh = make(chan struct{})
+	lbc.tornDown = make(chan struct{})
+
+	if body := tearDownAgainst(t, lbc, http.StatusOK); !strings.Contains(body, `"done":true`) {
+		t.Errorf("expected the summary to be written, got %v", body)
+	}
+	select {
+	case <-lbc.tornDown:
+	default:
+		t.Errorf("expected main to be told the teardown completed")
+	}
+	select {
+	case <-lbc.stopCh:
+	default:
+		t.Errorf("expected the controller to be stopped")
+	}
+}
+
+func TestFailedTeardownRestartsTheWorkers(t *testing.T) {
+	lbc := newTestLoadBalancerController()
+	synced := make(chan string, 1)
+	lbc.ingressQueue = newTaskQueue(func(key string) error {
+		synced <- key
+		return nil
+	})
+	lbc.stopCh = make(chan struct{})
+	defer close(lbc.stopCh)
+	lbc.tornDown = make(chan struct{})
+	lbc.ingressQueue.run(time.Millisecond, lbc.stopCh)
+	lbc.ingressStore.Add(newTestHostIngress("web", "foo.example.com"))
+
+	if body := tearDownAgainst(t, lbc, http.StatusForbidden); !strings.Contains(body, `"done":false`) {
+		t.Errorf("expected the summary to report the failure, got %v", body)
+	}
+	select {
+	case <-lbc.tornDown:
+		t.Errorf("expected main to keep running")
+	default:
+	}
+	if lbc.isTearingDown() {
+		t.Errorf("expected the teardown to be over")
+	}
+
+	// the ingresses are synced again by the restarted worker
+	select {
+	case key := <-synced:
+		if key != "default/web" {
+			t.Errorf("expected the ingress to be synced, got %v", key)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the worker to be restarted")
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/jargoonpard/appGatewaySample/kubernetes/azurecontroller"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/logging"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/redact"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+	"k8s.io/kubernetes/pkg/client/cache"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/runtime"
+	"k8s.io/kubernetes/pkg/watch"
+)
+
+// cachedCertificate is a converted secret, a new resource version of the
+// secret invalidates it.
+type cachedCertificate struct {
+	resourceVersion string
+	certificate     azurecontroller.TLSCertificate
+}
+
+// parseBackendCA splits a backend CA reference into the kind and name of the
+// object holding the certificates.
+func parseBackendCA(reference string) (string, string, error) {
+	parts := strings.SplitN(reference, "/", 2)
+	if len(parts) != 2 || parts[1] == "" || (parts[0] != secretKind && parts[0] != configMapKind) {
+		return "", "", fmt.Errorf("invalid %v %q, expected %v/<name> or %v/<name>", backendCAKey, reference, secretKind, configMapKind)
+	}
+	return parts[0], parts[1], nil
+}
+
+// ingressReferencesObject determines if the ingress uses the secret or config
+// map, either for TLS termination or as the CA of its backends.
+func ingressReferencesObject(ingress *extensions.Ingress, kind, namespace, name string) bool {
+	if ingress.Namespace != namespace {
+		return false
+	}
+	if kind == secretKind {
+		for _, tls := range ingress.Spec.TLS {
+			if tls.SecretName == name {
+				return true
+			}
+		}
+	}
+	caKind, caName, err := parseBackendCA(ingressAnnotations(ingress.Annotations).backendCA())
+	return err == nil && caKind == kind && caName == name
+}
+
+// certificateEventHandler requeues the ingresses using a secret or config map
+// whenever it is created, rotated or deleted.
+func (lbc *loadBalancerController) certificateEventHandler(kind string) cache.ResourceEventHandlerFuncs {
+	enqueue := func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		meta, err := api.ObjectMetaFor(obj.(runtime.Object))
+		if err != nil {
+			logging.Infof("could not get metadata for object %v: %v", redact.Value(obj), redact.Value(err))
+			return
+		}
+		for _, obj := range lbc.ingressStore.List() {
+			ingress := obj.(*extensions.Ingress)
+			if isAzureIngress(ingress) && ingressReferencesObject(ingress, kind, meta.Namespace, meta.Name) {
+				logging.V(3).Infof("%v %v/%v changed, requeuing ingress %v/%v", kind, meta.Namespace, meta.Name, ingress.Namespace, ingress.Name)
+				lbc.ingressQueue.enqueue(ingress)
+			}
+		}
+	}
+
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: enqueue,
+		UpdateFunc: func(old, cur interface{}) {
+			// only the payload matters, status and metadata updates are ignored
+			switch cur := cur.(type) {
+			case *api.Secret:
+				if reflect.DeepEqual(old.(*api.Secret).Data, cur.Data) {
+					return
+				}
+			case *api.ConfigMap:
+				if reflect.DeepEqual(old.(*api.ConfigMap).Data, cur.Data) {
+					return
+				}
+			}
+			enqueue(cur)
+		},
+		DeleteFunc: enqueue,
+	}
+}
+
+// resolveCertificates converts the secrets referenced by the TLS section of an
+// ingress. Secrets that are missing or invalid are reported as events and left
+// out, which serves their hosts over HTTP only.
+func (lbc *loadBalancerController) resolveCertificates(ingress *extensions.Ingress) map[string]azurecontroller.TLSCertificate {
+	certificates := map[string]azurecontroller.TLSCertificate{}
+	for _, tls := range ingress.Spec.TLS {
+		if tls.SecretName == "" {
+			continue
+		}
+		if _, ok := certificates[tls.SecretName]; ok {
+			continue
+		}
+
+		certificate, err := lbc.resolveCertificate(ingress.Namespace, tls.SecretName)
+		if err != nil {
+			logging.Warningf("ingress %v/%v: %v", ingress.Namespace, ingress.Name, err)
+			lbc.recorder.Eventf(ingress, api.EventTypeWarning, "TLS", "%v", redact.Value(err))
+			continue
+		}
+		certificates[tls.SecretName] = certificate
+	}
+	return certificates
+}
+
+func (lbc *loadBalancerController) resolveCertificate(namespace, name string) (azurecontroller.TLSCertificate, error) {
+	key := fmt.Sprintf("%s/%s", namespace, name)
+
+	obj, exists, err := lbc.secretStore.GetByKey(key)
+	if err != nil {
+		return azurecontroller.TLSCertificate{}, err
+	}
+	if !exists {
+		lbc.certificateLock.Lock()
+		delete(lbc.certificates, key)
+		lbc.certificateLock.Unlock()
+		return azurecontroller.TLSCertificate{}, fmt.Errorf("secret %v does not exist", key)
+	}
+	secret := obj.(*api.Secret)
+
+	// converting generates a new password every time, reusing the result
+	// keeps the gateway payload stable while the secret is unchanged
+	lbc.certificateLock.Lock()
+	cached, ok := lbc.certificates[key]
+	lbc.certificateLock.Unlock()
+	if ok && cached.resourceVersion == secret.ResourceVersion {
+		return cached.certificate, nil
+	}
+
+	certPEM, hasCert := secret.Data[api.TLSCertKey]
+	keyPEM, hasKey := secret.Data[api.TLSPrivateKeyKey]
+	if !hasCert || !hasKey {
+		return azurecontroller.TLSCertificate{}, fmt.Errorf("secret %v must contain %v and %v", key, api.TLSCertKey, api.TLSPrivateKeyKey)
+	}
+
+	certificate, err := azurecontroller.NewTLSCertificate(certPEM, keyPEM)
+	if err != nil {
+		return azurecontroller.TLSCertificate{}, fmt.Errorf("secret %v holds an invalid certificate: %v", key, err)
+	}
+
+	lbc.certificateLock.Lock()
+	lbc.certificates[key] = cachedCertificate{resourceVersion: secret.ResourceVersion, certificate: certificate}
+	lbc.certificateLock.Unlock()
+
+	return certificate, nil
+}
+
+// resolveBackendCertificates loads the CA certificates the gateway validates
+// HTTPS backends against. Ingresses without a backend CA return no certificates
+// and keep using HTTP. Unlike TLS secrets an unusable CA fails the sync, falling
+// back to plain HTTP would break backends that only speak HTTPS.
+func (lbc *loadBalancerController) resolveBackendCertificates(ingress *extensions.Ingress) ([]azurecontroller.AuthenticationCertificate, error) {
+	reference := ingressAnnotations(ingress.Annotations).backendCA()
+	if reference == "" {
+		return nil, nil
+	}
+
+	certificates, err := lbc.loadBackendCertificates(ingress.Namespace, reference)
+	if err != nil {
+		logging.Warningf("ingress %v/%v: %v", ingress.Namespace, ingress.Name, err)
+		lbc.recorder.Eventf(ingress, api.EventTypeWarning, "BACKEND_CA", "%v", redact.Value(err))
+		return nil, err
+	}
+	return certificates, nil
+}
+
+func (lbc *loadBalancerController) loadBackendCertificates(namespace, reference string) ([]azurecontroller.AuthenticationCertificate, error) {
+	kind, name, err := parseBackendCA(reference)
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("%s/%s", namespace, name)
+
+	var bundle []byte
+	switch kind {
+	case secretKind:
+		obj, exists, err := lbc.secretStore.GetByKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, fmt.Errorf("backend CA secret %v does not exist", key)
+		}
+		bundle = obj.(*api.Secret).Data[backendCADataKey]
+	case configMapKind:
+		obj, exists, err := lbc.configMapStore.GetByKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, fmt.Errorf("backend CA config map %v does not exist", key)
+		}
+		bundle = []byte(obj.(*api.ConfigMap).Data[backendCADataKey])
+	}
+
+	if len(bundle) == 0 {
+		return nil, fmt.Errorf("backend CA %v %v has no %v", kind, key, backendCADataKey)
+	}
+	certificates, err := azurecontroller.NewAuthenticationCertificates(bundle)
+	if err != nil {
+		return nil, fmt.Errorf("backend CA %v %v is invalid: %v", kind, key, err)
+	}
+	return certificates, nil
+}
+
+func secretListFunc(kubeClient *client.Client, namespace string) func(api.ListOptions) (runtime.Object, error) {
+	return func(opts api.ListOptions) (runtime.Object, error) {
+		return kubeClient.Secrets(namespace).List(opts)
+	}
+}
+
+func secretWatchFunc(kubeClient *client.Client, namespace string) func(options api.ListOptions) (watch.Interface, error) {
+	return func(options api.ListOptions) (watch.Interface, error) {
+		return kubeClient.Secrets(namespace).Watch(options)
+	}
+}
+
+func configMapListFunc(kubeClient *client.Client, namespace string) func(api.ListOptions) (runtime.Object, error) {
+	return func(opts api.ListOptions) (runtime.Object, error) {
+		return kubeClient.ConfigMaps(namespace).List(opts)
+	}
+}
+
+func configMapWatchFunc(kubeClient *client.Client, namespace string) func(options api.ListOptions) (watch.Interface, error) {
+	return func(options api.ListOptions) (watch.Interface, error) {
+		return kubeClient.ConfigMaps(namespace).Watch(options)
+	}
+}
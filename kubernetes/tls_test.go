@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/extensions"
+)
+
+func newTestSecret(t *testing.T, resourceVersion string) *api.Secret {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "foo.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &api.Secret{
+		ObjectMeta: api.ObjectMeta{Name: "web-tls", Namespace: "default", ResourceVersion: resourceVersion},
+		Type:       api.SecretTypeTLS,
+		Data: map[string][]byte{
+			api.TLSCertKey:       pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+			api.TLSPrivateKeyKey: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+		},
+	}
+}
+
+func newTestTLSIngress() *extensions.Ingress {
+	return &extensions.Ingress{
+		ObjectMeta: api.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec: extensions.IngressSpec{
+			TLS: []extensions.IngressTLS{{Hosts: []string{"foo.example.com"}, SecretName: "web-tls"}},
+		},
+	}
+}
+
+func TestResolveCertificatesRotation(t *testing.T) {
+	lbc := newTestLoadBalancerController()
+	lbc.secretStore.Add(newTestSecret(t, "1"))
+	ingress := newTestTLSIngress()
+
+	first := lbc.resolveCertificates(ingress)["web-tls"]
+	if first.Data == "" {
+		t.Fatalf("expected the secret to be converted")
+	}
+	if again := lbc.resolveCertificates(ingress)["web-tls"]; again != first {
+		t.Errorf("expected an unchanged secret to reuse its conversion")
+	}
+
+	lbc.secretStore.Update(newTestSecret(t, "2"))
+	rotated := lbc.resolveCertificates(ingress)["web-tls"]
+	if rotated.Fingerprint == first.Fingerprint {
+		t.Errorf("expected the rotated secret to change the fingerprint")
+	}
+}
+
+func TestResolveCertificatesInvalidSecrets(t *testing.T) {
+	lbc := newTestLoadBalancerController()
+	ingress := newTestTLSIngress()
+
+	if certificates := lbc.resolveCertificates(ingress); len(certificates) != 0 {
+		t.Errorf("expected no certificate for a missing secret, got %v", len(certificates))
+	}
+
+	secret := newTestSecret(t, "1")
+	delete(secret.Data, api.TLSPrivateKeyKey)
+	lbc.secretStore.Add(secret)
+	if certificates := lbc.resolveCertificates(ingress); len(certificates) != 0 {
+		t.Errorf("expected no certificate for a secret without a key, got %v", len(certificates))
+	}
+}
+
+func TestResolveBackendCertificates(t *testing.T) {
+	lbc := newTestLoadBalancerController()
+	bundle := newTestSecret(t, "1").Data[api.TLSCertKey]
+	lbc.configMapStore.Add(&api.ConfigMap{
+		ObjectMeta: api.ObjectMeta{Name: "backend-ca", Namespace: "default"},
+		Data:       map[string]string{backendCADataKey: string(bundle) + string(bundle)},
+	})
+
+	ingress := newTestTLSIngress()
+	if certificates, err := lbc.resolveBackendCertificates(ingress); err != nil || certificates != nil {
+		t.Errorf("expected no backend certificates without the annotation, got %v, %v", certificates, err)
+	}
+
+	ingress.Annotations = map[string]string{backendCAKey: "configmap/backend-ca"}
+	certificates, err := lbc.resolveBackendCertificates(ingress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certificates) != 1 {
+		t.Errorf("expected the duplicated certificate once, got %v", len(certificates))
+	}
+	if !ingressReferencesObject(ingress, configMapKind, "default", "backend-ca") || ingressReferencesObject(ingress, secretKind, "default", "backend-ca") {
+		t.Errorf("expected the ingress to reference only the backend-ca config map")
+	}
+
+	for _, reference := range []string{"secret/missing", "configmap/", "pod/backend-ca"} {
+		ingress.Annotations[backendCAKey] = reference
+		if _, err := lbc.resolveBackendCertificates(ingress); err == nil {
+			t.Errorf("expected an error for %v", reference)
+		}
+	}
+}
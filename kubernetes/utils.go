@@ -1,10 +1,17 @@
 package main
 
 import (
+	"fmt"
+	"io/ioutil"
+	"sync"
 	"time"
 
+	"github.com/Azure/go-autorest/autorest"
 	"github.com/Azure/go-autorest/autorest/azure"
-	"github.com/golang/glog"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/azurecontroller"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/credentials"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/logging"
+	"github.com/jargoonpard/appGatewaySample/kubernetes/redact"
 
 	"k8s.io/kubernetes/pkg/apis/extensions"
 	"k8s.io/kubernetes/pkg/util/wait"
@@ -16,6 +23,29 @@ type ingressAnnotations map[string]string
 const (
 	ingressClassKey   = "kubernetes.io/ingress.class"
 	azureIngressClass = "azure"
+
+	// backendAddressTypeKey selects whether backend pools hold pod IPs or
+	// node IPs combined with the service node port
+	backendAddressTypeKey = "azure.ingress.kubernetes.io/backend-address-type"
+	podAddressType        = "pod"
+	nodeAddressType       = "node"
+
+	// backendCAKey references the secret or config map, as secret/<name> or
+	// configmap/<name>, holding the CA certificates under backendCADataKey.
+	// Setting it makes the gateway reach the backends over HTTPS.
+	backendCAKey     = "azure.ingress.kubernetes.io/backend-ca"
+	backendCADataKey = "ca.crt"
+	secretKind       = "secret"
+	configMapKind    = "configmap"
+
+	// the health probe annotations override the probes derived from the
+	// readiness probes of the pods, the path alone is enough to probe
+	// backends whose pods have none
+	probePathKey               = "azure.ingress.kubernetes.io/health-probe-path"
+	probeHostKey               = "azure.ingress.kubernetes.io/health-probe-host"
+	probeIntervalKey           = "azure.ingress.kubernetes.io/health-probe-interval"
+	probeTimeoutKey            = "azure.ingress.kubernetes.io/health-probe-timeout"
+	probeUnhealthyThresholdKey = "azure.ingress.kubernetes.io/health-probe-unhealthy-threshold"
 )
 
 func (ingress ingressAnnotations) ingressClass() string {
@@ -26,31 +56,83 @@ func (ingress ingressAnnotations) ingressClass() string {
 	return val
 }
 
+func (ingress ingressAnnotations) backendAddressType() string {
+	val, ok := ingress[backendAddressTypeKey]
+	if !ok {
+		return podAddressType
+	}
+	return val
+}
+
+func (ingress ingressAnnotations) backendCA() string {
+	val, ok := ingress[backendCAKey]
+	if !ok {
+		return ""
+	}
+	return val
+}
+
 // enqueue enqueues ns/name of the given api object in the task queue.
 func (t *taskQueue) enqueue(obj interface{}) {
 	key, err := keyFunc(obj)
 	if err != nil {
-		glog.Infof("could not get key for object %+v: %v", obj, err)
+		logging.Infof("could not get key for object %v: %v", redact.Value(obj), redact.Value(err))
 		return
 	}
-	t.queue.Add(key)
+	t.workQueue().Add(key)
 }
 
-// shutdown shuts down the work queue and waits for the worker to ACK
+// shutdown shuts down the work queue and waits for the worker to ACK. A
+// queue whose worker never started, e.g. on a standby, returns right away.
 func (t *taskQueue) shutdown() {
-	t.queue.ShutDown()
-	<-t.workerDone
+	// a teardown shuts the queue down before Stop does, the queue panics
+	// when shut down twice
+	t.lock.Lock()
+	queue, workerDone, started, shutDown := t.queue, t.workerDone, t.started, t.shutDown
+	t.shutDown = true
+	t.lock.Unlock()
+	if !shutDown {
+		queue.ShutDown()
+	}
+	if started {
+		<-workerDone
+	}
+}
+
+// restart replaces a queue a failed teardown shut down. The worker picks the
+// new queue up the next time wait.Until runs it, the keys enqueued meanwhile
+// were dropped and have to be enqueued again.
+func (t *taskQueue) restart() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.queue = workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	t.workerDone = make(chan struct{})
+	t.shutDown = false
+}
+
+func (t *taskQueue) workQueue() workqueue.RateLimitingInterface {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.queue
 }
 
 // taskQueue manages a work queue through an independent worker that
 // invokes the given sync function for every work item inserted.
 type taskQueue struct {
-	// queue is the work queue the worker polls
-	queue workqueue.RateLimitingInterface
 	// sync is called for each item in the queue
 	sync func(string) error
-	// workerDone is closed when the worker exits
+
+	lock sync.Mutex
+	// queue is the work queue the worker polls, restart replaces it
+	queue workqueue.RateLimitingInterface
+	// workerDone is closed when the worker exits, shutdown only waits for
+	// it once the worker started
 	workerDone chan struct{}
+	shutDown   bool
+	// busySince is when the worker picked up the item it is syncing, it is
+	// zero while the worker waits for work
+	busySince time.Time
+	started   bool
 }
 
 func isAzureIngress(ingress *extensions.Ingress) bool {
@@ -68,46 +150,92 @@ func newTaskQueue(syncFn func(string) error) *taskQueue {
 	}
 }
 
+// run starts the worker in the background until stopCh is closed
 func (t *taskQueue) run(period time.Duration, stopCh <-chan struct{}) {
-	wait.Until(t.worker, period, stopCh)
+	t.lock.Lock()
+	t.started = true
+	t.lock.Unlock()
+
+	go wait.Until(t.worker, period, stopCh)
 }
 
 // worker processes work in the queue through sync.
 func (t *taskQueue) worker() {
+	t.lock.Lock()
+	queue, workerDone := t.queue, t.workerDone
+	t.lock.Unlock()
 	for {
-		key, quit := t.queue.Get()
+		key, quit := queue.Get()
 		if quit {
-			close(t.workerDone)
+			// wait.Until restarts the worker until stopCh is closed
+			t.lock.Lock()
+			select {
+			case <-workerDone:
+			default:
+				close(workerDone)
+			}
+			t.lock.Unlock()
 			return
 		}
-		glog.V(3).Infof("syncing %v", key)
-		if err := t.sync(key.(string)); err != nil {
-			glog.Warningf("requeuing %v, err %v", key, err)
-			t.queue.AddRateLimited(key.(string))
+		logging.V(3).Infof("syncing %v", key)
+		started := time.Now()
+		t.setBusySince(started)
+		err := t.sync(key.(string))
+		t.setBusySince(time.Time{})
+		observeSync(key.(string), time.Since(started), err)
+		if err != nil {
+			logging.Warningf("requeuing %v, err %v", key, redact.Value(err))
+			queueRetries.Inc()
+			queue.AddRateLimited(key.(string))
 		} else {
-			t.queue.Forget(key)
+			queue.Forget(key)
 		}
 
-		t.queue.Done(key)
+		queue.Done(key)
 	}
 }
 
-func newServicePrincipalToken(tenantID, clientID, clientSecret string) (*azure.ServicePrincipalToken, error) {
-	oauthConfig, err := azure.PublicCloud.OAuthConfigForTenant(tenantID)
-	if err != nil {
-		glog.Errorf("Error creating oauthConfig: %v", err)
-		return nil, err
+func (t *taskQueue) setBusySince(since time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.busySince = since
+}
+
+// busyFor returns how long the worker has been syncing its current item
+func (t *taskQueue) busyFor(now time.Time) time.Duration {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.busySince.IsZero() {
+		return 0
+	}
+	return now.Sub(t.busySince)
+}
+
+// newAuthorizer authenticates to the Active Directory of the environment with
+// the managed identity, the client certificate or the client secret of the
+// Azure settings
+func newAuthorizer(config credentials.Config, environment azure.Environment) (autorest.Authorizer, error) {
+	authentication := azurecontroller.AuthenticationConfig{
+		TenantID:            config.TenantID,
+		ClientID:            config.ClientID,
+		ClientSecret:        config.ClientSecret,
+		CertificatePassword: config.ClientCertificatePassword,
+		UseManagedIdentity:  config.UseManagedIdentity,
+		IdentityEndpoint:    *identityEndpoint,
+		Environment:         environment,
+	}
+	if config.ClientCertificatePath != "" && !config.UseManagedIdentity {
+		certificate, err := ioutil.ReadFile(config.ClientCertificatePath)
+		if err != nil {
+			return nil, fmt.Errorf("reading the client certificate: %v", err)
+		}
+		authentication.Certificate = certificate
 	}
 
-	servicePrincipalToken, err := azure.NewServicePrincipalToken(
-		*oauthConfig,
-		clientID,
-		clientSecret,
-		azure.PublicCloud.ServiceManagementEndpoint)
+	authorizer, err := azurecontroller.NewAuthorizer(authentication)
 	if err != nil {
-		glog.Errorf("Error creating servicePrincipalToken: %v", err)
+		logging.Errorf("Error creating the Azure authorizer: %v", redact.Value(err))
 		return nil, err
 	}
-
-	return servicePrincipalToken, err
+	return authorizer, nil
 }